@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -47,14 +48,37 @@ var transport = &http.Transport{
 	MaxIdleConnsPerHost:   5,
 }
 
+var httpClient = &http.Client{Transport: transport}
+var httpClientMutex sync.RWMutex
+
+// SetHTTPClient - sets the client the requests to the miners and the sharders
+// are sent with, the default client if nil. Its Transport can add TLS roots,
+// client certificates, a proxy or wrap the requests.
+func SetHTTPClient(client *http.Client) {
+	if client == nil {
+		client = &http.Client{Transport: transport}
+	}
+	httpClientMutex.Lock()
+	defer httpClientMutex.Unlock()
+	httpClient = client
+}
+
+// GetHTTPClient - the client the requests to the miners and the sharders are sent
+// with, as set by SetHTTPClient
+func GetHTTPClient() *http.Client {
+	httpClientMutex.RLock()
+	defer httpClientMutex.RUnlock()
+	return httpClient
+}
+
 func httpDo(req *http.Request, ctx context.Context, cncl context.CancelFunc, f func(*http.Response, error) error) error {
-	client := &http.Client{Transport: transport}
+	client := GetHTTPClient()
 	c := make(chan error, 1)
+	// The request is aborted when ctx is done
 	go func() { c <- f(client.Do(req.WithContext(ctx))) }()
 	defer cncl()
 	select {
 	case <-ctx.Done():
-		transport.CancelRequest(req)
 		<-c // Wait for f to return.
 		return ctx.Err()
 	case err := <-c:
@@ -0,0 +1,55 @@
+package allocationchange
+
+import (
+	"strings"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+)
+
+type DirCreateChange struct {
+	change
+	RemotePath string
+}
+
+func (ch *DirCreateChange) ProcessChange(rootRef *fileref.Ref) error {
+	tSubDirs := getSubDirs(ch.RemotePath)
+	dirRef := rootRef
+	for treelevel := 0; treelevel < len(tSubDirs); treelevel++ {
+		found := false
+		for _, child := range dirRef.Children {
+			if child.GetName() != tSubDirs[treelevel] {
+				continue
+			}
+			if child.GetType() != fileref.DIRECTORY {
+				return common.NewError("invalid_path", "A file exists at the path "+child.GetPath())
+			}
+			dirRef = child.(*fileref.Ref)
+			found = true
+			break
+		}
+		if found {
+			continue
+		}
+		newRef := &fileref.Ref{}
+		newRef.Type = fileref.DIRECTORY
+		newRef.AllocationID = dirRef.AllocationID
+		newRef.Path = "/" + strings.Join(tSubDirs[:treelevel+1], "/")
+		newRef.Name = tSubDirs[treelevel]
+		newRef.LookupHash = fileref.GetReferenceLookup(newRef.AllocationID, newRef.Path)
+		// Hashed by CalculateHash as a directory without children
+		newRef.SetChildrenLoaded()
+		dirRef.AddChild(newRef)
+		dirRef = newRef
+	}
+	rootRef.CalculateHash()
+	return nil
+}
+
+func (n *DirCreateChange) GetAffectedPath() string {
+	return n.RemotePath
+}
+
+func (n *DirCreateChange) GetSize() int64 {
+	return 0
+}
@@ -0,0 +1,460 @@
+// Package blobbertest - in-memory blobbers serving the endpoints the SDK calls,
+// for testing the code built on zboxcore/sdk without a network
+package blobbertest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/core/encryption"
+	"github.com/0chain/gosdk/core/zcncrypto"
+	"github.com/0chain/gosdk/zboxcore/blockchain"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/marker"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+const maxFormMemory = 32 << 20
+
+// Config - the allocation the blobbers store
+type Config struct {
+	AllocationID    string
+	OwnerID         string
+	OwnerPublicKey  string
+	SignatureScheme string
+	// The blobbers take the shards of the uploads in parts, as they tell the
+	// SDK in zboxutil.UPLOAD_CAPABILITIES_HEADER. Otherwise each upload
+	// request carries the whole shard.
+	ChunkedUploads bool
+}
+
+// Blobber - a blobber of one allocation, keeping the reference tree, the
+// shards and the markers in memory
+type Blobber struct {
+	ID     string
+	config Config
+	server *httptest.Server
+
+	mutex       sync.Mutex
+	root        *fileref.Ref
+	content     map[string][]byte
+	thumbnails  map[string][]byte
+	connections map[string]*connection
+	latestWM    *marker.WriteMarker
+	readMarkers map[string]*marker.ReadMarker
+	stats       map[string]*fileStats
+}
+
+// connection - the changes uploaded and not committed yet
+type connection struct {
+	ID      string              `json:"connection_id"`
+	Size    int64               `json:"size"`
+	Changes []*connectionChange `json:"changes"`
+}
+
+type connectionChange struct {
+	Operation string `json:"operation"`
+	Size      int64  `json:"size"`
+	Input     string `json:"input"`
+	path      string
+	newName   string
+	dest      string
+	file      *fileref.FileRef
+	// Part of the shard received of an upload not complete yet
+	data []byte
+}
+
+type fileStats struct {
+	NumUpdates        int64
+	NumBlockDownloads int64
+}
+
+// NewBlobber - starts a blobber of the allocation, closed by Close
+func NewBlobber(id string, config Config) *Blobber {
+	b := &Blobber{
+		ID:          id,
+		config:      config,
+		root:        newRootRef(config.AllocationID),
+		content:     make(map[string][]byte),
+		thumbnails:  make(map[string][]byte),
+		connections: make(map[string]*connection),
+		readMarkers: make(map[string]*marker.ReadMarker),
+		stats:       make(map[string]*fileStats),
+	}
+	mux := http.NewServeMux()
+	b.handle(mux, zboxutil.UPLOAD_ENDPOINT, true, b.uploadHandler)
+	b.handle(mux, zboxutil.RENAME_ENDPOINT, true, b.renameHandler)
+	b.handle(mux, zboxutil.COPY_ENDPOINT, true, b.copyHandler)
+	b.handle(mux, zboxutil.DIR_ENDPOINT, true, b.createDirHandler)
+	b.handle(mux, zboxutil.CONNECTION_ENDPOINT, true, b.connectionHandler)
+	b.handle(mux, zboxutil.COMMIT_ENDPOINT, true, b.commitHandler)
+	b.handle(mux, zboxutil.REFERENCE_ENDPOINT, true, b.referencePathHandler)
+	b.handle(mux, zboxutil.OBJECT_TREE_ENDPOINT, true, b.objectTreeHandler)
+	b.handle(mux, zboxutil.LIST_ENDPOINT, false, b.listHandler)
+	b.handle(mux, zboxutil.FILE_META_ENDPOINT, false, b.fileMetaHandler)
+	b.handle(mux, zboxutil.FILE_STATS_ENDPOINT, true, b.fileStatsHandler)
+	b.handle(mux, zboxutil.DOWNLOAD_ENDPOINT, false, b.downloadHandler)
+	b.server = httptest.NewServer(mux)
+	return b
+}
+
+func newRootRef(allocationID string) *fileref.Ref {
+	root := &fileref.Ref{Type: fileref.DIRECTORY}
+	root.AllocationID = allocationID
+	root.Name = "/"
+	root.Path = "/"
+	root.LookupHash = fileref.GetReferenceLookup(allocationID, "/")
+	return root
+}
+
+// URL - base URL of the blobber
+func (b *Blobber) URL() string {
+	return b.server.URL
+}
+
+// StorageNode - the blobber as the allocations list it
+func (b *Blobber) StorageNode() *blockchain.StorageNode {
+	return &blockchain.StorageNode{ID: b.ID, Baseurl: b.server.URL}
+}
+
+func (b *Blobber) Close() {
+	b.server.Close()
+}
+
+// LatestWriteMarker - the write marker of the last commit, nil before the
+// first commit
+func (b *Blobber) LatestWriteMarker() *marker.WriteMarker {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.latestWM
+}
+
+// AllocationRoot - hash of the reference tree as the write markers sign it
+func (b *Blobber) AllocationRoot() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.latestWM == nil {
+		return ""
+	}
+	return b.latestWM.AllocationRoot
+}
+
+// GetRef - the committed reference at the path, nil if there is none
+func (b *Blobber) GetRef(remotePath string) fileref.RefEntity {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return findRef(b.root, remotePath)
+}
+
+// ReadCounter - blocks read by the client so far
+func (b *Blobber) ReadCounter(clientID string) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if rm, ok := b.readMarkers[clientID]; ok {
+		return rm.ReadCounter
+	}
+	return 0
+}
+
+// PendingConnections - number of connections with changes not committed
+func (b *Blobber) PendingConnections() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.connections)
+}
+
+// CorruptShard - commits a copy of the shard of the file with a bit flipped,
+// as a blobber sent a wrong shard would hold it. The hashes of the file
+// reference match the corrupt shard and sign signs the write marker of the
+// commit as the owner, e.g. client.Sign.
+func (b *Blobber) CorruptShard(remotePath string, sign func(hash string) (string, error)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	file, ok := findRef(b.root, remotePath).(*fileref.FileRef)
+	if !ok || len(b.content[file.ContentHash]) == 0 {
+		return common.NewError("file_not_found", "No shard of "+remotePath)
+	}
+	data := append([]byte{}, b.content[file.ContentHash]...)
+	data[0] ^= 1
+	contentHash := sha1.Sum(data)
+	file.ContentHash = hex.EncodeToString(contentHash[:])
+	file.MerkleRoot = computeMerkleRoot(data)
+	b.content[file.ContentHash] = data
+	return b.commitRoot(sign)
+}
+
+// RemoveFile - commits the tree without the file, as a blobber that lost it
+// would hold it. sign signs the write marker of the commit as the owner.
+func (b *Blobber) RemoveFile(remotePath string, sign func(hash string) (string, error)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	dir, ok := findRef(b.root, path.Dir(remotePath)).(*fileref.Ref)
+	if !ok {
+		return common.NewError("file_not_found", "No directory of "+remotePath)
+	}
+	for i, child := range dir.Children {
+		if child.GetName() == path.Base(remotePath) {
+			dir.Children = append(dir.Children[:i], dir.Children[i+1:]...)
+			return b.commitRoot(sign)
+		}
+	}
+	return common.NewError("file_not_found", "No reference of "+remotePath)
+}
+
+// commitRoot - signs a write marker for the current root as the latest one
+func (b *Blobber) commitRoot(sign func(hash string) (string, error)) error {
+	b.root.CalculateHash()
+	wm := &marker.WriteMarker{}
+	wm.Timestamp = common.Now()
+	wm.AllocationRoot = encryption.Hash(b.root.Hash + ":" + strconv.FormatInt(wm.Timestamp, 10))
+	if b.latestWM != nil {
+		wm.PreviousAllocationRoot = b.latestWM.AllocationRoot
+	}
+	wm.AllocationID = b.config.AllocationID
+	wm.BlobberID = b.ID
+	wm.ClientID = b.config.OwnerID
+	var err error
+	wm.Signature, err = sign(wm.GetHash())
+	if err != nil {
+		return err
+	}
+	b.latestWM = wm
+	return nil
+}
+
+type handlerFunc func(r *http.Request, clientID string) (interface{}, error)
+
+type requestError struct {
+	status int
+	err    *common.Error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(code string, msg string) error {
+	return &requestError{status: http.StatusBadRequest, err: common.NewError(code, msg)}
+}
+
+func unauthorized(msg string) error {
+	return &requestError{status: http.StatusUnauthorized, err: common.NewError("invalid_client", msg)}
+}
+
+// handle - registers the handler of the endpoint of the allocation. The
+// handlers of ownerOnly endpoints serve only the owner, the others check the
+// client themselves.
+func (b *Blobber) handle(mux *http.ServeMux, endpoint string, ownerOnly bool, h handlerFunc) {
+	mux.HandleFunc(endpoint, func(w http.ResponseWriter, r *http.Request) {
+		var response interface{}
+		var err error
+		clientID := r.Header.Get("X-App-Client-ID")
+		if b.config.ChunkedUploads {
+			w.Header().Set(zboxutil.UPLOAD_CAPABILITIES_HEADER, zboxutil.CHUNKED_UPLOAD_CAPABILITY)
+		}
+		if strings.TrimPrefix(r.URL.Path, endpoint) != b.config.AllocationID {
+			err = badRequest("invalid_allocation", "Unknown allocation "+strings.TrimPrefix(r.URL.Path, endpoint))
+		} else if ownerOnly && clientID != b.config.OwnerID {
+			err = unauthorized("Operation needs to be performed by the owner of the allocation")
+		} else {
+			response, err = h(r, clientID)
+		}
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+		if data, ok := response.([]byte); ok {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(data)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+func respondError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	cerr, ok := err.(*common.Error)
+	if rerr, isReqErr := err.(*requestError); isReqErr {
+		status = rerr.status
+		cerr, ok = rerr.err, true
+	}
+	if !ok {
+		cerr = common.NewError("internal_error", err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": cerr.Code, "error": cerr.Msg})
+}
+
+// verifySignature - checks the signature of the hash with the public key, in
+// the signature scheme of the allocation
+func (b *Blobber) verifySignature(publicKey string, signature string, hash string) error {
+	ss := zcncrypto.NewSignatureScheme(b.config.SignatureScheme)
+	err := ss.SetPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	ok, err := ss.Verify(signature, hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return common.NewError("invalid_signature", "Signature is not valid")
+	}
+	return nil
+}
+
+// validateAuthTicket - checks that the ticket shares the reference with the
+// client
+func (b *Blobber) validateAuthTicket(authToken string, clientID string, ref fileref.RefEntity) error {
+	at := &marker.AuthTicket{}
+	err := json.Unmarshal([]byte(authToken), at)
+	if err != nil {
+		return badRequest("invalid_parameters", "Invalid auth ticket. "+err.Error())
+	}
+	if at.AllocationID != b.config.AllocationID || at.OwnerID != b.config.OwnerID {
+		return unauthorized("Auth ticket is not for this allocation")
+	}
+	if at.Expiration > 0 && at.Expiration < common.Now() {
+		return unauthorized("Auth ticket expired")
+	}
+	if len(at.ClientID) > 0 && at.ClientID != clientID {
+		return unauthorized("Auth ticket is not for this client")
+	}
+	err = b.verifySignature(b.config.OwnerPublicKey, at.Signature, encryption.Hash(at.GetHashData()))
+	if err != nil {
+		return unauthorized("Invalid auth ticket. " + err.Error())
+	}
+	shared := findRefByLookup(b.root, at.FilePathHash)
+	if shared == nil {
+		return unauthorized("Shared path not found")
+	}
+	if shared.GetPath() == ref.GetPath() {
+		return nil
+	}
+	if at.RefType == fileref.DIRECTORY && shared.GetType() == fileref.DIRECTORY && isSameOrUnder(ref.GetPath(), shared.GetPath()) {
+		return nil
+	}
+	return unauthorized("Auth ticket doesn't share the path")
+}
+
+// authorizeRead - lets the owner and the clients with an auth ticket of the
+// reference read it
+func (b *Blobber) authorizeRead(r *http.Request, clientID string, ref fileref.RefEntity) error {
+	authToken := r.FormValue("auth_token")
+	if len(authToken) > 0 {
+		return b.validateAuthTicket(authToken, clientID, ref)
+	}
+	if clientID != b.config.OwnerID {
+		return unauthorized("Operation needs to be performed by the owner or the payer of the allocation")
+	}
+	return nil
+}
+
+func isSameOrUnder(p string, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// findRef - the reference at the path under the root
+func findRef(root *fileref.Ref, remotePath string) fileref.RefEntity {
+	var ref fileref.RefEntity = root
+	for _, name := range strings.Split(path.Clean(remotePath), "/") {
+		if name == "" {
+			continue
+		}
+		dir, ok := ref.(*fileref.Ref)
+		if !ok || dir.Type != fileref.DIRECTORY {
+			return nil
+		}
+		ref = nil
+		for _, child := range dir.Children {
+			if child.GetName() == name {
+				ref = child
+				break
+			}
+		}
+		if ref == nil {
+			return nil
+		}
+	}
+	return ref
+}
+
+// findRefByLookup - the reference with the lookup hash under the root
+func findRefByLookup(root *fileref.Ref, lookupHash string) fileref.RefEntity {
+	if fileref.GetReferenceLookup(root.AllocationID, root.Path) == lookupHash {
+		return root
+	}
+	for _, child := range root.Children {
+		if dir, ok := child.(*fileref.Ref); ok {
+			if ref := findRefByLookup(dir, lookupHash); ref != nil {
+				return ref
+			}
+			continue
+		}
+		if fileref.GetReferenceLookup(root.AllocationID, child.GetPath()) == lookupHash {
+			return child
+		}
+	}
+	return nil
+}
+
+// getMeta - the reference as the blobber lists it
+func getMeta(ref fileref.RefEntity) map[string]interface{} {
+	meta := make(map[string]interface{})
+	data, _ := json.Marshal(ref)
+	_ = json.Unmarshal(data, &meta)
+	return meta
+}
+
+// toReferencePath - the reference with the children of the directories
+// expand returns true for
+func toReferencePath(ref fileref.RefEntity, expand func(dir *fileref.Ref) bool) *fileref.ReferencePath {
+	rp := &fileref.ReferencePath{Meta: getMeta(ref)}
+	dir, ok := ref.(*fileref.Ref)
+	if !ok || !expand(dir) {
+		return rp
+	}
+	rp.List = make([]*fileref.ReferencePath, 0, len(dir.Children))
+	for _, child := range dir.Children {
+		rp.List = append(rp.List, toReferencePath(child, expand))
+	}
+	return rp
+}
+
+func expandAll(dir *fileref.Ref) bool {
+	return true
+}
+
+// cloneTree - a copy of the tree, decoded the way the SDK decodes the
+// reference paths so that the hashes come out the same
+func cloneTree(root *fileref.Ref) (*fileref.Ref, error) {
+	return toReferencePath(root, expandAll).GetDirTree(root.AllocationID)
+}
+
+func cloneRef(ref fileref.RefEntity, allocationID string) (fileref.RefEntity, error) {
+	return toReferencePath(ref, expandAll).GetRefFromObjectTree(allocationID)
+}
+
+// updateLookupHashes - sets the lookup hashes after the paths changed
+func updateLookupHashes(dir *fileref.Ref) {
+	dir.LookupHash = fileref.GetReferenceLookup(dir.AllocationID, dir.Path)
+	for _, child := range dir.Children {
+		switch ref := child.(type) {
+		case *fileref.Ref:
+			updateLookupHashes(ref)
+		case *fileref.FileRef:
+			ref.LookupHash = fileref.GetReferenceLookup(ref.AllocationID, ref.Path)
+		}
+	}
+}
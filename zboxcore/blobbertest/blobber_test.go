@@ -0,0 +1,213 @@
+package blobbertest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/core/zcncrypto"
+	"github.com/0chain/gosdk/zboxcore/blobbertest"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/marker"
+	"github.com/0chain/gosdk/zboxcore/sdk"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+const signatureScheme = "ed25519"
+
+func newAllocation(t *testing.T, allocationID string) (*sdk.Allocation, *blobbertest.Network) {
+	wallet, walletJSON, err := blobbertest.NewWallet(signatureScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sdk.InitStorageSDK(walletJSON, nil, nil, "", signatureScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network := blobbertest.NewNetwork(3, blobbertest.NewConfig(allocationID, wallet, signatureScheme))
+	a := &sdk.Allocation{}
+	err = json.Unmarshal(network.AllocationJSON(2), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.InitAllocation()
+	return a, network
+}
+
+func TestAllocationOperations(t *testing.T) {
+	a, network := newAllocation(t, "allocation_operations")
+	defer network.Close()
+	dir, err := ioutil.TempDir("", "blobbertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 3*fileref.CHUNK_SIZE+1000)
+	rand.Read(content)
+	localPath := filepath.Join(dir, "a.bin")
+	err = ioutil.WriteFile(localPath, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, err = a.UploadFileCtx(ctx, localPath, "/docs/a.bin", nil)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	for i, b := range network.Blobbers {
+		if b.GetRef("/docs/a.bin") == nil || b.LatestWriteMarker() == nil {
+			t.Fatalf("blobber %d didn't commit the upload", i)
+		}
+	}
+
+	list, err := a.ListDir("/docs")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list.Children) != 1 || list.Children[0].Name != "a.bin" || list.Children[0].ActualSize != int64(len(content)) {
+		t.Fatalf("unexpected list %+v", list.Children)
+	}
+
+	downloadPath := filepath.Join(dir, "downloaded.bin")
+	err = a.DownloadFileCtx(ctx, downloadPath, "/docs/a.bin", nil)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatal("downloaded content differs from the upload")
+	}
+
+	ticket, err := a.GetAuthTicketForShare("/docs/a.bin", "a.bin", fileref.FILE, "")
+	if err != nil {
+		t.Fatalf("auth ticket: %v", err)
+	}
+	sharedPath := filepath.Join(dir, "shared.bin")
+	err = a.DownloadFromAuthTicketCtx(ctx, sharedPath, ticket, fileref.GetReferenceLookup(a.ID, "/docs/a.bin"), "a.bin", nil)
+	if err != nil {
+		t.Fatalf("download from auth ticket: %v", err)
+	}
+	downloaded, err = ioutil.ReadFile(sharedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatal("shared content differs from the upload")
+	}
+
+	err = a.RenameObject("/docs/a.bin", "b.bin")
+	if err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	err = a.CreateDir("/backup")
+	if err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+	err = a.CopyObject("/docs/b.bin", "/backup")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	err = a.DeleteFile("/docs/b.bin")
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	for i, b := range network.Blobbers {
+		if b.GetRef("/docs/a.bin") != nil || b.GetRef("/docs/b.bin") != nil {
+			t.Fatalf("blobber %d kept the renamed or deleted file", i)
+		}
+		if b.GetRef("/backup/b.bin") == nil {
+			t.Fatalf("blobber %d is missing the copy", i)
+		}
+		if b.PendingConnections() != 0 {
+			t.Fatalf("blobber %d has uncommitted connections", i)
+		}
+	}
+
+	err = a.DownloadFileCtx(ctx, filepath.Join(dir, "copy.bin"), "/backup/b.bin", nil)
+	if err != nil {
+		t.Fatalf("download of the copy: %v", err)
+	}
+	downloaded, err = ioutil.ReadFile(filepath.Join(dir, "copy.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatal("downloaded copy differs from the upload")
+	}
+}
+
+func postForm(t *testing.T, url string, clientID string, fields map[string]string) *http.Response {
+	body := new(bytes.Buffer)
+	formWriter := multipart.NewWriter(body)
+	for k, v := range fields {
+		formWriter.WriteField(k, v)
+	}
+	formWriter.Close()
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", formWriter.FormDataContentType())
+	req.Header.Set("X-App-Client-ID", clientID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCommitValidatesWriteMarker(t *testing.T) {
+	allocationID := "commit_validation"
+	owner, _, err := blobbertest.NewWallet(signatureScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := blobbertest.NewWallet(signatureScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blobbertest.NewBlobber("blobber", blobbertest.NewConfig(allocationID, owner, signatureScheme))
+	defer b.Close()
+
+	resp := postForm(t, b.URL()+zboxutil.DIR_ENDPOINT+allocationID, other.ClientID, map[string]string{"connection_id": "c1", "dir_path": "/a"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("create dir by another client: status %d", resp.StatusCode)
+	}
+	resp = postForm(t, b.URL()+zboxutil.DIR_ENDPOINT+allocationID, owner.ClientID, map[string]string{"connection_id": "c1", "dir_path": "/a"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("create dir: status %d", resp.StatusCode)
+	}
+
+	wm := &marker.WriteMarker{AllocationID: allocationID, BlobberID: b.ID, ClientID: owner.ClientID, Timestamp: common.Now()}
+	commit := func(signer *zcncrypto.Wallet) int {
+		ss := zcncrypto.NewSignatureScheme(signatureScheme)
+		ss.SetPrivateKey(signer.Keys[0].PrivateKey)
+		wm.Signature, _ = ss.Sign(wm.GetHash())
+		wmData, _ := json.Marshal(wm)
+		return postForm(t, b.URL()+zboxutil.COMMIT_ENDPOINT+allocationID, owner.ClientID, map[string]string{"connection_id": "c1", "write_marker": string(wmData)}).StatusCode
+	}
+	// Neither the allocation root of the changes nor the signature of the owner
+	wm.AllocationRoot = "root"
+	if status := commit(other); status != http.StatusBadRequest {
+		t.Fatalf("commit signed by another client: status %d", status)
+	}
+	if status := commit(owner); status != http.StatusBadRequest {
+		t.Fatalf("commit with a wrong allocation root: status %d", status)
+	}
+	if b.GetRef("/a") != nil || b.PendingConnections() != 1 || b.LatestWriteMarker() != nil {
+		t.Fatal("rejected commit changed the blobber")
+	}
+}
@@ -0,0 +1,245 @@
+package blobbertest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+// Fault - faults injected into the requests to a blobber
+type Fault struct {
+	// Operations the fault applies to, as zboxutil.GetOperation tells them
+	// apart, all if empty
+	Operations []string
+	// Latency added before the request is sent
+	Latency time.Duration
+	// Fraction of the requests answered with ErrorStatus without reaching the
+	// blobber, or failed with a connection reset if ErrorStatus is 0
+	ErrorRate   float64
+	ErrorStatus int
+	// Fraction of the responses whose body fails with io.ErrUnexpectedEOF
+	// after TruncateAt bytes, half the body if 0
+	TruncateRate float64
+	TruncateAt   int
+	// Fraction of the responses with one bit of the body flipped
+	BitFlipRate float64
+}
+
+func (f *Fault) appliesTo(operation string) bool {
+	if len(f.Operations) == 0 {
+		return true
+	}
+	for _, op := range f.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// FaultInjector - an http.RoundTripper injecting the faults set for the
+// blobbers into the requests to them. Install it with
+// zboxutil.SetHTTPClient(injector.Client()).
+type FaultInjector struct {
+	transport http.RoundTripper
+
+	mutex    sync.Mutex
+	rand     *rand.Rand
+	faults   map[string][]Fault
+	injected map[string]int
+}
+
+// NewFaultInjector - an injector sending the requests with the transport,
+// http.DefaultTransport if nil. The seed makes the faults of a sequence of
+// requests reproducible.
+func NewFaultInjector(transport http.RoundTripper, seed int64) *FaultInjector {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &FaultInjector{
+		transport: transport,
+		rand:      rand.New(rand.NewSource(seed)),
+		faults:    make(map[string][]Fault),
+		injected:  make(map[string]int),
+	}
+}
+
+// Client - a client sending the requests through the injector
+func (fi *FaultInjector) Client() *http.Client {
+	return &http.Client{Transport: fi}
+}
+
+// SetFaults - replaces the faults of the blobber at the base URL, none clears
+// them
+func (fi *FaultInjector) SetFaults(blobberURL string, faults ...Fault) {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	fi.faults[hostOf(blobberURL)] = faults
+}
+
+// Clear - clears the faults of all the blobbers and the counts of the
+// injected ones
+func (fi *FaultInjector) Clear() {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	fi.faults = make(map[string][]Fault)
+	fi.injected = make(map[string]int)
+}
+
+// Injected - number of the requests to the blobber a fault other than the
+// latency was injected into
+func (fi *FaultInjector) Injected(blobberURL string) int {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	return fi.injected[hostOf(blobberURL)]
+}
+
+func hostOf(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return baseURL
+	}
+	return u.Host
+}
+
+// injection - what is done to one request
+type injection struct {
+	latency    time.Duration
+	errStatus  int
+	reset      bool
+	truncateAt int
+	truncate   bool
+	bitFlip    bool
+	flipSeed   int64
+}
+
+func (fi *FaultInjector) draw(req *http.Request) *injection {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	host := req.URL.Host
+	faults := fi.faults[host]
+	if len(faults) == 0 {
+		return nil
+	}
+	operation := zboxutil.GetOperation(req)
+	inj := &injection{}
+	injected := false
+	for i := range faults {
+		f := &faults[i]
+		if !f.appliesTo(operation) {
+			continue
+		}
+		inj.latency += f.Latency
+		if !injected && fi.rand.Float64() < f.ErrorRate {
+			inj.errStatus, inj.reset = f.ErrorStatus, f.ErrorStatus == 0
+			injected = true
+		}
+		if !injected && fi.rand.Float64() < f.TruncateRate {
+			inj.truncate, inj.truncateAt = true, f.TruncateAt
+			injected = true
+		}
+		if !injected && fi.rand.Float64() < f.BitFlipRate {
+			inj.bitFlip, inj.flipSeed = true, fi.rand.Int63()
+			injected = true
+		}
+	}
+	if injected {
+		fi.injected[host]++
+	}
+	return inj
+}
+
+// RoundTrip - sends the request with the faults of its blobber
+func (fi *FaultInjector) RoundTrip(req *http.Request) (*http.Response, error) {
+	inj := fi.draw(req)
+	if inj == nil {
+		return fi.transport.RoundTrip(req)
+	}
+	if inj.latency > 0 {
+		select {
+		case <-req.Context().Done():
+			closeBody(req)
+			return nil, req.Context().Err()
+		case <-time.After(inj.latency):
+		}
+	}
+	if inj.reset {
+		closeBody(req)
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by injected fault")}
+	}
+	if inj.errStatus > 0 {
+		closeBody(req)
+		body := fmt.Sprintf(`{"code":"injected_fault","error":"%s"}`, http.StatusText(inj.errStatus))
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", inj.errStatus, http.StatusText(inj.errStatus)),
+			StatusCode:    inj.errStatus,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{"application/json"}},
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	resp, err := fi.transport.RoundTrip(req)
+	if err != nil || (!inj.truncate && !inj.bitFlip) {
+		return resp, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if inj.bitFlip && len(data) > 0 {
+		r := rand.New(rand.NewSource(inj.flipSeed))
+		data[r.Intn(len(data))] ^= 1 << uint(r.Intn(8))
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if inj.truncate {
+		n := inj.truncateAt
+		if n <= 0 || n > len(data) {
+			n = len(data) / 2
+		}
+		resp.Body = &truncatedBody{data: data[:n]}
+	}
+	// The length the body ends at isn't known ahead
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// truncatedBody - a body dropped mid-stream
+type truncatedBody struct {
+	data []byte
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if len(b.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+func (b *truncatedBody) Close() error {
+	return nil
+}
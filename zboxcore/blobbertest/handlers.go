@@ -0,0 +1,695 @@
+package blobbertest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0chain/gosdk/core/encryption"
+	"github.com/0chain/gosdk/core/util"
+	"github.com/0chain/gosdk/zboxcore/allocationchange"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/marker"
+	"golang.org/x/crypto/sha3"
+)
+
+const downloadContentThumb = "thumbnail"
+
+type uploadFormData struct {
+	ConnectionID        string `json:"connection_id"`
+	Filename            string `json:"filename"`
+	Path                string `json:"filepath"`
+	Hash                string `json:"content_hash,omitempty"`
+	ThumbnailHash       string `json:"thumbnail_content_hash,omitempty"`
+	MerkleRoot          string `json:"merkle_root,omitempty"`
+	ActualHash          string `json:"actual_hash"`
+	ActualSize          int64  `json:"actual_size"`
+	ActualThumbnailSize int64  `json:"actual_thumb_size"`
+	ActualThumbnailHash string `json:"actual_thumb_hash"`
+	MimeType            string `json:"mimetype"`
+	CustomMeta          string `json:"custom_meta,omitempty"`
+	EncryptedKey        string `json:"encrypted_key,omitempty"`
+	ChunkIndex          int64  `json:"chunk_index,omitempty"`
+	UploadOffset        int64  `json:"upload_offset,omitempty"`
+	IsFinal             bool   `json:"is_final,omitempty"`
+}
+
+type uploadResult struct {
+	Filename     string `json:"filename"`
+	ShardSize    int64  `json:"size"`
+	Hash         string `json:"content_hash,omitempty"`
+	MerkleRoot   string `json:"merkle_root,omitempty"`
+	UploadLength int64  `json:"upload_length,omitempty"`
+}
+
+type referencePathResult struct {
+	*fileref.ReferencePath
+	LatestWM *marker.WriteMarker `json:"latest_write_marker"`
+}
+
+type fileStatsResult struct {
+	Name              string `json:"name"`
+	Size              int64  `json:"size"`
+	PathHash          string `json:"path_hash"`
+	Path              string `json:"path"`
+	NumBlocks         int64  `json:"num_of_blocks"`
+	NumUpdates        int64  `json:"num_of_updates"`
+	NumBlockDownloads int64  `json:"num_of_block_downloads"`
+	BlobberID         string `json:"blobber_id"`
+	BlobberURL        string `json:"blobber_url"`
+}
+
+type downloadFailure struct {
+	Success  bool               `json:"success"`
+	LatestRM *marker.ReadMarker `json:"latest_rm"`
+}
+
+func isValidPath(p string) bool {
+	return strings.HasPrefix(p, "/") && path.Clean(p) == p
+}
+
+func parseForm(r *http.Request, fields ...string) error {
+	err := r.ParseMultipartForm(maxFormMemory)
+	if err != nil {
+		return badRequest("invalid_parameters", "Invalid form. "+err.Error())
+	}
+	for _, field := range fields {
+		if len(r.FormValue(field)) == 0 {
+			return badRequest("invalid_parameters", "Missing "+field)
+		}
+	}
+	return nil
+}
+
+func readFormFile(r *http.Request, field string) ([]byte, error) {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// formInput - the form fields as the connection details list them
+func formInput(r *http.Request, fields ...string) string {
+	input := make(map[string]string)
+	for _, field := range fields {
+		input[field] = r.FormValue(field)
+	}
+	data, _ := json.Marshal(input)
+	return string(data)
+}
+
+// computeMerkleRoot - root of the merkle tree of the shard, with a leaf per
+// 64 bytes offset of the chunks as the SDK computes it
+func computeMerkleRoot(data []byte) string {
+	merkleHashes := make([]hash.Hash, 1024)
+	for idx := range merkleHashes {
+		merkleHashes[idx] = sha3.New256()
+	}
+	for start := 0; start < len(data); start += fileref.CHUNK_SIZE {
+		end := start + fileref.CHUNK_SIZE
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[start:end]
+		for i := 0; i < len(chunk); i += 64 {
+			e := i + 64
+			if e > len(chunk) {
+				e = len(chunk)
+			}
+			merkleHashes[i/64].Write(chunk[i:e])
+		}
+	}
+	merkleLeaves := make([]util.Hashable, len(merkleHashes))
+	for idx := range merkleHashes {
+		merkleLeaves[idx] = util.NewStringHashable(hex.EncodeToString(merkleHashes[idx].Sum(nil)))
+	}
+	var mt util.MerkleTreeI = &util.MerkleTree{}
+	mt.ComputeTree(merkleLeaves)
+	return mt.GetRoot()
+}
+
+// addChange - adds the change to the connection, opening it if needed
+func (b *Blobber) addChange(connectionID string, change *connectionChange) {
+	conn, ok := b.connections[connectionID]
+	if !ok {
+		conn = &connection{ID: connectionID, Changes: make([]*connectionChange, 0)}
+		b.connections[connectionID] = conn
+	}
+	conn.Changes = append(conn.Changes, change)
+	conn.Size += change.Size
+}
+
+func (b *Blobber) uploadHandler(r *http.Request, clientID string) (interface{}, error) {
+	if r.Method == http.MethodDelete {
+		return b.deleteHandler(r)
+	}
+	isUpdate := r.Method == http.MethodPut
+	metaField := "uploadMeta"
+	if isUpdate {
+		metaField = "updateMeta"
+	}
+	err := parseForm(r, "connection_id", metaField)
+	if err != nil {
+		return nil, err
+	}
+	formData := &uploadFormData{}
+	err = json.Unmarshal([]byte(r.FormValue(metaField)), formData)
+	if err != nil {
+		return nil, badRequest("invalid_parameters", "Invalid upload meta. "+err.Error())
+	}
+	if !b.config.ChunkedUploads {
+		// The request carries the whole shard
+		formData.UploadOffset = 0
+		formData.IsFinal = true
+	}
+	if !isValidPath(formData.Path) || formData.Path == "/" || path.Base(formData.Path) != formData.Filename {
+		return nil, badRequest("invalid_parameters", "Invalid file path "+formData.Path)
+	}
+	data, err := readFormFile(r, "uploadFile")
+	if err != nil {
+		return nil, badRequest("invalid_parameters", "Missing the file. "+err.Error())
+	}
+	thumbnail, err := readFormFile(r, "uploadThumbnailFile")
+	if err != nil && err != http.ErrMissingFile {
+		return nil, badRequest("invalid_parameters", "Invalid thumbnail. "+err.Error())
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	existing := findRef(b.root, formData.Path)
+	if isUpdate && (existing == nil || existing.GetType() != fileref.FILE) {
+		return nil, badRequest("file_not_found", "File to update not found "+formData.Path)
+	}
+	if !isUpdate && existing != nil {
+		return nil, badRequest("duplicate_file", "File already exists at "+formData.Path)
+	}
+	connectionID := r.FormValue("connection_id")
+	// The shard comes in parts, each continuing the one before
+	change := b.pendingUpload(connectionID, formData.Path)
+	if formData.UploadOffset == 0 {
+		b.removeChange(connectionID, change)
+		change = &connectionChange{Operation: allocationchange.INSERT_OPERATION, path: formData.Path}
+		if isUpdate {
+			change.Operation = allocationchange.UPDATE_OPERATION
+		}
+		b.addChange(connectionID, change)
+	} else if change == nil || int64(len(change.data)) != formData.UploadOffset {
+		return nil, badRequest("upload_offset_mismatch", "Upload offset doesn't match the part of the shard received")
+	}
+	change.data = append(change.data, data...)
+	change.Input = r.FormValue(metaField)
+	if !formData.IsFinal {
+		return &uploadResult{Filename: formData.Filename, UploadLength: int64(len(change.data))}, nil
+	}
+
+	data = change.data
+	contentHash := sha1.Sum(data)
+	if hex.EncodeToString(contentHash[:]) != formData.Hash {
+		b.removeChange(connectionID, change)
+		return nil, badRequest("content_hash_mismatch", "Content hash doesn't match the shard")
+	}
+	if computeMerkleRoot(data) != formData.MerkleRoot {
+		b.removeChange(connectionID, change)
+		return nil, badRequest("merkle_root_mismatch", "Merkle root doesn't match the shard")
+	}
+	if thumbnail != nil {
+		thumbnailHash := sha1.Sum(thumbnail)
+		if hex.EncodeToString(thumbnailHash[:]) != formData.ThumbnailHash {
+			b.removeChange(connectionID, change)
+			return nil, badRequest("content_hash_mismatch", "Content hash doesn't match the thumbnail")
+		}
+	}
+	change.Size = int64(len(data))
+	if isUpdate {
+		change.Size -= existing.GetSize()
+	}
+	b.connections[connectionID].Size += change.Size
+	file := &fileref.FileRef{}
+	file.Type = fileref.FILE
+	file.AllocationID = b.config.AllocationID
+	file.Name = formData.Filename
+	file.Path = formData.Path
+	file.Size = int64(len(data))
+	file.ContentHash = formData.Hash
+	file.MerkleRoot = formData.MerkleRoot
+	file.ThumbnailSize = int64(len(thumbnail))
+	file.ThumbnailHash = formData.ThumbnailHash
+	file.ActualFileSize = formData.ActualSize
+	file.ActualFileHash = formData.ActualHash
+	file.ActualThumbnailSize = formData.ActualThumbnailSize
+	file.ActualThumbnailHash = formData.ActualThumbnailHash
+	file.MimeType = formData.MimeType
+	file.CustomMeta = formData.CustomMeta
+	file.EncryptedKey = formData.EncryptedKey
+	file.LookupHash = fileref.GetReferenceLookup(file.AllocationID, file.Path)
+	file.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	file.CalculateHash()
+	change.file = file
+	change.data = nil
+	b.content[file.ContentHash] = data
+	if thumbnail != nil {
+		b.thumbnails[file.ThumbnailHash] = thumbnail
+	}
+	result := &uploadResult{Filename: file.Name, ShardSize: file.Size, Hash: file.ContentHash, MerkleRoot: file.MerkleRoot}
+	if b.config.ChunkedUploads {
+		result.UploadLength = file.Size
+	}
+	return result, nil
+}
+
+// pendingUpload - the upload of remotePath in the connection still waiting
+// for the rest of the shard
+func (b *Blobber) pendingUpload(connectionID string, remotePath string) *connectionChange {
+	conn, ok := b.connections[connectionID]
+	if !ok {
+		return nil
+	}
+	for _, change := range conn.Changes {
+		isUpload := change.Operation == allocationchange.INSERT_OPERATION || change.Operation == allocationchange.UPDATE_OPERATION
+		if isUpload && change.file == nil && change.path == remotePath {
+			return change
+		}
+	}
+	return nil
+}
+
+// removeChange - drops the change from the connection
+func (b *Blobber) removeChange(connectionID string, change *connectionChange) {
+	conn, ok := b.connections[connectionID]
+	if !ok || change == nil {
+		return
+	}
+	for i, c := range conn.Changes {
+		if c == change {
+			conn.Changes = append(conn.Changes[:i], conn.Changes[i+1:]...)
+			conn.Size -= change.Size
+			return
+		}
+	}
+}
+
+func (b *Blobber) deleteHandler(r *http.Request) (interface{}, error) {
+	err := parseForm(r, "connection_id", "path")
+	if err != nil {
+		return nil, err
+	}
+	remotePath := r.FormValue("path")
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ref := findRef(b.root, remotePath)
+	if ref == nil || remotePath == "/" {
+		return nil, badRequest("invalid_parameters", "Invalid file path "+remotePath)
+	}
+	change := &connectionChange{Operation: allocationchange.DELETE_OPERATION, Size: -ref.GetSize(), path: remotePath}
+	change.Input = formInput(r, "connection_id", "path")
+	b.addChange(r.FormValue("connection_id"), change)
+	return map[string]string{"connection_id": r.FormValue("connection_id"), "filepath": remotePath, "filename": ref.GetName()}, nil
+}
+
+func (b *Blobber) renameHandler(r *http.Request, clientID string) (interface{}, error) {
+	err := parseForm(r, "connection_id", "path", "new_name")
+	if err != nil {
+		return nil, err
+	}
+	remotePath := r.FormValue("path")
+	newName := r.FormValue("new_name")
+	if strings.Contains(newName, "/") || newName == "." || newName == ".." {
+		return nil, badRequest("invalid_parameters", "Invalid name "+newName)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ref := findRef(b.root, remotePath)
+	if ref == nil || remotePath == "/" {
+		return nil, badRequest("invalid_parameters", "Invalid file path "+remotePath)
+	}
+	if findRef(b.root, path.Join(path.Dir(remotePath), newName)) != nil {
+		return nil, badRequest("duplicate_file", "An object already exists with the name "+newName)
+	}
+	change := &connectionChange{Operation: allocationchange.RENAME_OPERATION, path: remotePath, newName: newName}
+	change.Input = formInput(r, "connection_id", "path", "new_name")
+	b.addChange(r.FormValue("connection_id"), change)
+	return map[string]string{"connection_id": r.FormValue("connection_id"), "filepath": remotePath, "new_name": newName}, nil
+}
+
+func (b *Blobber) copyHandler(r *http.Request, clientID string) (interface{}, error) {
+	err := parseForm(r, "connection_id", "path", "dest")
+	if err != nil {
+		return nil, err
+	}
+	remotePath := r.FormValue("path")
+	destPath := r.FormValue("dest")
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ref := findRef(b.root, remotePath)
+	if ref == nil || remotePath == "/" {
+		return nil, badRequest("invalid_parameters", "Invalid file path "+remotePath)
+	}
+	dest := findRef(b.root, destPath)
+	if dest == nil || dest.GetType() != fileref.DIRECTORY {
+		return nil, badRequest("invalid_parameters", "Invalid destination path "+destPath)
+	}
+	if ref.GetType() == fileref.DIRECTORY && isSameOrUnder(destPath, remotePath) {
+		return nil, badRequest("invalid_parameters", "Can't copy a directory under itself")
+	}
+	if findRef(b.root, path.Join(destPath, ref.GetName())) != nil {
+		return nil, badRequest("duplicate_file", "An object already exists at the destination")
+	}
+	change := &connectionChange{Operation: allocationchange.COPY_OPERATION, Size: ref.GetSize(), path: remotePath, dest: destPath}
+	change.Input = formInput(r, "connection_id", "path", "dest")
+	b.addChange(r.FormValue("connection_id"), change)
+	return map[string]string{"connection_id": r.FormValue("connection_id"), "filepath": remotePath, "dest": destPath}, nil
+}
+
+func (b *Blobber) createDirHandler(r *http.Request, clientID string) (interface{}, error) {
+	err := parseForm(r, "connection_id", "dir_path")
+	if err != nil {
+		return nil, err
+	}
+	dirPath := r.FormValue("dir_path")
+	if !isValidPath(dirPath) {
+		return nil, badRequest("invalid_parameters", "Invalid directory path "+dirPath)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if ref := findRef(b.root, dirPath); ref != nil && ref.GetType() != fileref.DIRECTORY {
+		return nil, badRequest("duplicate_file", "A file exists at the path "+dirPath)
+	}
+	change := &connectionChange{Operation: allocationchange.CREATEDIR_OPERATION, path: dirPath}
+	change.Input = formInput(r, "connection_id", "dir_path")
+	b.addChange(r.FormValue("connection_id"), change)
+	return map[string]string{"connection_id": r.FormValue("connection_id"), "dir_path": dirPath}, nil
+}
+
+func (b *Blobber) connectionHandler(r *http.Request, clientID string) (interface{}, error) {
+	connectionID := r.FormValue("connection_id")
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	conn, ok := b.connections[connectionID]
+	if !ok {
+		return nil, badRequest("invalid_connection", "Connection not found "+connectionID)
+	}
+	if r.Method == http.MethodDelete {
+		delete(b.connections, connectionID)
+		return map[string]string{"connection_id": connectionID}, nil
+	}
+	return map[string]interface{}{
+		"connection_id": conn.ID,
+		"allocation_id": b.config.AllocationID,
+		"size":          conn.Size,
+		"changes":       conn.Changes,
+	}, nil
+}
+
+// toAllocationChange - the change of the connection on the tree it is
+// applied to
+func (b *Blobber) toAllocationChange(root *fileref.Ref, change *connectionChange) (allocationchange.AllocationChange, error) {
+	if change.Operation == allocationchange.INSERT_OPERATION || change.Operation == allocationchange.UPDATE_OPERATION {
+		if change.file == nil {
+			return nil, badRequest("upload_incomplete", "Upload of "+change.path+" is not complete")
+		}
+		// The tree takes the reference, a failed commit has to leave it as is
+		file := *change.file
+		if change.Operation == allocationchange.INSERT_OPERATION {
+			return &allocationchange.NewFileChange{File: &file}, nil
+		}
+		return &allocationchange.UpdateFileChange{NewFile: &file}, nil
+	}
+	if change.Operation == allocationchange.CREATEDIR_OPERATION {
+		return &allocationchange.DirCreateChange{RemotePath: change.path}, nil
+	}
+	ref := findRef(root, change.path)
+	if ref == nil {
+		return nil, badRequest("file_not_found", "Object not found "+change.path)
+	}
+	switch change.Operation {
+	case allocationchange.DELETE_OPERATION:
+		return &allocationchange.DeleteFileChange{ObjectTree: ref}, nil
+	case allocationchange.RENAME_OPERATION:
+		return &allocationchange.RenameFileChange{ObjectTree: ref, NewName: change.newName}, nil
+	case allocationchange.COPY_OPERATION:
+		objectTree, err := cloneRef(ref, b.config.AllocationID)
+		if err != nil {
+			return nil, err
+		}
+		return &allocationchange.CopyFileChange{ObjectTree: objectTree, DestPath: change.dest}, nil
+	}
+	return nil, badRequest("invalid_operation", "Unknown operation "+change.Operation)
+}
+
+// updateStats - counts the updates of the files changed by the commit
+func (b *Blobber) updateStats(change *connectionChange) {
+	switch change.Operation {
+	case allocationchange.INSERT_OPERATION:
+		b.stats[change.path] = &fileStats{}
+	case allocationchange.UPDATE_OPERATION:
+		if s, ok := b.stats[change.path]; ok {
+			s.NumUpdates++
+		}
+	case allocationchange.DELETE_OPERATION, allocationchange.RENAME_OPERATION:
+		newPath := path.Join(path.Dir(change.path), change.newName)
+		for p, s := range b.stats {
+			if !isSameOrUnder(p, change.path) {
+				continue
+			}
+			delete(b.stats, p)
+			if change.Operation == allocationchange.RENAME_OPERATION {
+				b.stats[newPath+strings.TrimPrefix(p, change.path)] = s
+			}
+		}
+	}
+}
+
+func (b *Blobber) commitHandler(r *http.Request, clientID string) (interface{}, error) {
+	err := parseForm(r, "connection_id", "write_marker")
+	if err != nil {
+		return nil, err
+	}
+	connectionID := r.FormValue("connection_id")
+	wm := &marker.WriteMarker{}
+	err = json.Unmarshal([]byte(r.FormValue("write_marker")), wm)
+	if err != nil {
+		return nil, badRequest("invalid_parameters", "Invalid write marker. "+err.Error())
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	conn, ok := b.connections[connectionID]
+	if !ok {
+		return nil, badRequest("invalid_connection", "Connection not found "+connectionID)
+	}
+	if wm.AllocationID != b.config.AllocationID || wm.BlobberID != b.ID || wm.ClientID != b.config.OwnerID {
+		return nil, badRequest("write_marker_validation_failed", "Write marker is not for this allocation, blobber or client")
+	}
+	err = b.verifySignature(b.config.OwnerPublicKey, wm.Signature, wm.GetHash())
+	if err != nil {
+		return nil, badRequest("write_marker_validation_failed", "Invalid write marker signature. "+err.Error())
+	}
+	prevAllocationRoot := ""
+	if b.latestWM != nil {
+		prevAllocationRoot = b.latestWM.AllocationRoot
+		if wm.Timestamp < b.latestWM.Timestamp {
+			return nil, badRequest("write_marker_validation_failed", "Write marker timestamp is older than the latest one")
+		}
+	}
+	if wm.PreviousAllocationRoot != prevAllocationRoot {
+		return nil, badRequest("write_marker_validation_failed", "Previous allocation root doesn't match the latest one")
+	}
+
+	root, err := cloneTree(b.root)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(0)
+	for _, change := range conn.Changes {
+		ac, err := b.toAllocationChange(root, change)
+		if err != nil {
+			return nil, err
+		}
+		err = ac.ProcessChange(root)
+		if err != nil {
+			return nil, badRequest("commit_failed", err.Error())
+		}
+		size += ac.GetSize()
+	}
+	if size != wm.Size {
+		return nil, badRequest("write_marker_validation_failed", "Write marker size "+strconv.FormatInt(wm.Size, 10)+" doesn't match the changes "+strconv.FormatInt(size, 10))
+	}
+	root.CalculateHash()
+	allocationRoot := encryption.Hash(root.Hash + ":" + strconv.FormatInt(wm.Timestamp, 10))
+	if wm.AllocationRoot != allocationRoot {
+		return nil, badRequest("allocation_root_mismatch", "Allocation root in the write marker doesn't match the changes. Expected: "+allocationRoot)
+	}
+	updateLookupHashes(root)
+	b.root = root
+	b.latestWM = wm
+	delete(b.connections, connectionID)
+	for _, change := range conn.Changes {
+		b.updateStats(change)
+	}
+	return map[string]interface{}{"allocation_root": allocationRoot, "write_marker": wm, "success": true}, nil
+}
+
+func (b *Blobber) referencePathHandler(r *http.Request, clientID string) (interface{}, error) {
+	var paths []string
+	err := json.Unmarshal([]byte(r.FormValue("paths")), &paths)
+	if err != nil {
+		return nil, badRequest("invalid_parameters", "Invalid paths. "+err.Error())
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	expand := func(dir *fileref.Ref) bool {
+		for _, p := range paths {
+			if isSameOrUnder(p, dir.Path) {
+				return true
+			}
+		}
+		return false
+	}
+	return &referencePathResult{ReferencePath: toReferencePath(b.root, expand), LatestWM: b.latestWM}, nil
+}
+
+func (b *Blobber) objectTreeHandler(r *http.Request, clientID string) (interface{}, error) {
+	remotePath := r.FormValue("path")
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ref := findRef(b.root, remotePath)
+	if ref == nil {
+		return nil, badRequest("invalid_parameters", "Invalid file path "+remotePath)
+	}
+	return &referencePathResult{ReferencePath: toReferencePath(ref, expandAll), LatestWM: b.latestWM}, nil
+}
+
+// getRef - the reference of the path hash in the request, readable by the
+// client
+func (b *Blobber) getRef(r *http.Request, clientID string) (fileref.RefEntity, error) {
+	pathHash := r.FormValue("path_hash")
+	ref := findRefByLookup(b.root, pathHash)
+	if ref == nil {
+		return nil, badRequest("invalid_parameters", "Invalid path hash "+pathHash)
+	}
+	return ref, b.authorizeRead(r, clientID, ref)
+}
+
+func (b *Blobber) listHandler(r *http.Request, clientID string) (interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ref, err := b.getRef(r, clientID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]interface{}, 0)
+	if dir, ok := ref.(*fileref.Ref); ok {
+		for _, child := range dir.Children {
+			list = append(list, getMeta(child))
+		}
+	}
+	allocationRoot := ""
+	if b.latestWM != nil {
+		allocationRoot = b.latestWM.AllocationRoot
+	}
+	return &fileref.ListResult{AllocationRoot: allocationRoot, Meta: getMeta(ref), Entities: list}, nil
+}
+
+func (b *Blobber) fileMetaHandler(r *http.Request, clientID string) (interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ref, err := b.getRef(r, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return getMeta(ref), nil
+}
+
+func (b *Blobber) fileStatsHandler(r *http.Request, clientID string) (interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ref, err := b.getRef(r, clientID)
+	if err != nil {
+		return nil, err
+	}
+	result := &fileStatsResult{
+		Name:       ref.GetName(),
+		Size:       ref.GetSize(),
+		PathHash:   r.FormValue("path_hash"),
+		Path:       ref.GetPath(),
+		NumBlocks:  ref.GetNumBlocks(),
+		BlobberID:  b.ID,
+		BlobberURL: b.server.URL,
+	}
+	if s, ok := b.stats[ref.GetPath()]; ok {
+		result.NumUpdates = s.NumUpdates
+		result.NumBlockDownloads = s.NumBlockDownloads
+	}
+	return result, nil
+}
+
+func (b *Blobber) downloadHandler(r *http.Request, clientID string) (interface{}, error) {
+	err := parseForm(r, "path_hash", "block_num", "num_blocks", "read_marker")
+	if err != nil {
+		return nil, err
+	}
+	blockNum, err := strconv.ParseInt(r.FormValue("block_num"), 10, 64)
+	if err != nil || blockNum < 1 {
+		return nil, badRequest("invalid_parameters", "Invalid block number")
+	}
+	numBlocks, err := strconv.ParseInt(r.FormValue("num_blocks"), 10, 64)
+	if err != nil || numBlocks < 1 {
+		return nil, badRequest("invalid_parameters", "Invalid number of blocks")
+	}
+	rm := &marker.ReadMarker{}
+	err = json.Unmarshal([]byte(r.FormValue("read_marker")), rm)
+	if err != nil {
+		return nil, badRequest("invalid_parameters", "Invalid read marker. "+err.Error())
+	}
+	if rm.AllocationID != b.config.AllocationID || rm.BlobberID != b.ID || rm.ClientID != clientID {
+		return nil, badRequest("invalid_read_marker", "Read marker is not for this allocation, blobber or client")
+	}
+	err = b.verifySignature(rm.ClientPublicKey, rm.Signature, rm.GetHash())
+	if err != nil {
+		return nil, badRequest("invalid_read_marker", "Invalid read marker signature. "+err.Error())
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ref, err := b.getRef(r, clientID)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := ref.(*fileref.FileRef)
+	if !ok {
+		return nil, badRequest("invalid_parameters", "Path is not a file")
+	}
+	latestRM := b.readMarkers[rm.ClientID]
+	if latestRM != nil && rm.ReadCounter < latestRM.ReadCounter+numBlocks {
+		// Lets the client catch up with the counter and retry
+		return &downloadFailure{Success: false, LatestRM: latestRM}, nil
+	}
+	if latestRM == nil && rm.ReadCounter < numBlocks {
+		return nil, badRequest("invalid_read_marker", "Read counter doesn't cover the blocks")
+	}
+	data := b.content[file.ContentHash]
+	if r.FormValue("content") == downloadContentThumb {
+		data = b.thumbnails[file.ThumbnailHash]
+	}
+	start := (blockNum - 1) * fileref.CHUNK_SIZE
+	if start >= int64(len(data)) {
+		return nil, badRequest("invalid_block_num", "Block number is out of the file")
+	}
+	end := start + numBlocks*fileref.CHUNK_SIZE
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	b.readMarkers[rm.ClientID] = rm
+	if s, ok := b.stats[file.Path]; ok {
+		s.NumBlockDownloads += numBlocks
+	}
+	return data[start:end], nil
+}
@@ -0,0 +1,80 @@
+package blobbertest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/0chain/gosdk/core/encryption"
+	"github.com/0chain/gosdk/core/zcncrypto"
+	"github.com/0chain/gosdk/zboxcore/blockchain"
+)
+
+// Network - the blobbers of an allocation
+type Network struct {
+	Config   Config
+	Blobbers []*Blobber
+}
+
+// NewNetwork - starts numBlobbers blobbers of the allocation, closed by Close
+func NewNetwork(numBlobbers int, config Config) *Network {
+	n := &Network{Config: config, Blobbers: make([]*Blobber, numBlobbers)}
+	for i := range n.Blobbers {
+		id := encryption.Hash(fmt.Sprintf("%s:blobber:%d", config.AllocationID, i))
+		n.Blobbers[i] = NewBlobber(id, config)
+	}
+	return n
+}
+
+// StorageNodes - the blobbers in the order of the shards, as the Blobbers of
+// an Allocation
+func (n *Network) StorageNodes() []*blockchain.StorageNode {
+	nodes := make([]*blockchain.StorageNode, len(n.Blobbers))
+	for i, b := range n.Blobbers {
+		nodes[i] = b.StorageNode()
+	}
+	return nodes
+}
+
+// AllocationJSON - the allocation on the network, as the chain returns it.
+// The first dataShards blobbers hold the data shards and the rest the parity.
+func (n *Network) AllocationJSON(dataShards int) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":               n.Config.AllocationID,
+		"data_shards":      dataShards,
+		"parity_shards":    len(n.Blobbers) - dataShards,
+		"owner_id":         n.Config.OwnerID,
+		"owner_public_key": n.Config.OwnerPublicKey,
+		"blobbers":         n.StorageNodes(),
+	})
+	return data
+}
+
+func (n *Network) Close() {
+	for _, b := range n.Blobbers {
+		b.Close()
+	}
+}
+
+// NewWallet - a new wallet of the signature scheme, along with the JSON
+// InitStorageSDK takes
+func NewWallet(signatureScheme string) (*zcncrypto.Wallet, string, error) {
+	wallet, err := zcncrypto.NewSignatureScheme(signatureScheme).GenerateKeys()
+	if err != nil {
+		return nil, "", err
+	}
+	walletJSON, err := json.Marshal(wallet)
+	if err != nil {
+		return nil, "", err
+	}
+	return wallet, string(walletJSON), nil
+}
+
+// NewConfig - config of an allocation owned by the wallet
+func NewConfig(allocationID string, owner *zcncrypto.Wallet, signatureScheme string) Config {
+	return Config{
+		AllocationID:    allocationID,
+		OwnerID:         owner.ClientID,
+		OwnerPublicKey:  owner.ClientKey,
+		SignatureScheme: signatureScheme,
+	}
+}
@@ -53,6 +53,7 @@ type Ref struct {
 	NumBlocks      int64  `json:"num_of_blocks"`
 	PathHash       string `json:"path_hash"`
 	LookupHash     string `json:"lookup_hash"`
+	UpdatedAt      string `json:"updated_at"`
 	childrenLoaded bool
 	Children       []RefEntity `json:"-"`
 }
@@ -146,6 +147,12 @@ func (r *Ref) RemoveChild(idx int) {
 	})
 }
 
+// SetChildrenLoaded - marks the children as all known, so that CalculateHash
+// hashes them even when there are none, as for a new empty directory
+func (r *Ref) SetChildrenLoaded() {
+	r.childrenLoaded = true
+}
+
 func (fr *FileRef) GetHashData() string {
 	hashArray := make([]string, 0)
 	hashArray = append(hashArray, fr.AllocationID)
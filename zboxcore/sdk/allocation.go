@@ -1,11 +1,14 @@
 package sdk
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -23,6 +26,9 @@ import (
 var (
 	noBLOBBERS     = errors.New("No Blobbers set in this allocation")
 	notInitialized = common.NewError("sdk_not_initialized", "Please call InitStorageSDK Init and use GetAllocation to get the allocation object")
+	// ErrCancelled - reported to the status callbacks not implementing
+	// CancelCallback when an operation is canceled
+	ErrCancelled = common.NewError("cancelled", "Operation cancelled")
 )
 
 type ConsolidatedFileMeta struct {
@@ -69,6 +75,7 @@ type Allocation struct {
 	ctxCancelF          context.CancelFunc
 	mutex               *sync.Mutex
 	downloadProgressMap map[string]*DownloadRequest
+	uploadProgressMap   map[string]*UploadRequest
 	initialized         bool
 }
 
@@ -93,6 +100,7 @@ func (a *Allocation) InitAllocation() {
 	a.downloadChan = make(chan *DownloadRequest, 10)
 	a.ctx, a.ctxCancelF = context.WithCancel(context.Background())
 	a.downloadProgressMap = make(map[string]*DownloadRequest)
+	a.uploadProgressMap = make(map[string]*UploadRequest)
 	a.mutex = &sync.Mutex{}
 	a.startWorker(a.ctx)
 	InitCommitWorker(a.Blobbers)
@@ -156,13 +164,105 @@ func (a *Allocation) EncryptAndUploadFileWithThumbnail(localpath string, remotep
 	return a.uploadOrUpdateFile(localpath, remotepath, status, false, thumbnailpath, true)
 }
 
+// UploadResult - the file committed by a blocking upload
+type UploadResult struct {
+	RemotePath string `json:"remote_path"`
+	Size       int64  `json:"size"`
+	Hash       string `json:"hash"`
+	MimeType   string `json:"mimetype"`
+}
+
+// UploadFileCtx - uploads the local file and returns once it is committed.
+// Canceling ctx aborts the requests to the blobbers, unless the commit
+// already started. status is optional.
+func (a *Allocation) UploadFileCtx(ctx context.Context, localpath string, remotepath string, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFileCtx(ctx, localpath, remotepath, status, false, "", false)
+}
+
+// UpdateFileCtx - updates the remote file with the local file. See
+// UploadFileCtx.
+func (a *Allocation) UpdateFileCtx(ctx context.Context, localpath string, remotepath string, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFileCtx(ctx, localpath, remotepath, status, true, "", false)
+}
+
+// EncryptAndUploadFileCtx - encrypts and uploads the local file. See
+// UploadFileCtx.
+func (a *Allocation) EncryptAndUploadFileCtx(ctx context.Context, localpath string, remotepath string, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFileCtx(ctx, localpath, remotepath, status, false, "", true)
+}
+
+// UploadFileWithThumbnailCtx - uploads the local file along with its
+// thumbnail. See UploadFileCtx.
+func (a *Allocation) UploadFileWithThumbnailCtx(ctx context.Context, localpath string, remotepath string, thumbnailpath string, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFileCtx(ctx, localpath, remotepath, status, false, thumbnailpath, false)
+}
+
+// UpdateFileWithThumbnailCtx - updates the remote file and its thumbnail.
+// See UploadFileCtx.
+func (a *Allocation) UpdateFileWithThumbnailCtx(ctx context.Context, localpath string, remotepath string, thumbnailpath string, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFileCtx(ctx, localpath, remotepath, status, true, thumbnailpath, false)
+}
+
+func (a *Allocation) uploadOrUpdateFileCtx(ctx context.Context, localpath string, remotepath string, status StatusCallback, isUpdate bool, thumbnailpath string, encryption bool) (*UploadResult, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	uploadStatus := &opStatus{status: status, op: OpUpload}
+	uploadReq, err := a.newLocalUploadRequest(localpath, remotepath, uploadStatus, isUpdate, thumbnailpath, encryption)
+	if err != nil {
+		return nil, err
+	}
+	return a.processUploadCtx(ctx, uploadReq, localpath, uploadStatus)
+}
+
+// processUploadCtx - runs the upload till it is committed or ctx is done,
+// with name in the error of an upload that didn't complete
+func (a *Allocation) processUploadCtx(ctx context.Context, uploadReq *UploadRequest, name string, uploadStatus *opStatus) (*UploadResult, error) {
+	// Canceled by ctx rather than by the allocation
+	uploadReq.ctxCncl()
+	uploadReq.ctx, uploadReq.ctxCncl = context.WithCancel(ctx)
+	a.trackUpload(uploadReq)
+	uploadReq.processUpload(ctx, a)
+	if uploadStatus.err != nil || !uploadStatus.completed {
+		// Failures caused by the cancel are reported as such
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if uploadStatus.err != nil {
+		return nil, uploadStatus.err
+	}
+	if !uploadStatus.completed {
+		return nil, common.NewError("upload_failed", "Upload of "+name+" didn't complete")
+	}
+	result := &UploadResult{}
+	result.RemotePath = uploadReq.remotefilepath
+	result.Size = uploadReq.filemeta.Size
+	result.Hash = uploadReq.filemeta.Hash
+	result.MimeType = uploadReq.filemeta.MimeType
+	return result, nil
+}
+
 func (a *Allocation) uploadOrUpdateFile(localpath string, remotepath string, status StatusCallback, isUpdate bool, thumbnailpath string, encryption bool) error {
 	if !a.isInitialized() {
 		return notInitialized
 	}
+	uploadReq, err := a.newLocalUploadRequest(localpath, remotepath, status, isUpdate, thumbnailpath, encryption)
+	if err != nil {
+		return err
+	}
+	a.trackUpload(uploadReq)
+	go func() {
+		a.uploadChan <- uploadReq
+	}()
+	return nil
+}
+
+// newLocalUploadRequest - the request uploading the local file at localpath
+func (a *Allocation) newLocalUploadRequest(localpath string, remotepath string, status StatusCallback, isUpdate bool, thumbnailpath string, encryption bool) (*UploadRequest, error) {
 	fileInfo, err := os.Stat(localpath)
 	if err != nil {
-		return fmt.Errorf("Local file error: %s", err.Error())
+		return nil, fmt.Errorf("Local file error: %s", err.Error())
 	}
 	thumbnailSize := int64(0)
 	if len(thumbnailpath) > 0 {
@@ -179,25 +279,265 @@ func (a *Allocation) uploadOrUpdateFile(localpath string, remotepath string, sta
 	remotepath = filepath.Clean(remotepath)
 	isabs := filepath.IsAbs(remotepath)
 	if !isabs {
-		return common.NewError("invalid_path", "Path should be valid and absolute")
+		return nil, common.NewError("invalid_path", "Path should be valid and absolute")
 	}
 	remotepath = zboxutil.GetFullRemotePath(localpath, remotepath)
+	uploadReq := a.newUploadRequest(remotepath, fileInfo.Size(), status, isUpdate, encryption)
+	uploadReq.filepath = localpath
+	uploadReq.thumbnailpath = thumbnailpath
+	uploadReq.filemeta.ThumbnailSize = thumbnailSize
+	uploadReq.thumbRemaining = uploadReq.filemeta.ThumbnailSize
+	uploadReq.state = newUploadState(a.ID, uploadReq, fileInfo)
+	return uploadReq, nil
+}
+
+// ResumeUpload - continues an interrupted upload of a local file to
+// remotepath, from the progress saved in the upload state directory. Each
+// blobber taking the shard in parts is sent the shard after the last chunk it
+// acknowledged, if it still holds the chunks in the pending connection. The
+// other blobbers keep only the uploads they received whole. Encrypted uploads
+// start over, since the encryption key is not kept across attempts. Only the
+// uploads of local files are saved, the uploads from readers and streams
+// can't be read again and are not resumable.
+func (a *Allocation) ResumeUpload(remotepath string, status StatusCallback) error {
+	if !a.isInitialized() {
+		return notInitialized
+	}
+	remotepath = filepath.Clean(remotepath)
+	state, err := loadUploadState(a.ID, remotepath)
+	if err != nil {
+		return common.NewError("upload_state_not_found", "No interrupted upload for the path "+remotepath)
+	}
+	fileInfo, err := os.Stat(state.LocalPath)
+	if err != nil {
+		return fmt.Errorf("Local file error: %s", err.Error())
+	}
+	if fileInfo.Size() != state.Size || fileInfo.ModTime().UnixNano() != state.ModTime {
+		state.remove()
+		return common.NewError("local_file_changed", "Local file changed after the upload was interrupted. Upload it again")
+	}
+	thumbnailSize := int64(0)
+	if len(state.ThumbnailPath) > 0 {
+		thumbInfo, err := os.Stat(state.ThumbnailPath)
+		if err != nil {
+			state.ThumbnailPath = ""
+		} else {
+			thumbnailSize = thumbInfo.Size()
+		}
+	}
+
+	uploadReq := a.newUploadRequest(remotepath, state.Size, status, state.IsUpdate, state.IsEncrypted)
+	uploadReq.filepath = state.LocalPath
+	uploadReq.thumbnailpath = state.ThumbnailPath
+	uploadReq.filemeta.ThumbnailSize = thumbnailSize
+	uploadReq.thumbRemaining = thumbnailSize
+	uploadReq.state = state
+	if state.IsEncrypted {
+		state.ConnectionID = uploadReq.connectionID
+		state.Uploaded = make(map[string]*fileref.FileRef)
+		state.Acknowledged = make(map[string]int64)
+	} else {
+		uploadReq.connectionID = state.ConnectionID
+		uploadReq.resumed, uploadReq.startChunks = a.getResumableUploads(state)
+		for pos := range uploadReq.resumed {
+			uploadReq.uploadMask &= ^(1 << uint32(pos))
+		}
+	}
+	a.trackUpload(uploadReq)
+	go func() {
+		a.uploadChan <- uploadReq
+	}()
+	return nil
+}
+
+// getResumableUploads - file refs of the blobbers, by blobber index, that
+// acknowledged the whole upload and still hold it in the pending connection,
+// and the chunk each of the others resumes from
+func (a *Allocation) getResumableUploads(state *uploadState) (map[int]*fileref.FileRef, map[int]int64) {
+	resumed := make(map[int]*fileref.FileRef)
+	startChunks := make(map[int]int64)
+	mutex := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for pos, blobber := range a.Blobbers {
+		ref, uploaded := state.Uploaded[blobber.ID]
+		chunkIndex, acknowledged := state.Acknowledged[blobber.ID]
+		if !uploaded && !acknowledged {
+			continue
+		}
+		wg.Add(1)
+		go func(pos int, blobber *blockchain.StorageNode) {
+			defer wg.Done()
+			pending := getPendingUpload(a.ctx, blobber, a.ID, state.ConnectionID, state.RemotePath)
+			if pending == nil {
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			// Blobbers taking the shard in one request hold only complete uploads
+			if uploaded && (pending.IsFinal || !supportsChunkedUpload(a.ctx, blobber, a.ID, state.ConnectionID)) {
+				resumed[pos] = ref
+			} else if acknowledged && !pending.IsFinal && pending.ChunkIndex == chunkIndex {
+				startChunks[pos] = chunkIndex + 1
+			}
+		}(pos, blobber)
+	}
+	wg.Wait()
+	// Uploads the blobbers lost are sent again
+	state.Uploaded = make(map[string]*fileref.FileRef)
+	state.Acknowledged = make(map[string]int64)
+	for pos, ref := range resumed {
+		state.Uploaded[a.Blobbers[pos].ID] = ref
+	}
+	for pos, startChunk := range startChunks {
+		state.Acknowledged[a.Blobbers[pos].ID] = startChunk - 1
+	}
+	Logger.Info("Resuming upload of ", state.RemotePath, ". Blobbers with the upload: ", len(resumed), ", with a part of it: ", len(startChunks))
+	return resumed, startChunks
+}
+
+// UploadFromReader - uploads the content of the reader to the remotepath.
+// size is the number of bytes the reader will return. Pass a negative size
+// for streams of unknown length, e.g. stdin or a pipe. The upload keeps no
+// state, an interrupted upload is sent again from the start.
+func (a *Allocation) UploadFromReader(reader io.Reader, size int64, remotepath string, status StatusCallback) error {
+	return a.uploadOrUpdateFromReader(reader, size, remotepath, status, false, nil, 0, false)
+}
+
+func (a *Allocation) UpdateFromReader(reader io.Reader, size int64, remotepath string, status StatusCallback) error {
+	return a.uploadOrUpdateFromReader(reader, size, remotepath, status, true, nil, 0, false)
+}
+
+func (a *Allocation) UploadFromReaderWithThumbnail(reader io.Reader, size int64, remotepath string, thumbnail io.Reader, thumbnailSize int64, status StatusCallback) error {
+	return a.uploadOrUpdateFromReader(reader, size, remotepath, status, false, thumbnail, thumbnailSize, false)
+}
+
+func (a *Allocation) UpdateFromReaderWithThumbnail(reader io.Reader, size int64, remotepath string, thumbnail io.Reader, thumbnailSize int64, status StatusCallback) error {
+	return a.uploadOrUpdateFromReader(reader, size, remotepath, status, true, thumbnail, thumbnailSize, false)
+}
+
+func (a *Allocation) EncryptAndUploadFromReader(reader io.Reader, size int64, remotepath string, status StatusCallback) error {
+	return a.uploadOrUpdateFromReader(reader, size, remotepath, status, false, nil, 0, true)
+}
+
+func (a *Allocation) EncryptAndUpdateFromReader(reader io.Reader, size int64, remotepath string, status StatusCallback) error {
+	return a.uploadOrUpdateFromReader(reader, size, remotepath, status, true, nil, 0, true)
+}
+
+func (a *Allocation) EncryptAndUploadFromReaderWithThumbnail(reader io.Reader, size int64, remotepath string, thumbnail io.Reader, thumbnailSize int64, status StatusCallback) error {
+	return a.uploadOrUpdateFromReader(reader, size, remotepath, status, false, thumbnail, thumbnailSize, true)
+}
+
+func (a *Allocation) EncryptAndUpdateFromReaderWithThumbnail(reader io.Reader, size int64, remotepath string, thumbnail io.Reader, thumbnailSize int64, status StatusCallback) error {
+	return a.uploadOrUpdateFromReader(reader, size, remotepath, status, true, thumbnail, thumbnailSize, true)
+}
+
+// UploadFromReaderCtx - uploads the content of the reader and returns once
+// it is committed. See UploadFromReader and UploadFileCtx.
+func (a *Allocation) UploadFromReaderCtx(ctx context.Context, reader io.Reader, size int64, remotepath string, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFromReaderCtx(ctx, reader, size, remotepath, status, false, nil, 0, false)
+}
+
+// UpdateFromReaderCtx - updates the remote file with the content of the
+// reader. See UploadFromReaderCtx.
+func (a *Allocation) UpdateFromReaderCtx(ctx context.Context, reader io.Reader, size int64, remotepath string, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFromReaderCtx(ctx, reader, size, remotepath, status, true, nil, 0, false)
+}
+
+// UploadFromReaderWithThumbnailCtx - uploads the content of the reader along
+// with the thumbnail. See UploadFromReaderCtx.
+func (a *Allocation) UploadFromReaderWithThumbnailCtx(ctx context.Context, reader io.Reader, size int64, remotepath string, thumbnail io.Reader, thumbnailSize int64, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFromReaderCtx(ctx, reader, size, remotepath, status, false, thumbnail, thumbnailSize, false)
+}
+
+// UpdateFromReaderWithThumbnailCtx - updates the remote file and its
+// thumbnail with the content of the readers. See UploadFromReaderCtx.
+func (a *Allocation) UpdateFromReaderWithThumbnailCtx(ctx context.Context, reader io.Reader, size int64, remotepath string, thumbnail io.Reader, thumbnailSize int64, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFromReaderCtx(ctx, reader, size, remotepath, status, true, thumbnail, thumbnailSize, false)
+}
+
+// EncryptAndUploadFromReaderCtx - encrypts and uploads the content of the
+// reader. See UploadFromReaderCtx.
+func (a *Allocation) EncryptAndUploadFromReaderCtx(ctx context.Context, reader io.Reader, size int64, remotepath string, status StatusCallback) (*UploadResult, error) {
+	return a.uploadOrUpdateFromReaderCtx(ctx, reader, size, remotepath, status, false, nil, 0, true)
+}
+
+func (a *Allocation) uploadOrUpdateFromReaderCtx(ctx context.Context, reader io.Reader, size int64, remotepath string, status StatusCallback, isUpdate bool, thumbnail io.Reader, thumbnailSize int64, encryption bool) (*UploadResult, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	uploadStatus := &opStatus{status: status, op: OpUpload}
+	uploadReq, err := a.newReaderUploadRequest(reader, size, remotepath, uploadStatus, isUpdate, thumbnail, thumbnailSize, encryption)
+	if err != nil {
+		return nil, err
+	}
+	return a.processUploadCtx(ctx, uploadReq, uploadReq.remotefilepath, uploadStatus)
+}
+
+func (a *Allocation) uploadOrUpdateFromReader(reader io.Reader, size int64, remotepath string, status StatusCallback, isUpdate bool, thumbnail io.Reader, thumbnailSize int64, encryption bool) error {
+	if !a.isInitialized() {
+		return notInitialized
+	}
+	uploadReq, err := a.newReaderUploadRequest(reader, size, remotepath, status, isUpdate, thumbnail, thumbnailSize, encryption)
+	if err != nil {
+		return err
+	}
+	a.trackUpload(uploadReq)
+	go func() {
+		a.uploadChan <- uploadReq
+	}()
+	return nil
+}
+
+// newReaderUploadRequest - the request uploading the content of reader
+func (a *Allocation) newReaderUploadRequest(reader io.Reader, size int64, remotepath string, status StatusCallback, isUpdate bool, thumbnail io.Reader, thumbnailSize int64, encryption bool) (*UploadRequest, error) {
+	if reader == nil {
+		return nil, common.NewError("invalid_reader", "Reader to upload from is not set")
+	}
+	if len(remotepath) == 0 || strings.HasSuffix(remotepath, "/") {
+		return nil, common.NewError("invalid_path", "Remote path should include the file name")
+	}
+	remotepath = filepath.Clean(remotepath)
+	isabs := filepath.IsAbs(remotepath)
+	if !isabs {
+		return nil, common.NewError("invalid_path", "Path should be valid and absolute")
+	}
+	if thumbnail != nil && thumbnailSize < 0 {
+		// Thumbnails are small, buffer them to find out the size
+		thumbnailBytes, err := ioutil.ReadAll(thumbnail)
+		if err != nil {
+			return nil, fmt.Errorf("Thumbnail read error: %s", err.Error())
+		}
+		thumbnail = bytes.NewReader(thumbnailBytes)
+		thumbnailSize = int64(len(thumbnailBytes))
+	}
+	if thumbnailSize == 0 {
+		thumbnail = nil
+	}
+
+	uploadReq := a.newUploadRequest(remotepath, size, status, isUpdate, encryption)
+	uploadReq.fileReader = reader
+	uploadReq.thumbnailReader = thumbnail
+	if thumbnail != nil {
+		uploadReq.filemeta.ThumbnailSize = thumbnailSize
+		uploadReq.thumbRemaining = thumbnailSize
+	}
+	return uploadReq, nil
+}
+
+func (a *Allocation) newUploadRequest(remotepath string, size int64, status StatusCallback, isUpdate bool, encryption bool) *UploadRequest {
 	var fileName string
 	_, fileName = filepath.Split(remotepath)
 	uploadReq := &UploadRequest{}
 	uploadReq.remotefilepath = remotepath
-	uploadReq.thumbnailpath = thumbnailpath
-	uploadReq.filepath = localpath
 	uploadReq.filemeta = &UploadFileMeta{}
 	uploadReq.filemeta.Name = fileName
-	uploadReq.filemeta.Size = fileInfo.Size()
+	uploadReq.filemeta.Size = size
 	uploadReq.filemeta.Path = remotepath
-	uploadReq.filemeta.ThumbnailSize = thumbnailSize
 	uploadReq.remaining = uploadReq.filemeta.Size
-	uploadReq.thumbRemaining = uploadReq.filemeta.ThumbnailSize
 	uploadReq.isRepair = false
 	uploadReq.isUpdate = isUpdate
 	uploadReq.connectionID = zboxutil.NewConnectionId()
+	uploadReq.ctx, uploadReq.ctxCncl = context.WithCancel(a.ctx)
 	uploadReq.statusCallback = status
 	uploadReq.datashards = a.DataShards
 	uploadReq.parityshards = a.ParityShards
@@ -205,46 +545,175 @@ func (a *Allocation) uploadOrUpdateFile(localpath string, remotepath string, sta
 	uploadReq.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
 	uploadReq.fullconsensus = float32(a.DataShards + a.ParityShards)
 	uploadReq.isEncrypted = encryption
-	go func() {
-		a.uploadChan <- uploadReq
-	}()
-	return nil
+	if size < 0 {
+		uploadReq.isStream = true
+		uploadReq.remaining = 0
+	}
+	return uploadReq
 }
+
 func (a *Allocation) DownloadFile(localPath string, remotePath string, status StatusCallback) error {
-	return a.downloadFile(localPath, remotePath, DOWNLOAD_CONTENT_FULL, status)
+	return a.downloadFile(localPath, remotePath, DOWNLOAD_CONTENT_FULL, 0, 0, false, status)
+}
+
+// ResumeDownload - downloads the remote file, continuing from the blocks
+// already written to localPath by an earlier ResumeDownload that failed or
+// was canceled. The download starts over if the remote file changed since. A
+// local file that isn't such a partial download is left untouched and the
+// download fails.
+func (a *Allocation) ResumeDownload(localPath string, remotePath string, status StatusCallback) error {
+	return a.downloadFile(localPath, remotePath, DOWNLOAD_CONTENT_FULL, 0, 0, true, status)
 }
 
 func (a *Allocation) DownloadThumbnail(localPath string, remotePath string, status StatusCallback) error {
-	return a.downloadFile(localPath, remotePath, DOWNLOAD_CONTENT_THUMB, status)
+	return a.downloadFile(localPath, remotePath, DOWNLOAD_CONTENT_THUMB, 0, 0, false, status)
+}
+
+// DownloadFileRange - downloads length bytes of the remote file starting at
+// offset. Only the blocks covering the range are fetched from the blobbers.
+func (a *Allocation) DownloadFileRange(localPath string, remotePath string, offset int64, length int64, status StatusCallback) error {
+	if offset < 0 || length <= 0 {
+		return common.NewError("invalid_range", "Offset must not be negative and length must be positive")
+	}
+	return a.downloadFile(localPath, remotePath, DOWNLOAD_CONTENT_FULL, offset, length, false, status)
+}
+
+// DownloadFileCtx - downloads the remote file and returns once it is
+// written to localPath. Canceling ctx aborts the requests to the blobbers.
+// status is optional.
+func (a *Allocation) DownloadFileCtx(ctx context.Context, localPath string, remotePath string, status StatusCallback) error {
+	return a.downloadFileCtx(ctx, localPath, remotePath, DOWNLOAD_CONTENT_FULL, status)
+}
+
+// DownloadThumbnailCtx - downloads the thumbnail of the remote file. See
+// DownloadFileCtx.
+func (a *Allocation) DownloadThumbnailCtx(ctx context.Context, localPath string, remotePath string, status StatusCallback) error {
+	return a.downloadFileCtx(ctx, localPath, remotePath, DOWNLOAD_CONTENT_THUMB, status)
 }
 
-func (a *Allocation) downloadFile(localPath string, remotePath string, contentMode string, status StatusCallback) error {
+func (a *Allocation) downloadFileCtx(ctx context.Context, localPath string, remotePath string, contentMode string, status StatusCallback) error {
 	if !a.isInitialized() {
 		return notInitialized
 	}
-	if stat, err := os.Stat(localPath); err == nil {
+	localPath, err := getDownloadLocalPath(localPath, remotePath, false)
+	if err != nil {
+		return err
+	}
+	if len(a.Blobbers) <= 1 {
+		return noBLOBBERS
+	}
+	downloadStatus := &opStatus{status: status, op: OpDownload}
+	downloadReq := a.newDownloadRequest(localPath, contentMode, downloadStatus)
+	downloadReq.remotefilepath = remotePath
+	return a.processDownloadCtx(ctx, downloadReq, remotePath, downloadStatus)
+}
+
+// processDownloadCtx - runs the download till it completes or ctx is done,
+// tracked by key for CancelDownload
+func (a *Allocation) processDownloadCtx(ctx context.Context, downloadReq *DownloadRequest, key string, downloadStatus *opStatus) error {
+	// Canceled by ctx rather than by the allocation
+	downloadReq.ctxCncl()
+	downloadReq.ctx, downloadReq.ctxCncl = context.WithCancel(ctx)
+	a.trackDownload(key, downloadReq)
+	downloadReq.processDownload(ctx, a)
+	if downloadStatus.err != nil || !downloadStatus.completed {
+		// Failures caused by the cancel are reported as such
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	if downloadStatus.err != nil {
+		return downloadStatus.err
+	}
+	if !downloadStatus.completed {
+		return common.NewError("download_failed", "Download of "+key+" didn't complete")
+	}
+	return nil
+}
+
+// getDownloadLocalPath - the file the remote file is downloaded to, inside
+// localPath when it is a directory. Creates the missing parent directories.
+func getDownloadLocalPath(localPath string, remotePath string, isResume bool) (string, error) {
+	if stat, err := os.Stat(localPath); err == nil && !(isResume && !stat.IsDir()) {
 		if !stat.IsDir() {
-			return fmt.Errorf("Local path is not a directory '%s'", localPath)
+			return "", fmt.Errorf("Local path is not a directory '%s'", localPath)
 		}
 		localPath = strings.TrimRight(localPath, "/")
 		_, rFile := filepath.Split(remotePath)
 		localPath = fmt.Sprintf("%s/%s", localPath, rFile)
-		if _, err := os.Stat(localPath); err == nil {
-			return fmt.Errorf("Local file already exists '%s'", localPath)
+		if _, err := os.Stat(localPath); err == nil && !isResume {
+			return "", fmt.Errorf("Local file already exists '%s'", localPath)
 		}
 	}
+	if stat, err := os.Stat(localPath); err == nil && isResume && stat.Size() > 0 && loadDownloadState(localPath) == nil {
+		return "", fmt.Errorf("Local file '%s' isn't a partial download to resume", localPath)
+	}
 	lPath, _ := filepath.Split(localPath)
 	os.MkdirAll(lPath, os.ModePerm)
+	return localPath, nil
+}
+
+func (a *Allocation) downloadFile(localPath string, remotePath string, contentMode string, offset int64, length int64, isResume bool, status StatusCallback) error {
+	if !a.isInitialized() {
+		return notInitialized
+	}
+	localPath, err := getDownloadLocalPath(localPath, remotePath, isResume)
+	if err != nil {
+		return err
+	}
 
 	if len(a.Blobbers) <= 1 {
 		return noBLOBBERS
 	}
 
+	downloadReq := a.newDownloadRequest(localPath, contentMode, status)
+	downloadReq.remotefilepath = remotePath
+	downloadReq.offset = offset
+	downloadReq.length = length
+	downloadReq.isResume = isResume
+	a.trackDownload(remotePath, downloadReq)
+	go func() {
+		a.downloadChan <- downloadReq
+	}()
+	return nil
+}
+
+// trackDownload - makes the download cancelable by CancelDownload with key
+// until it completes
+func (a *Allocation) trackDownload(key string, downloadReq *DownloadRequest) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.downloadProgressMap[key] = downloadReq
+	downloadReq.completedCallback = func(remotepath string, remotepathhash string) {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		if a.downloadProgressMap[key] == downloadReq {
+			delete(a.downloadProgressMap, key)
+		}
+	}
+}
+
+// trackUpload - makes the upload cancelable by CancelUpload until it is
+// processed
+func (a *Allocation) trackUpload(uploadReq *UploadRequest) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.uploadProgressMap[uploadReq.remotefilepath] = uploadReq
+}
+
+func (a *Allocation) untrackUpload(uploadReq *UploadRequest) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.uploadProgressMap[uploadReq.remotefilepath] == uploadReq {
+		delete(a.uploadProgressMap, uploadReq.remotefilepath)
+	}
+}
+
+func (a *Allocation) newDownloadRequest(localPath string, contentMode string, status StatusCallback) *DownloadRequest {
 	downloadReq := &DownloadRequest{}
 	downloadReq.allocationID = a.ID
-	downloadReq.ctx, _ = context.WithCancel(a.ctx)
+	downloadReq.ctx, downloadReq.ctxCncl = context.WithCancel(a.ctx)
 	downloadReq.localpath = localPath
-	downloadReq.remotefilepath = remotePath
 	downloadReq.statusCallback = status
 	downloadReq.downloadMask = ((1 << uint32(len(a.Blobbers))) - 1)
 	downloadReq.blobbers = a.Blobbers
@@ -253,19 +722,8 @@ func (a *Allocation) downloadFile(localPath string, remotePath string, contentMo
 	downloadReq.numBlocks = int64(numBlockDownloads)
 	downloadReq.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
 	downloadReq.fullconsensus = float32(a.DataShards + a.ParityShards)
-	downloadReq.completedCallback = func(remotepath string, remotepathhash string) {
-		a.mutex.Lock()
-		defer a.mutex.Unlock()
-		delete(a.downloadProgressMap, remotepath)
-	}
 	downloadReq.contentMode = contentMode
-	go func() {
-		a.downloadChan <- downloadReq
-		a.mutex.Lock()
-		defer a.mutex.Unlock()
-		a.downloadProgressMap[remotePath] = downloadReq
-	}()
-	return nil
+	return downloadReq
 }
 
 func (a *Allocation) ListDirFromAuthTicket(authTicket string, lookupHash string) (*ListResult, error) {
@@ -301,6 +759,12 @@ func (a *Allocation) ListDirFromAuthTicket(authTicket string, lookupHash string)
 }
 
 func (a *Allocation) ListDir(path string) (*ListResult, error) {
+	return a.ListDirCtx(a.ctx, path)
+}
+
+// ListDirCtx - ListDir with the requests to the blobbers canceled along with
+// ctx
+func (a *Allocation) ListDirCtx(ctx context.Context, path string) (*ListResult, error) {
 	if !a.isInitialized() {
 		return nil, notInitialized
 	}
@@ -317,7 +781,7 @@ func (a *Allocation) ListDir(path string) (*ListResult, error) {
 	listReq.blobbers = a.Blobbers
 	listReq.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
 	listReq.fullconsensus = float32(a.DataShards + a.ParityShards)
-	listReq.ctx = a.ctx
+	listReq.ctx = ctx
 	listReq.remotefilepath = path
 	ref := listReq.GetListFromBlobbers()
 	if ref != nil {
@@ -327,13 +791,19 @@ func (a *Allocation) ListDir(path string) (*ListResult, error) {
 }
 
 func (a *Allocation) GetFileMeta(path string) (*ConsolidatedFileMeta, error) {
+	return a.GetFileMetaCtx(a.ctx, path)
+}
+
+// GetFileMetaCtx - GetFileMeta with the requests to the blobbers canceled
+// along with ctx
+func (a *Allocation) GetFileMetaCtx(ctx context.Context, path string) (*ConsolidatedFileMeta, error) {
 	result := &ConsolidatedFileMeta{}
 	listReq := &ListRequest{}
 	listReq.allocationID = a.ID
 	listReq.blobbers = a.Blobbers
 	listReq.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
 	listReq.fullconsensus = float32(a.DataShards + a.ParityShards)
-	listReq.ctx = a.ctx
+	listReq.ctx = ctx
 	listReq.remotefilepath = path
 	_, ref, _ := listReq.getFileConsensusFromBlobbers()
 	if ref != nil {
@@ -419,7 +889,31 @@ func (a *Allocation) GetFileStats(path string) (map[string]*FileStats, error) {
 	return nil, common.NewError("file_stats_request_failed", "Failed to get file stats response from the blobbers")
 }
 
+// RepairFile - restores the file on the blobbers that lost it or hold a
+// version out of consensus. It returns once the repair is committed; status
+// is optional and reports the progress as OpRepair.
+func (a *Allocation) RepairFile(remotePath string, status StatusCallback) error {
+	if !a.isInitialized() {
+		return notInitialized
+	}
+	if len(a.Blobbers) <= 1 {
+		return noBLOBBERS
+	}
+	remotePath = filepath.Clean(remotePath)
+	isabs := filepath.IsAbs(remotePath)
+	if !isabs {
+		return common.NewError("invalid_path", "Path should be valid and absolute")
+	}
+	return a.repairFile(remotePath, status)
+}
+
 func (a *Allocation) DeleteFile(path string) error {
+	return a.DeleteFileCtx(a.ctx, path)
+}
+
+// DeleteFileCtx - DeleteFile with the requests to the blobbers canceled
+// along with ctx. A commit once started is not interrupted.
+func (a *Allocation) DeleteFileCtx(ctx context.Context, path string) error {
 	if !a.isInitialized() {
 		return notInitialized
 	}
@@ -437,7 +931,7 @@ func (a *Allocation) DeleteFile(path string) error {
 	req.allocationID = a.ID
 	req.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
 	req.fullconsensus = float32(a.DataShards + a.ParityShards)
-	req.ctx = a.ctx
+	req.ctx = ctx
 	req.remotefilepath = path
 	req.deleteMask = 0
 	req.listMask = 0
@@ -447,6 +941,12 @@ func (a *Allocation) DeleteFile(path string) error {
 }
 
 func (a *Allocation) RenameObject(path string, destName string) error {
+	return a.RenameObjectCtx(a.ctx, path, destName)
+}
+
+// RenameObjectCtx - RenameObject with the requests to the blobbers canceled
+// along with ctx. A commit once started is not interrupted.
+func (a *Allocation) RenameObjectCtx(ctx context.Context, path string, destName string) error {
 	if !a.isInitialized() {
 		return notInitialized
 	}
@@ -465,7 +965,7 @@ func (a *Allocation) RenameObject(path string, destName string) error {
 	req.newName = destName
 	req.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
 	req.fullconsensus = float32(a.DataShards + a.ParityShards)
-	req.ctx = a.ctx
+	req.ctx = ctx
 	req.remotefilepath = path
 	req.renameMask = 0
 	req.connectionID = zboxutil.NewConnectionId()
@@ -474,6 +974,12 @@ func (a *Allocation) RenameObject(path string, destName string) error {
 }
 
 func (a *Allocation) CopyObject(path string, destPath string) error {
+	return a.CopyObjectCtx(a.ctx, path, destPath)
+}
+
+// CopyObjectCtx - CopyObject with the requests to the blobbers canceled
+// along with ctx. A commit once started is not interrupted.
+func (a *Allocation) CopyObjectCtx(ctx context.Context, path string, destPath string) error {
 	if !a.isInitialized() {
 		return notInitialized
 	}
@@ -492,7 +998,7 @@ func (a *Allocation) CopyObject(path string, destPath string) error {
 	req.destPath = destPath
 	req.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
 	req.fullconsensus = float32(a.DataShards + a.ParityShards)
-	req.ctx = a.ctx
+	req.ctx = ctx
 	req.remotefilepath = path
 	req.copyMask = 0
 	req.connectionID = zboxutil.NewConnectionId()
@@ -500,6 +1006,89 @@ func (a *Allocation) CopyObject(path string, destPath string) error {
 	return err
 }
 
+// MoveObject - moves the file or directory at path into destDir. The copy to
+// destDir and the delete of path are committed together, with one write
+// marker per blobber.
+func (a *Allocation) MoveObject(path string, destDir string) error {
+	return a.MoveObjectCtx(a.ctx, path, destDir)
+}
+
+// MoveObjectCtx - MoveObject with the requests to the blobbers canceled
+// along with ctx. A commit once started is not interrupted.
+func (a *Allocation) MoveObjectCtx(ctx context.Context, path string, destDir string) error {
+	if !a.isInitialized() {
+		return notInitialized
+	}
+	if len(path) == 0 || len(destDir) == 0 {
+		return common.NewError("invalid_path", "Invalid path for move")
+	}
+	path = filepath.Clean(path)
+	destDir = filepath.Clean(destDir)
+	if !filepath.IsAbs(path) || !filepath.IsAbs(destDir) {
+		return common.NewError("invalid_path", "Path should be valid and absolute")
+	}
+	if path == "/" {
+		return common.NewError("invalid_path", "Root directory can't be moved")
+	}
+	parent, _ := filepath.Split(path)
+	if filepath.Clean(parent) == destDir {
+		return common.NewError("invalid_path", "Object is already in the destination directory")
+	}
+	if destDir == path || strings.HasPrefix(destDir, path+"/") {
+		return common.NewError("invalid_path", "Directory can't be moved into itself")
+	}
+
+	req := &MoveRequest{}
+	req.blobbers = a.Blobbers
+	req.allocationID = a.ID
+	req.destPath = destDir
+	req.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
+	req.fullconsensus = float32(a.DataShards + a.ParityShards)
+	req.ctx = ctx
+	req.remotefilepath = path
+	req.moveMask = 0
+	req.connectionID = zboxutil.NewConnectionId()
+	err := req.ProcessMove()
+	return err
+}
+
+// CreateDir - creates an empty directory, along with any missing parent
+// directories, on all the blobbers
+func (a *Allocation) CreateDir(remotePath string) error {
+	return a.CreateDirCtx(a.ctx, remotePath)
+}
+
+// CreateDirCtx - CreateDir with the requests to the blobbers canceled along
+// with ctx. A commit once started is not interrupted.
+func (a *Allocation) CreateDirCtx(ctx context.Context, remotePath string) error {
+	if !a.isInitialized() {
+		return notInitialized
+	}
+	if len(remotePath) == 0 {
+		return common.NewError("invalid_path", "Invalid path for the directory")
+	}
+	remotePath = filepath.Clean(remotePath)
+	isabs := filepath.IsAbs(remotePath)
+	if !isabs {
+		return common.NewError("invalid_path", "Path should be valid and absolute")
+	}
+	if remotePath == "/" {
+		return common.NewError("invalid_path", "Root directory already exists")
+	}
+
+	req := &DirRequest{}
+	req.blobbers = a.Blobbers
+	req.allocationID = a.ID
+	req.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
+	req.fullconsensus = float32(a.DataShards + a.ParityShards)
+	req.ctx = ctx
+	req.remotefilepath = remotePath
+	req.dirMask = 0
+	req.connectionID = zboxutil.NewConnectionId()
+	err := req.ProcessDir()
+	return err
+}
+
 func (a *Allocation) GetAuthTicketForShare(path string, filename string, referenceType string, refereeClientID string) (string, error) {
 	return a.GetAuthTicket(path, filename, referenceType, refereeClientID, "")
 }
@@ -543,75 +1132,165 @@ func (a *Allocation) GetAuthTicket(path string, filename string, referenceType s
 	return authTicket, nil
 }
 
+// CancelDownload - cancels the download of remotepath, or of the lookup hash
+// for the downloads from an auth ticket. The requests to the blobbers are
+// aborted, the partial local file is removed and the cancel is reported to
+// the status callback.
 func (a *Allocation) CancelDownload(remotepath string) error {
-	if downloadReq, ok := a.downloadProgressMap[remotepath]; ok {
-		downloadReq.isDownloadCanceled = true
+	a.mutex.Lock()
+	downloadReq, ok := a.downloadProgressMap[remotepath]
+	a.mutex.Unlock()
+	if ok {
+		downloadReq.ctxCncl()
 		return nil
 	}
 	return common.NewError("remote_path_not_found", "Invalid path. Do download in progress for the path "+remotepath)
 }
 
+// CancelUpload - cancels the upload of remotepath, queued or in progress.
+// The requests to the blobbers are aborted and the shards already sent are
+// dropped from them, unless the commit has started, in which case the upload
+// completes. The cancel is reported to the status callback.
+func (a *Allocation) CancelUpload(remotepath string) error {
+	remotepath = filepath.Clean(remotepath)
+	a.mutex.Lock()
+	uploadReq, ok := a.uploadProgressMap[remotepath]
+	a.mutex.Unlock()
+	if ok {
+		uploadReq.ctxCncl()
+		return nil
+	}
+	return common.NewError("upload_not_found", "No upload in progress for the path "+remotepath)
+}
+
 func (a *Allocation) DownloadThumbnailFromAuthTicket(localPath string, authTicket string, remoteLookupHash string, remoteFilename string, status StatusCallback) error {
-	return a.downloadFromAuthTicket(localPath, authTicket, remoteLookupHash, remoteFilename, DOWNLOAD_CONTENT_THUMB, status)
+	return a.downloadFromAuthTicket(localPath, authTicket, remoteLookupHash, remoteFilename, DOWNLOAD_CONTENT_THUMB, 0, 0, false, status)
 }
 
 func (a *Allocation) DownloadFromAuthTicket(localPath string, authTicket string, remoteLookupHash string, remoteFilename string, status StatusCallback) error {
-	return a.downloadFromAuthTicket(localPath, authTicket, remoteLookupHash, remoteFilename, DOWNLOAD_CONTENT_FULL, status)
+	return a.downloadFromAuthTicket(localPath, authTicket, remoteLookupHash, remoteFilename, DOWNLOAD_CONTENT_FULL, 0, 0, false, status)
+}
+
+// ResumeDownloadFromAuthTicket - downloads the shared file, continuing from
+// the blocks already written to localPath. See ResumeDownload.
+func (a *Allocation) ResumeDownloadFromAuthTicket(localPath string, authTicket string, remoteLookupHash string, remoteFilename string, status StatusCallback) error {
+	return a.downloadFromAuthTicket(localPath, authTicket, remoteLookupHash, remoteFilename, DOWNLOAD_CONTENT_FULL, 0, 0, true, status)
 }
 
-func (a *Allocation) downloadFromAuthTicket(localPath string, authTicket string, remoteLookupHash string, remoteFilename string, contentMode string, status StatusCallback) error {
+// DownloadFromAuthTicketRange - downloads length bytes of the shared file
+// starting at offset.
+func (a *Allocation) DownloadFromAuthTicketRange(localPath string, authTicket string, remoteLookupHash string, remoteFilename string, offset int64, length int64, status StatusCallback) error {
+	if offset < 0 || length <= 0 {
+		return common.NewError("invalid_range", "Offset must not be negative and length must be positive")
+	}
+	return a.downloadFromAuthTicket(localPath, authTicket, remoteLookupHash, remoteFilename, DOWNLOAD_CONTENT_FULL, offset, length, false, status)
+}
+
+// DownloadFromAuthTicketCtx - downloads the shared file and returns once it
+// is written to localPath. See DownloadFileCtx.
+func (a *Allocation) DownloadFromAuthTicketCtx(ctx context.Context, localPath string, authTicket string, remoteLookupHash string, remoteFilename string, status StatusCallback) error {
 	if !a.isInitialized() {
 		return notInitialized
 	}
+	at, err := decodeAuthTicket(authTicket)
+	if err != nil {
+		return err
+	}
+	localPath, err = getDownloadLocalPath(localPath, remoteFilename, false)
+	if err != nil {
+		return err
+	}
+	if len(a.Blobbers) <= 1 {
+		return noBLOBBERS
+	}
+	downloadStatus := &opStatus{status: status, op: OpDownload}
+	downloadReq := a.newDownloadRequest(localPath, DOWNLOAD_CONTENT_FULL, downloadStatus)
+	downloadReq.remotefilepathhash = remoteLookupHash
+	downloadReq.authTicket = at
+	return a.processDownloadCtx(ctx, downloadReq, remoteLookupHash, downloadStatus)
+}
+
+func decodeAuthTicket(authTicket string) (*marker.AuthTicket, error) {
 	sEnc, err := base64.StdEncoding.DecodeString(authTicket)
 	if err != nil {
-		return common.NewError("auth_ticket_decode_error", "Error decoding the auth ticket."+err.Error())
+		return nil, common.NewError("auth_ticket_decode_error", "Error decoding the auth ticket."+err.Error())
 	}
 	at := &marker.AuthTicket{}
 	err = json.Unmarshal(sEnc, at)
 	if err != nil {
-		return common.NewError("auth_ticket_decode_error", "Error unmarshaling the auth ticket."+err.Error())
+		return nil, common.NewError("auth_ticket_decode_error", "Error unmarshaling the auth ticket."+err.Error())
 	}
-	if stat, err := os.Stat(localPath); err == nil {
-		if !stat.IsDir() {
-			return fmt.Errorf("Local path is not a directory '%s'", localPath)
-		}
-		localPath = strings.TrimRight(localPath, "/")
-		_, rFile := filepath.Split(remoteFilename)
-		localPath = fmt.Sprintf("%s/%s", localPath, rFile)
-		if _, err := os.Stat(localPath); err == nil {
-			return fmt.Errorf("Local file already exists '%s'", localPath)
-		}
+	return at, nil
+}
+
+func (a *Allocation) downloadFromAuthTicket(localPath string, authTicket string, remoteLookupHash string, remoteFilename string, contentMode string, offset int64, length int64, isResume bool, status StatusCallback) error {
+	if !a.isInitialized() {
+		return notInitialized
+	}
+	at, err := decodeAuthTicket(authTicket)
+	if err != nil {
+		return err
+	}
+	localPath, err = getDownloadLocalPath(localPath, remoteFilename, isResume)
+	if err != nil {
+		return err
 	}
 	if len(a.Blobbers) <= 1 {
 		return noBLOBBERS
 	}
 
-	downloadReq := &DownloadRequest{}
-	downloadReq.allocationID = a.ID
-	downloadReq.ctx, _ = context.WithCancel(a.ctx)
-	downloadReq.localpath = localPath
+	downloadReq := a.newDownloadRequest(localPath, contentMode, status)
 	downloadReq.remotefilepathhash = remoteLookupHash
 	downloadReq.authTicket = at
-	downloadReq.statusCallback = status
-	downloadReq.downloadMask = ((1 << uint32(len(a.Blobbers))) - 1)
-	downloadReq.blobbers = a.Blobbers
-	downloadReq.datashards = a.DataShards
-	downloadReq.parityshards = a.ParityShards
-	downloadReq.contentMode = contentMode
-	downloadReq.numBlocks = int64(numBlockDownloads)
-	downloadReq.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
-	downloadReq.fullconsensus = float32(a.DataShards + a.ParityShards)
-	downloadReq.completedCallback = func(remotepath string, remotepathHash string) {
-		a.mutex.Lock()
-		defer a.mutex.Unlock()
-		delete(a.downloadProgressMap, remotepathHash)
-	}
+	downloadReq.offset = offset
+	downloadReq.length = length
+	downloadReq.isResume = isResume
+	a.trackDownload(remoteLookupHash, downloadReq)
 	go func() {
 		a.downloadChan <- downloadReq
-		a.mutex.Lock()
-		defer a.mutex.Unlock()
-		a.downloadProgressMap[remoteLookupHash] = downloadReq
 	}()
 	return nil
 }
+
+// OpenFile - opens the remote file for reading. The content is downloaded
+// block by block as it is read, so the returned reader must be closed.
+func (a *Allocation) OpenFile(remotePath string) (*FileReader, error) {
+	return a.openFile(remotePath, DOWNLOAD_CONTENT_FULL)
+}
+
+func (a *Allocation) openFile(remotePath string, contentMode string) (*FileReader, error) {
+	return a.openFileFrom(remotePath, contentMode, (1<<uint32(len(a.Blobbers)))-1)
+}
+
+// openFileFrom - opens the remote file for reading from only the blobbers of
+// the mask
+func (a *Allocation) openFileFrom(remotePath string, contentMode string, mask uint32) (*FileReader, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	if len(a.Blobbers) <= 1 {
+		return nil, noBLOBBERS
+	}
+	downloadReq := a.newDownloadRequest("", contentMode, nil)
+	downloadReq.downloadMask = mask
+	downloadReq.remotefilepath = remotePath
+	return newFileReader(downloadReq)
+}
+
+// OpenFileFromAuthTicket - opens the shared file for reading.
+func (a *Allocation) OpenFileFromAuthTicket(authTicket string, remoteLookupHash string) (*FileReader, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	at, err := decodeAuthTicket(authTicket)
+	if err != nil {
+		return nil, err
+	}
+	if len(a.Blobbers) <= 1 {
+		return nil, noBLOBBERS
+	}
+	downloadReq := a.newDownloadRequest("", DOWNLOAD_CONTENT_FULL, nil)
+	downloadReq.remotefilepathhash = remoteLookupHash
+	downloadReq.authTicket = at
+	return newFileReader(downloadReq)
+}
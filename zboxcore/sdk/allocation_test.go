@@ -0,0 +1,107 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/0chain/gosdk/core/encryption"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+)
+
+func TestOperationsCtx(t *testing.T) {
+	ft := newFaultTest(t, "operations_ctx")
+	defer ft.Close()
+	ctx := context.Background()
+	content := randomContent(fileref.CHUNK_SIZE + 10)
+	thumbnail := randomContent(100)
+
+	result, err := ft.a.UploadFromReaderCtx(ctx, bytes.NewReader(content), int64(len(content)), "/reader.bin", nil)
+	if err != nil {
+		t.Fatalf("upload from reader: %v", err)
+	}
+	if result.RemotePath != "/reader.bin" || result.Size != int64(len(content)) {
+		t.Errorf("upload result %+v", result)
+	}
+	updated := randomContent(10)
+	_, err = ft.a.UpdateFromReaderWithThumbnailCtx(ctx, bytes.NewReader(updated), int64(len(updated)), "/reader.bin", bytes.NewReader(thumbnail), -1, nil)
+	if err != nil {
+		t.Fatalf("update from reader: %v", err)
+	}
+	data, err := ft.downloadFrom(t, "/reader.bin", "reader.bin")
+	if err != nil || !bytes.Equal(data, updated) {
+		t.Errorf("updated content: %v", err)
+	}
+
+	localPath := filepath.Join(ft.dir, "local.bin")
+	thumbnailPath := filepath.Join(ft.dir, "thumbnail.jpg")
+	if err := ioutil.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(thumbnailPath, thumbnail, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ft.a.UploadFileWithThumbnailCtx(ctx, localPath, "/thumb.bin", thumbnailPath, nil)
+	if err != nil {
+		t.Fatalf("upload with thumbnail: %v", err)
+	}
+	file, ok := ft.network.Blobbers[0].GetRef("/thumb.bin").(*fileref.FileRef)
+	if !ok || file.ActualThumbnailSize != int64(len(thumbnail)) {
+		t.Errorf("thumbnail not uploaded: %+v", file)
+	}
+
+	if err := ft.a.CreateDirCtx(ctx, "/dir"); err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+	if err := ft.a.MoveObjectCtx(ctx, "/thumb.bin", "/dir"); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if ft.network.Blobbers[0].GetRef("/dir/thumb.bin") == nil || ft.network.Blobbers[0].GetRef("/thumb.bin") != nil {
+		t.Error("file not moved")
+	}
+
+	// Nothing is sent once ctx is done
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ft.a.UploadFromReaderCtx(canceled, bytes.NewReader(content), int64(len(content)), "/canceled.bin", nil); err != context.Canceled {
+		t.Errorf("canceled upload: %v", err)
+	}
+	if err := ft.a.CreateDirCtx(canceled, "/canceled"); err == nil {
+		t.Error("canceled directory created")
+	}
+	if err := ft.a.MoveObjectCtx(canceled, "/reader.bin", "/dir"); err == nil {
+		t.Error("canceled move done")
+	}
+	for _, remotePath := range []string{"/canceled.bin", "/canceled", "/dir/reader.bin"} {
+		if ft.network.Blobbers[0].GetRef(remotePath) != nil {
+			t.Errorf("%s committed", remotePath)
+		}
+	}
+}
+
+func TestCreateDirHash(t *testing.T) {
+	ft := newFaultTest(t, "create_dir_hash")
+	defer ft.Close()
+	if err := ft.a.CreateDir("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	// As a blobber hashes a directory from the hashes of its children
+	for _, b := range ft.network.Blobbers {
+		dir, ok := b.GetRef("/a").(*fileref.Ref)
+		if !ok {
+			t.Fatalf("/a not created on %s", b.ID)
+		}
+		empty, ok := b.GetRef("/a/b").(*fileref.Ref)
+		if !ok {
+			t.Fatalf("/a/b not created on %s", b.ID)
+		}
+		if empty.Hash != encryption.Hash("") || empty.PathHash != encryption.Hash("") {
+			t.Errorf("hashes of the empty directory %q %q", empty.Hash, empty.PathHash)
+		}
+		if dir.Hash != encryption.Hash(empty.Hash) || dir.PathHash != encryption.Hash(empty.PathHash) {
+			t.Errorf("hashes of the parent directory %q %q", dir.Hash, dir.PathHash)
+		}
+	}
+}
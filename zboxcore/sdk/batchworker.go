@@ -0,0 +1,476 @@
+package sdk
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/zboxcore/allocationchange"
+	"github.com/0chain/gosdk/zboxcore/blockchain"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+// Batch - operations queued to be sent under one connection and committed
+// together, with one write marker per blobber. The operations are checked
+// against the committed state of the allocation, so an operation can't work
+// on an object created by an earlier operation of the same batch.
+type Batch struct {
+	allocation   *Allocation
+	connectionID string
+	ops          []*batchOp
+	committed    bool
+	mutex        sync.Mutex
+}
+
+type batchOp struct {
+	operation  string
+	remotePath string
+	localPath  string
+	// Destination directory of a copy or new name of a rename
+	dest   string
+	result *BatchOpResult
+}
+
+// BatchOpResult - outcome of one operation of the batch
+type BatchOpResult struct {
+	Operation  string `json:"operation"`
+	RemotePath string `json:"remote_path"`
+	// Number of blobbers that accepted the operation
+	Blobbers int    `json:"blobbers"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchResult - outcome of Batch.Commit
+type BatchResult struct {
+	ConnectionID string `json:"connection_id"`
+	Committed    bool   `json:"committed"`
+	// Number of blobbers that committed the whole batch
+	Blobbers int              `json:"blobbers"`
+	Results  []*BatchOpResult `json:"results"`
+}
+
+// NewBatch - starts a batch of operations on the allocation
+func (a *Allocation) NewBatch() *Batch {
+	return &Batch{allocation: a, connectionID: zboxutil.NewConnectionId()}
+}
+
+func (b *Batch) add(operation string, remotePath string, localPath string, dest string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.committed {
+		return common.NewError("batch_committed", "Batch is already committed")
+	}
+	op := &batchOp{operation: operation, remotePath: remotePath, localPath: localPath, dest: dest}
+	op.result = &BatchOpResult{Operation: operation, RemotePath: remotePath}
+	b.ops = append(b.ops, op)
+	return nil
+}
+
+func cleanBatchPath(path string) (string, error) {
+	if len(path) == 0 {
+		return "", common.NewError("invalid_path", "Invalid path for the batch")
+	}
+	path = filepath.Clean(path)
+	if !filepath.IsAbs(path) {
+		return "", common.NewError("invalid_path", "Path should be valid and absolute")
+	}
+	return path, nil
+}
+
+// UploadFile - queues the upload of a new file
+func (b *Batch) UploadFile(localpath string, remotepath string) error {
+	return b.addUpload(localpath, remotepath, allocationchange.INSERT_OPERATION)
+}
+
+// UpdateFile - queues the update of an existing file
+func (b *Batch) UpdateFile(localpath string, remotepath string) error {
+	return b.addUpload(localpath, remotepath, allocationchange.UPDATE_OPERATION)
+}
+
+func (b *Batch) addUpload(localpath string, remotepath string, operation string) error {
+	fileInfo, err := os.Stat(localpath)
+	if err != nil {
+		return fmt.Errorf("Local file error: %s", err.Error())
+	}
+	if fileInfo.IsDir() {
+		return common.NewError("invalid_path", localpath+" is a directory")
+	}
+	remotepath, err = cleanBatchPath(remotepath)
+	if err != nil {
+		return err
+	}
+	remotepath = zboxutil.GetFullRemotePath(localpath, remotepath)
+	return b.add(operation, remotepath, localpath, "")
+}
+
+// DeleteFile - queues the delete of a file or directory
+func (b *Batch) DeleteFile(path string) error {
+	path, err := cleanBatchPath(path)
+	if err != nil {
+		return err
+	}
+	return b.add(allocationchange.DELETE_OPERATION, path, "", "")
+}
+
+// RenameObject - queues the rename of a file or directory
+func (b *Batch) RenameObject(path string, destName string) error {
+	path, err := cleanBatchPath(path)
+	if err != nil {
+		return err
+	}
+	if len(destName) == 0 {
+		return common.NewError("invalid_name", "New name is not set")
+	}
+	return b.add(allocationchange.RENAME_OPERATION, path, "", destName)
+}
+
+// CopyObject - queues the copy of a file or directory to destPath
+func (b *Batch) CopyObject(path string, destPath string) error {
+	path, err := cleanBatchPath(path)
+	if err != nil {
+		return err
+	}
+	destPath, err = cleanBatchPath(destPath)
+	if err != nil {
+		return err
+	}
+	return b.add(allocationchange.COPY_OPERATION, path, "", destPath)
+}
+
+// CreateDir - queues the creation of a directory
+func (b *Batch) CreateDir(remotePath string) error {
+	remotePath, err := cleanBatchPath(remotePath)
+	if err != nil {
+		return err
+	}
+	if remotePath == "/" {
+		return common.NewError("invalid_path", "Root directory already exists")
+	}
+	return b.add(allocationchange.CREATEDIR_OPERATION, remotePath, "", "")
+}
+
+// Commit - sends the queued operations in order and commits them together.
+// A blobber is committed only if it accepted every operation, and nothing is
+// committed once an operation fails to reach consensus. The operations not
+// committed are dropped from the blobbers. The returned result holds the
+// outcome of each operation as well as of the commit.
+//
+// Commit is not atomic across the blobbers. Each blobber commits on its own,
+// so when the commit fails on some of them the others keep the batch, the
+// same as with a failed upload.
+func (b *Batch) Commit() (*BatchResult, error) {
+	a := b.allocation
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	b.mutex.Lock()
+	if b.committed {
+		b.mutex.Unlock()
+		return nil, common.NewError("batch_committed", "Batch is already committed")
+	}
+	b.committed = true
+	b.mutex.Unlock()
+	if len(b.ops) == 0 {
+		return nil, common.NewError("empty_batch", "No operations in the batch")
+	}
+
+	result := &BatchResult{ConnectionID: b.connectionID}
+	for _, op := range b.ops {
+		result.Results = append(result.Results, op.result)
+	}
+	changes := make([][]allocationchange.AllocationChange, len(a.Blobbers))
+	// A blobber that failed an operation may still hold a part of it
+	sentMask := uint32((1 << uint32(len(a.Blobbers))) - 1)
+	commitMask := sentMask
+	for idx, op := range b.ops {
+		opMask, opChanges, err := b.sendOp(op)
+		op.result.Blobbers = bits.OnesCount32(opMask)
+		if err != nil {
+			op.result.Error = err.Error()
+			for _, skipped := range b.ops[idx+1:] {
+				skipped.result.Error = "Skipped, an earlier operation of the batch failed"
+			}
+			b.dropConnection(sentMask)
+			return result, fmt.Errorf("Batch failed: %s %s: %s", op.operation, op.remotePath, err.Error())
+		}
+		commitMask &= opMask
+		for pos, change := range opChanges {
+			if change != nil {
+				changes[pos] = append(changes[pos], change)
+			}
+		}
+	}
+
+	consensus := b.newConsensus(commitMask)
+	if !consensus.isConsensusOk() {
+		b.dropConnection(sentMask)
+		return result, fmt.Errorf("Batch failed: Too few blobbers accepted every operation. Success_rate:%2f, expected:%2f", consensus.getConsensusRate(), consensus.getConsensusRequiredForOk())
+	}
+
+	consensus.consensus = 0
+	wg := &sync.WaitGroup{}
+	wg.Add(bits.OnesCount32(commitMask))
+	commitReqs := make([]*CommitRequest, bits.OnesCount32(commitMask))
+	commitMasks := make([]uint32, len(commitReqs))
+	c, pos := 0, 0
+	for i := commitMask; i != 0; i &= ^(1 << uint32(pos)) {
+		pos = bits.TrailingZeros32(i)
+		commitReq := &CommitRequest{}
+		commitReq.allocationID = a.ID
+		commitReq.blobber = a.Blobbers[pos]
+		commitReq.changes = changes[pos]
+		commitReq.connectionID = b.connectionID
+		commitReq.wg = wg
+		commitReqs[c] = commitReq
+		commitMasks[c] = 1 << uint32(pos)
+		go AddCommitRequest(commitReq)
+		c++
+	}
+	wg.Wait()
+
+	committedMask := uint32(0)
+	for idx, commitReq := range commitReqs {
+		if commitReq.result != nil {
+			if commitReq.result.Success {
+				Logger.Info("Commit success", commitReq.blobber.Baseurl)
+				consensus.consensus++
+				committedMask |= commitMasks[idx]
+			} else {
+				Logger.Info("Commit failed", commitReq.blobber.Baseurl, commitReq.result.ErrorMessage)
+			}
+		} else {
+			Logger.Info("Commit result not set", commitReq.blobber.Baseurl)
+		}
+	}
+	result.Blobbers = int(consensus.consensus)
+	// Blobbers that failed an operation or the commit
+	b.dropConnection(sentMask &^ committedMask)
+	if !consensus.isConsensusOk() {
+		return result, fmt.Errorf("Batch failed: Commit consensus failed")
+	}
+	result.Committed = true
+	return result, nil
+}
+
+// dropConnection - drops the operations sent under the batch connection from
+// the blobbers in the mask
+func (b *Batch) dropConnection(mask uint32) {
+	a := b.allocation
+	wg := &sync.WaitGroup{}
+	for pos, blobber := range a.Blobbers {
+		if mask&(1<<uint32(pos)) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(blobber *blockchain.StorageNode) {
+			defer wg.Done()
+			err := deleteConnection(a.ctx, blobber, a.ID, b.connectionID)
+			if err != nil {
+				Logger.Error(blobber.Baseurl, " dropping the batch failed: ", err)
+			}
+		}(blobber)
+	}
+	wg.Wait()
+}
+
+func (b *Batch) newConsensus(mask uint32) *Consensus {
+	a := b.allocation
+	consensus := &Consensus{}
+	consensus.consensus = float32(bits.OnesCount32(mask))
+	consensus.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
+	consensus.fullconsensus = float32(a.DataShards + a.ParityShards)
+	return consensus
+}
+
+// sendOp - sends the operation to the blobbers under the batch connection.
+// Returns the blobbers that accepted it and the change to commit on each.
+func (b *Batch) sendOp(op *batchOp) (uint32, []allocationchange.AllocationChange, error) {
+	a := b.allocation
+	if op.operation == allocationchange.INSERT_OPERATION || op.operation == allocationchange.UPDATE_OPERATION {
+		return b.sendUpload(op)
+	}
+
+	var send func(blobber *blockchain.StorageNode, blobberIdx int) (fileref.RefEntity, error)
+	var newChange func(objectTree fileref.RefEntity) allocationchange.AllocationChange
+	switch op.operation {
+	case allocationchange.DELETE_OPERATION:
+		send = func(blobber *blockchain.StorageNode, blobberIdx int) (fileref.RefEntity, error) {
+			refEntity, err := getObjectTreeFromBlobber(a.ctx, a.ID, op.remotePath, blobber)
+			if err != nil {
+				return nil, err
+			}
+			req := &DeleteRequest{}
+			req.allocationID = a.ID
+			req.blobbers = a.Blobbers
+			req.remotefilepath = op.remotePath
+			req.ctx = a.ctx
+			req.connectionID = b.connectionID
+			req.wg = &sync.WaitGroup{}
+			req.wg.Add(1)
+			req.deleteBlobberFile(blobber, blobberIdx, refEntity)
+			if req.deleteMask == 0 {
+				return nil, fmt.Errorf("%s delete of %s failed", blobber.Baseurl, op.remotePath)
+			}
+			return refEntity, nil
+		}
+		newChange = func(objectTree fileref.RefEntity) allocationchange.AllocationChange {
+			change := &allocationchange.DeleteFileChange{}
+			change.ObjectTree = objectTree
+			change.NumBlocks = objectTree.GetNumBlocks()
+			change.Operation = allocationchange.DELETE_OPERATION
+			change.Size = objectTree.GetSize()
+			return change
+		}
+	case allocationchange.RENAME_OPERATION:
+		send = func(blobber *blockchain.StorageNode, blobberIdx int) (fileref.RefEntity, error) {
+			req := &RenameRequest{}
+			req.allocationID = a.ID
+			req.blobbers = a.Blobbers
+			req.remotefilepath = op.remotePath
+			req.newName = op.dest
+			req.ctx = a.ctx
+			req.connectionID = b.connectionID
+			refEntity, err := req.renameBlobberObject(blobber, blobberIdx)
+			if err != nil {
+				return nil, err
+			}
+			if req.renameMask == 0 {
+				return nil, fmt.Errorf("%s rename of %s failed", blobber.Baseurl, op.remotePath)
+			}
+			return refEntity, nil
+		}
+		newChange = func(objectTree fileref.RefEntity) allocationchange.AllocationChange {
+			change := &allocationchange.RenameFileChange{}
+			change.NewName = op.dest
+			change.ObjectTree = objectTree
+			change.NumBlocks = 0
+			change.Operation = allocationchange.RENAME_OPERATION
+			change.Size = 0
+			return change
+		}
+	case allocationchange.COPY_OPERATION:
+		send = func(blobber *blockchain.StorageNode, blobberIdx int) (fileref.RefEntity, error) {
+			req := &CopyRequest{}
+			req.allocationID = a.ID
+			req.blobbers = a.Blobbers
+			req.remotefilepath = op.remotePath
+			req.destPath = op.dest
+			req.ctx = a.ctx
+			req.connectionID = b.connectionID
+			refEntity, err := req.copyBlobberObject(blobber, blobberIdx)
+			if err != nil {
+				return nil, err
+			}
+			if req.copyMask == 0 {
+				return nil, fmt.Errorf("%s copy of %s failed", blobber.Baseurl, op.remotePath)
+			}
+			return refEntity, nil
+		}
+		newChange = func(objectTree fileref.RefEntity) allocationchange.AllocationChange {
+			change := &allocationchange.CopyFileChange{}
+			change.DestPath = op.dest
+			change.ObjectTree = objectTree
+			change.NumBlocks = 0
+			change.Operation = allocationchange.COPY_OPERATION
+			change.Size = 0
+			return change
+		}
+	case allocationchange.CREATEDIR_OPERATION:
+		send = func(blobber *blockchain.StorageNode, blobberIdx int) (fileref.RefEntity, error) {
+			req := &DirRequest{}
+			req.allocationID = a.ID
+			req.blobbers = a.Blobbers
+			req.remotefilepath = op.remotePath
+			req.ctx = a.ctx
+			req.connectionID = b.connectionID
+			err := req.createBlobberDir(blobber, blobberIdx)
+			if err != nil {
+				return nil, err
+			}
+			if req.dirMask == 0 {
+				return nil, fmt.Errorf("%s create dir %s failed", blobber.Baseurl, op.remotePath)
+			}
+			return nil, nil
+		}
+		newChange = func(objectTree fileref.RefEntity) allocationchange.AllocationChange {
+			change := &allocationchange.DirCreateChange{}
+			change.RemotePath = op.remotePath
+			change.NumBlocks = 0
+			change.Operation = allocationchange.CREATEDIR_OPERATION
+			change.Size = 0
+			return change
+		}
+	default:
+		return 0, nil, common.NewError("invalid_operation", "Unknown batch operation "+op.operation)
+	}
+
+	mask := uint32(0)
+	changes := make([]allocationchange.AllocationChange, len(a.Blobbers))
+	mutex := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	wg.Add(len(a.Blobbers))
+	for i := range a.Blobbers {
+		go func(blobberIdx int) {
+			defer wg.Done()
+			refEntity, err := send(a.Blobbers[blobberIdx], blobberIdx)
+			if err != nil {
+				Logger.Error(err.Error())
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			mask |= (1 << uint32(blobberIdx))
+			changes[blobberIdx] = newChange(refEntity)
+		}(i)
+	}
+	wg.Wait()
+	if !b.newConsensus(mask).isConsensusOk() {
+		return mask, nil, fmt.Errorf("Consensus not met, accepted by %d of %d blobbers", bits.OnesCount32(mask), len(a.Blobbers))
+	}
+	return mask, changes, nil
+}
+
+// sendUpload - pushes the file to the blobbers under the batch connection
+func (b *Batch) sendUpload(op *batchOp) (uint32, []allocationchange.AllocationChange, error) {
+	a := b.allocation
+	fileInfo, err := os.Stat(op.localPath)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Local file error: %s", err.Error())
+	}
+	status := &opStatus{op: OpUpload}
+	req := a.newUploadRequest(op.remotePath, fileInfo.Size(), status, op.operation == allocationchange.UPDATE_OPERATION, false)
+	req.filepath = op.localPath
+	req.connectionID = b.connectionID
+	defer req.ctxCncl()
+	_, ok := req.pushUpload(a.ctx, a)
+	if status.err != nil {
+		return 0, nil, status.err
+	}
+	if !ok {
+		return 0, nil, common.NewError("upload_failed", "Upload of "+op.localPath+" failed")
+	}
+
+	mask := uint32(0)
+	changes := make([]allocationchange.AllocationChange, len(a.Blobbers))
+	c, pos := 0, 0
+	for i := req.uploadMask; i != 0; i &= ^(1 << uint32(pos)) {
+		pos = bits.TrailingZeros32(i)
+		file := req.file[c]
+		c++
+		// The content hash is set once the blobber accepts the shard
+		if len(file.ContentHash) == 0 {
+			continue
+		}
+		mask |= (1 << uint32(pos))
+		changes[pos] = req.newChange(pos, file)
+	}
+	if !b.newConsensus(mask).isConsensusOk() {
+		return mask, nil, fmt.Errorf("Consensus not met, accepted by %d of %d blobbers", bits.OnesCount32(mask), len(a.Blobbers))
+	}
+	return mask, changes, nil
+}
@@ -0,0 +1,130 @@
+package sdk
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/0chain/gosdk/zboxcore/allocationchange"
+	"github.com/0chain/gosdk/zboxcore/blobbertest"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+func TestBatchFailureDropsConnection(t *testing.T) {
+	ft := newFaultTest(t, "batch_failure")
+	defer ft.Close()
+	roots := make([]string, len(ft.network.Blobbers))
+	for i, b := range ft.network.Blobbers {
+		roots[i] = b.AllocationRoot()
+	}
+
+	// The upload and the dir are sent before the delete fails
+	batch := ft.a.NewBatch()
+	err := batch.UploadFile(filepath.Join(ft.dir, "file.bin"), "/batch.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = batch.CreateDir("/batch_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = batch.DeleteFile("/missing.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = batch.Commit()
+	if err == nil {
+		t.Fatal("batch with a failing operation committed")
+	}
+	for i, b := range ft.network.Blobbers {
+		if b.PendingConnections() != 0 || b.AllocationRoot() != roots[i] {
+			t.Fatalf("blobber %d kept the failed batch", i)
+		}
+	}
+
+	// A blobber rejecting the commit doesn't keep the batch either
+	ft.injector.SetFaults(ft.blobberURL(2), blobbertest.Fault{Operations: []string{zboxutil.OperationCommit}, ErrorRate: 1, ErrorStatus: http.StatusBadRequest})
+	batch = ft.a.NewBatch()
+	err = batch.CreateDir("/batch_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = batch.Commit()
+	if err == nil {
+		t.Fatal("batch committed with a commit rejected")
+	}
+	if ft.network.Blobbers[2].PendingConnections() != 0 {
+		t.Fatal("blobber rejecting the commit kept the batch")
+	}
+}
+
+func TestBatchCommit(t *testing.T) {
+	ft := newFaultTest(t, "batch_commit")
+	defer ft.Close()
+	err := ft.a.CreateDir("/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := make([]string, len(ft.network.Blobbers))
+	for i, b := range ft.network.Blobbers {
+		roots[i] = b.AllocationRoot()
+	}
+
+	batch := ft.a.NewBatch()
+	steps := []struct {
+		add        func() error
+		operation  string
+		remotePath string
+	}{
+		{func() error { return batch.UploadFile(filepath.Join(ft.dir, "file.bin"), "/batch.bin") }, allocationchange.INSERT_OPERATION, "/batch.bin"},
+		{func() error { return batch.CreateDir("/batch_dir") }, allocationchange.CREATEDIR_OPERATION, "/batch_dir"},
+		{func() error { return batch.CopyObject("/file.bin", "/docs") }, allocationchange.COPY_OPERATION, "/file.bin"},
+		{func() error { return batch.RenameObject("/file.bin", "renamed.bin") }, allocationchange.RENAME_OPERATION, "/file.bin"},
+	}
+	for _, step := range steps {
+		err = step.add()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := batch.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Committed || result.Blobbers != len(ft.network.Blobbers) || len(result.Results) != len(steps) {
+		t.Fatalf("result %+v", result)
+	}
+	for i, step := range steps {
+		opResult := result.Results[i]
+		if opResult.Operation != step.operation || opResult.RemotePath != step.remotePath || opResult.Blobbers != len(ft.network.Blobbers) || len(opResult.Error) > 0 {
+			t.Errorf("operation %d: %+v", i, opResult)
+		}
+	}
+
+	// One write marker for the whole batch, right after the previous one
+	for i, b := range ft.network.Blobbers {
+		wm := b.LatestWriteMarker()
+		if wm.PreviousAllocationRoot != roots[i] || b.PendingConnections() != 0 {
+			t.Errorf("blobber %d committed more than one write marker", i)
+		}
+		for _, p := range []string{"/batch.bin", "/batch_dir", "/docs/file.bin", "/renamed.bin"} {
+			if b.GetRef(p) == nil {
+				t.Errorf("blobber %d: %s missing", i, p)
+			}
+		}
+		if b.GetRef("/file.bin") != nil {
+			t.Errorf("blobber %d: renamed file left", i)
+		}
+	}
+	for _, p := range []string{"/batch.bin", "/docs/file.bin", "/renamed.bin"} {
+		content, err := ft.downloadFrom(t, p, filepath.Base(p)+".read")
+		if err != nil || !bytes.Equal(content, ft.content) {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+
+	if _, err = batch.Commit(); err == nil {
+		t.Error("batch committed twice")
+	}
+}
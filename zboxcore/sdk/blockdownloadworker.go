@@ -9,7 +9,6 @@ import (
 	"mime/multipart"
 	"net/http"
 	"sync"
-	"time"
 
 	"github.com/0chain/gosdk/core/common"
 	"github.com/0chain/gosdk/zboxcore/blockchain"
@@ -165,8 +164,7 @@ func (req *BlockDownloadRequest) downloadBlobberBlock() {
 			return
 		}
 		httpreq.Header.Add("Content-Type", formWriter.FormDataContentType())
-		// TODO: Fix the timeout
-		ctx, cncl := context.WithTimeout(req.ctx, (time.Second * 30))
+		ctx, cncl := context.WithCancel(req.ctx)
 		shouldRetry := false
 		err = zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
 			if err != nil {
@@ -179,9 +177,10 @@ func (req *BlockDownloadRequest) downloadBlobberBlock() {
 				//req.consensus++
 				
 				response, err := ioutil.ReadAll(resp.Body)
-				// if err != nil {
-				// 	return fmt.Errorf("[%d] Read error:%s\n", req.blobberIdx, err.Error())
-				// }
+				if err != nil {
+					// A body dropped mid-stream isn't a shard
+					return fmt.Errorf("[%d] Read error:%s\n", req.blobberIdx, err.Error())
+				}
 				var rspData downloadBlock
 				rspData.idx = req.blobberIdx
 				// dec := json.NewDecoder(resp.Body)
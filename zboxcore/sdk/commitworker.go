@@ -10,7 +10,6 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
-	"time"
 
 	"github.com/0chain/gosdk/core/common"
 	"github.com/0chain/gosdk/core/encryption"
@@ -50,6 +49,9 @@ type CommitRequest struct {
 	connectionID string
 	wg           *sync.WaitGroup
 	result       *CommitResult
+	// Set by the callers retrying the commit themselves, bounding all the
+	// attempts
+	ctx context.Context
 }
 
 var commitChan map[string]chan *CommitRequest
@@ -109,7 +111,11 @@ func (commitreq *CommitRequest) processCommit() {
 		Logger.Error("Creating ref path req", err)
 		return
 	}
-	ctx, cncl := context.WithTimeout(context.Background(), (time.Second * 30))
+	ctx, cncl := commitreq.ctx, context.CancelFunc(func() {})
+	if ctx == nil {
+		ctx, cncl = newCommitContext(zboxutil.GetRetryPolicy())
+	}
+	defer cncl()
 	err = zboxutil.HttpDo(ctx, cncl, req, func(resp *http.Response, err error) error {
 		if err != nil {
 			Logger.Error("Ref path error:", err)
@@ -177,7 +183,7 @@ func (commitreq *CommitRequest) processCommit() {
 		commitreq.wg.Done()
 		return
 	}
-	err = commitreq.commitBlobber(rootRef, lR.LatestWM, size)
+	err = commitreq.commitBlobber(ctx, rootRef, lR.LatestWM, size)
 	if err != nil {
 		commitreq.result = ErrorCommitResult(err.Error())
 		commitreq.wg.Done()
@@ -187,7 +193,17 @@ func (commitreq *CommitRequest) processCommit() {
 	commitreq.wg.Done()
 }
 
-func (req *CommitRequest) commitBlobber(rootRef *fileref.Ref, latestWM *marker.WriteMarker, size int64) error {
+// newCommitContext - bounds the reference path and the commit requests, with
+// their retries, by the time the policy lets them take
+func newCommitContext(policy *zboxutil.RetryPolicy) (context.Context, context.CancelFunc) {
+	deadline := policy.GetDeadline(zboxutil.OperationReferencePath, zboxutil.OperationCommit)
+	if deadline <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), deadline)
+}
+
+func (req *CommitRequest) commitBlobber(ctx context.Context, rootRef *fileref.Ref, latestWM *marker.WriteMarker, size int64) error {
 	wm := &marker.WriteMarker{}
 	timestamp := common.Now()
 	wm.AllocationRoot = encryption.Hash(rootRef.Hash + ":" + strconv.FormatInt(timestamp, 10))
@@ -224,9 +240,8 @@ func (req *CommitRequest) commitBlobber(rootRef *fileref.Ref, latestWM *marker.W
 		return err
 	}
 	httpreq.Header.Add("Content-Type", formWriter.FormDataContentType())
-	ctx, cncl := context.WithTimeout(context.Background(), (time.Second * 60))
 	Logger.Info("Committing to blobber." + req.blobber.Baseurl)
-	err = zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
+	err = zboxutil.HttpDo(ctx, func() {}, httpreq, func(resp *http.Response, err error) error {
 		if err != nil {
 			Logger.Error("Commit: ", err)
 			return err
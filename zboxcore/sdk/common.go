@@ -6,7 +6,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"encoding/json"
-	"time"
 
 	"github.com/0chain/gosdk/zboxcore/blockchain"
 	"github.com/0chain/gosdk/zboxcore/fileref"
@@ -22,7 +21,7 @@ func getObjectTreeFromBlobber(ctx context.Context, allocationID string, remotefi
 		return nil, err
 	}
 	var lR ReferencePathResult
-	ctx, cncl := context.WithTimeout(ctx, (time.Second * 30))
+	ctx, cncl := context.WithCancel(ctx)
 	err = zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
 		if err != nil {
 			Logger.Error("Object tree:", err)
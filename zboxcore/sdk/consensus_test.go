@@ -0,0 +1,285 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0chain/gosdk/zboxcore/blobbertest"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+const testSignatureScheme = "ed25519"
+
+func TestConsensusOk(t *testing.T) {
+	// 2 data and 1 parity shards
+	thresh := float32(2*100) / 3
+	tests := []struct {
+		consensus float32
+		ok        bool
+		min       bool
+	}{
+		{3, true, true},
+		{2, false, true},
+		{1, false, false},
+		{0, false, false},
+	}
+	for _, tt := range tests {
+		c := &Consensus{consensus: tt.consensus, consensusThresh: thresh, fullconsensus: 3}
+		if c.isConsensusOk() != tt.ok || c.isConsensusMin() != tt.min {
+			t.Errorf("consensus %v of 3: ok %v min %v, expected %v %v", tt.consensus, c.isConsensusOk(), c.isConsensusMin(), tt.ok, tt.min)
+		}
+	}
+}
+
+// faultTest - an allocation of 2 data and 1 parity shards on mock blobbers,
+// with a file uploaded and the faults injected into the requests to them
+type faultTest struct {
+	a        *Allocation
+	network  *blobbertest.Network
+	injector *blobbertest.FaultInjector
+	dir      string
+	content  []byte
+}
+
+func newFaultTest(t *testing.T, allocationID string) *faultTest {
+	return newFaultTestWith(t, allocationID, false)
+}
+
+// newFaultTestWith - the fault test on blobbers taking the shards of the
+// uploads in parts if chunkedUploads
+func newFaultTestWith(t *testing.T, allocationID string, chunkedUploads bool) *faultTest {
+	wallet, walletJSON, err := blobbertest.NewWallet(testSignatureScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitStorageSDK(walletJSON, nil, nil, "", testSignatureScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := blobbertest.NewConfig(allocationID, wallet, testSignatureScheme)
+	config.ChunkedUploads = chunkedUploads
+	ft := &faultTest{
+		network:  blobbertest.NewNetwork(3, config),
+		injector: blobbertest.NewFaultInjector(nil, 1),
+	}
+	ft.a = &Allocation{}
+	err = json.Unmarshal(ft.network.AllocationJSON(2), ft.a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.a.InitAllocation()
+	zboxutil.SetHTTPClient(ft.injector.Client())
+	zboxutil.SetRetryPolicy(&zboxutil.RetryPolicy{
+		DefaultTimeout: 200 * time.Millisecond,
+		Timeouts:       map[string]time.Duration{zboxutil.OperationUpload: 0},
+		MaxRetries:     1,
+		InitialBackoff: time.Millisecond,
+	})
+	ft.dir, err = ioutil.TempDir("", "sdkfaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Whole blocks of both data shards, so that no bit is erasure padding
+	ft.content = make([]byte, 4*fileref.CHUNK_SIZE)
+	rand.Read(ft.content)
+	localPath := filepath.Join(ft.dir, "file.bin")
+	err = ioutil.WriteFile(localPath, ft.content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ft.a.UploadFileCtx(context.Background(), localPath, "/file.bin", nil)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	return ft
+}
+
+func (ft *faultTest) Close() {
+	zboxutil.SetHTTPClient(nil)
+	zboxutil.SetRetryPolicy(nil)
+	ft.network.Close()
+	os.RemoveAll(ft.dir)
+}
+
+func (ft *faultTest) blobberURL(idx int) string {
+	return ft.network.Blobbers[idx].URL()
+}
+
+func (ft *faultTest) fileConsensus() (uint32, *fileref.FileRef) {
+	listReq := &ListRequest{allocationID: ft.a.ID, blobbers: ft.a.Blobbers, remotefilepath: "/file.bin", ctx: context.Background()}
+	listReq.consensusThresh = (float32(ft.a.DataShards) * 100) / float32(ft.a.DataShards+ft.a.ParityShards)
+	listReq.fullconsensus = float32(ft.a.DataShards + ft.a.ParityShards)
+	mask, ref, _ := listReq.getFileConsensusFromBlobbers()
+	return mask, ref
+}
+
+func (ft *faultTest) download(t *testing.T, name string) ([]byte, error) {
+	return ft.downloadFrom(t, "/file.bin", name)
+}
+
+// downloadFrom - downloads the remote file to name in the test directory
+func (ft *faultTest) downloadFrom(t *testing.T, remotePath string, name string) ([]byte, error) {
+	localPath := filepath.Join(ft.dir, name)
+	err := ft.a.DownloadFileCtx(context.Background(), localPath, remotePath, nil)
+	if err != nil {
+		if _, statErr := os.Stat(localPath); statErr == nil {
+			t.Errorf("%s: failed download left the file", name)
+		}
+		return nil, err
+	}
+	return ioutil.ReadFile(localPath)
+}
+
+func TestFileConsensusWithFaults(t *testing.T) {
+	ft := newFaultTest(t, "file_consensus_faults")
+	defer ft.Close()
+	meta := []string{zboxutil.OperationFileMeta}
+	tests := []struct {
+		name   string
+		faults map[int]blobbertest.Fault
+		mask   uint32
+	}{
+		{"no faults", nil, 7},
+		{"latency within the timeout", map[int]blobbertest.Fault{0: {Operations: meta, Latency: 50 * time.Millisecond}}, 7},
+		{"latency beyond the timeout", map[int]blobbertest.Fault{0: {Operations: meta, Latency: time.Second}}, 6},
+		{"server error", map[int]blobbertest.Fault{1: {Operations: meta, ErrorRate: 1, ErrorStatus: http.StatusInternalServerError}}, 5},
+		{"connection reset", map[int]blobbertest.Fault{2: {Operations: meta, ErrorRate: 1}}, 3},
+		{"truncated body", map[int]blobbertest.Fault{0: {Operations: meta, TruncateRate: 1}}, 6},
+		{"another operation", map[int]blobbertest.Fault{0: {Operations: []string{zboxutil.OperationDownload}, ErrorRate: 1}}, 7},
+		{"two blobbers failing", map[int]blobbertest.Fault{
+			0: {Operations: meta, ErrorRate: 1, ErrorStatus: http.StatusServiceUnavailable},
+			2: {Operations: meta, TruncateRate: 1},
+		}, 0},
+	}
+	expected := ft.network.Blobbers[0].GetRef("/file.bin").(*fileref.FileRef).ActualFileHash
+	for _, tt := range tests {
+		ft.injector.Clear()
+		for idx, fault := range tt.faults {
+			ft.injector.SetFaults(ft.blobberURL(idx), fault)
+		}
+		mask, ref := ft.fileConsensus()
+		if mask != tt.mask {
+			t.Errorf("%s: mask %03b, expected %03b", tt.name, mask, tt.mask)
+		}
+		if tt.mask == 0 && ref != nil {
+			t.Errorf("%s: file meta found without consensus", tt.name)
+		}
+		if tt.mask != 0 && (ref == nil || ref.ActualFileHash != expected) {
+			t.Errorf("%s: wrong file meta %+v", tt.name, ref)
+		}
+	}
+
+	// A flipped bit fails the parse or changes the meta, or hits a field the
+	// consensus doesn't compare. The meta of the intact blobbers is chosen.
+	ft.injector.Clear()
+	ft.injector.SetFaults(ft.blobberURL(0), blobbertest.Fault{Operations: meta, BitFlipRate: 1})
+	mask, ref := ft.fileConsensus()
+	if ft.injector.Injected(ft.blobberURL(0)) == 0 {
+		t.Fatal("no bit flipped")
+	}
+	if mask&6 != 6 || ref == nil || ref.ActualFileHash != expected {
+		t.Errorf("bit flip: mask %03b, meta %+v", mask, ref)
+	}
+}
+
+func TestDownloadWithFaults(t *testing.T) {
+	ft := newFaultTest(t, "download_faults")
+	defer ft.Close()
+	download := []string{zboxutil.OperationDownload}
+
+	// Any one shard is rebuilt from the other two
+	for idx := range ft.network.Blobbers {
+		for name, fault := range map[string]blobbertest.Fault{
+			"server error":   {Operations: download, ErrorRate: 1, ErrorStatus: http.StatusInternalServerError},
+			"truncated body": {Operations: download, TruncateRate: 1, TruncateAt: fileref.CHUNK_SIZE / 2},
+		} {
+			ft.injector.Clear()
+			ft.injector.SetFaults(ft.blobberURL(idx), fault)
+			data, err := ft.download(t, fmt.Sprintf("%d_%s.bin", idx, strings.Replace(name, " ", "_", -1)))
+			if err != nil {
+				t.Fatalf("%s from blobber %d: %v", name, idx, err)
+			}
+			if !bytes.Equal(data, ft.content) {
+				t.Fatalf("%s from blobber %d: downloaded content differs", name, idx)
+			}
+		}
+	}
+
+	ft.injector.Clear()
+	ft.injector.SetFaults(ft.blobberURL(0), blobbertest.Fault{Operations: download, ErrorRate: 1, ErrorStatus: http.StatusInternalServerError})
+	ft.injector.SetFaults(ft.blobberURL(2), blobbertest.Fault{Operations: download, TruncateRate: 1})
+	_, err := ft.download(t, "two_failing.bin")
+	if err == nil {
+		t.Fatal("download succeeded with two of three shards failing")
+	}
+
+	// A corrupt data shard decodes to wrong content, which the file hash
+	// catches. The corrupt shard comes first and the parity last, so that
+	// the corrupt one is decoded.
+	ft.injector.Clear()
+	ft.injector.SetFaults(ft.blobberURL(0), blobbertest.Fault{Operations: download, BitFlipRate: 1})
+	ft.injector.SetFaults(ft.blobberURL(1), blobbertest.Fault{Operations: download, Latency: 20 * time.Millisecond})
+	ft.injector.SetFaults(ft.blobberURL(2), blobbertest.Fault{Operations: download, Latency: 150 * time.Millisecond})
+	_, err = ft.download(t, "corrupt.bin")
+	if err == nil {
+		t.Fatal("download of a corrupt shard succeeded")
+	}
+}
+
+func TestUploadWithFaults(t *testing.T) {
+	ft := newFaultTest(t, "upload_faults")
+	defer ft.Close()
+	localPath := filepath.Join(ft.dir, "file.bin")
+
+	ft.injector.SetFaults(ft.blobberURL(1), blobbertest.Fault{Latency: 20 * time.Millisecond})
+	_, err := ft.a.UploadFileCtx(context.Background(), localPath, "/slow.bin", nil)
+	if err != nil {
+		t.Fatalf("upload with a slow blobber: %v", err)
+	}
+
+	// A push rejected by a blobber misses the consensus, nothing is committed
+	ft.injector.Clear()
+	ft.injector.SetFaults(ft.blobberURL(1), blobbertest.Fault{Operations: []string{zboxutil.OperationUpload}, ErrorRate: 1, ErrorStatus: http.StatusBadRequest})
+	roots := make([]string, len(ft.network.Blobbers))
+	for i, b := range ft.network.Blobbers {
+		roots[i] = b.AllocationRoot()
+	}
+	_, err = ft.a.UploadFileCtx(context.Background(), localPath, "/push_rejected.bin", nil)
+	if err == nil {
+		t.Fatal("upload succeeded with a push rejected")
+	}
+	for i, b := range ft.network.Blobbers {
+		if b.GetRef("/push_rejected.bin") != nil || b.AllocationRoot() != roots[i] {
+			t.Fatalf("blobber %d committed the rejected push", i)
+		}
+		if b.PendingConnections() != 0 {
+			t.Fatalf("blobber %d kept the rejected push", i)
+		}
+	}
+	if _, err = loadUploadState(ft.a.ID, "/push_rejected.bin"); err == nil {
+		t.Fatal("failed upload left its state")
+	}
+
+	// Every blobber has to commit for the consensus of 2 data and 1 parity
+	// shards
+	ft.injector.Clear()
+	ft.injector.SetFaults(ft.blobberURL(2), blobbertest.Fault{Operations: []string{zboxutil.OperationCommit}, ErrorRate: 1, ErrorStatus: http.StatusBadRequest})
+	_, err = ft.a.UploadFileCtx(context.Background(), localPath, "/rejected.bin", nil)
+	if err == nil {
+		t.Fatal("upload succeeded with a commit rejected")
+	}
+	if ft.network.Blobbers[2].GetRef("/rejected.bin") != nil {
+		t.Fatal("blobber rejecting the commit has the file")
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"mime/multipart"
 	"net/http"
 	"sync"
-	"time"
 
 	"github.com/0chain/gosdk/zboxcore/allocationchange"
 	"github.com/0chain/gosdk/zboxcore/blockchain"
@@ -55,7 +54,7 @@ func (req *CopyRequest) copyBlobberObject(blobber *blockchain.StorageNode, blobb
 	}
 	httpreq.Header.Add("Content-Type", formWriter.FormDataContentType())
 	Logger.Info(httpreq.URL.Path)
-	ctx, cncl := context.WithTimeout(req.ctx, (time.Second * 30))
+	ctx, cncl := context.WithCancel(req.ctx)
 	err = zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
 		if err != nil {
 			Logger.Error("Copy : ", err)
@@ -0,0 +1,568 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+	"github.com/0chain/gosdk/zboxcore/marker"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+// Policies for files that already exist at the destination
+const (
+	ExistingSkip      = "skip"
+	ExistingOverwrite = "overwrite"
+)
+
+// Outcome of a file in a directory transfer
+const (
+	TransferCompleted = "completed"
+	TransferSkipped   = "skipped"
+	TransferFailed    = "failed"
+)
+
+type UploadDirOptions struct {
+	// Gitignore style patterns matched against the slash separated path
+	// relative to the local directory. When set, only the files they match
+	// are uploaded.
+	Include []string
+	// Patterns of the files left out, matched the same way as Include
+	Exclude []string
+	// Files uploaded at a time, 1 if not set
+	MaxConcurrency int
+	Encrypt        bool
+	// ExistingSkip or ExistingOverwrite for the files already on the
+	// allocation, ExistingSkip if not set
+	Existing string
+}
+
+type DownloadDirOptions struct {
+	// Gitignore style patterns matched against the slash separated path
+	// relative to the remote directory. When set, only the files they match
+	// are downloaded.
+	Include []string
+	// Patterns of the files left out, matched the same way as Include
+	Exclude []string
+	// Files downloaded at a time, 1 if not set
+	MaxConcurrency int
+	// ExistingSkip or ExistingOverwrite for the files already in the local
+	// directory, ExistingSkip if not set
+	Existing string
+}
+
+type DirTransferResult struct {
+	LocalPath  string `json:"local_path"`
+	RemotePath string `json:"remote_path"`
+	Size       int64  `json:"size"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+type DirTransferSummary struct {
+	Completed int                  `json:"completed"`
+	Skipped   int                  `json:"skipped"`
+	Failed    int                  `json:"failed"`
+	Bytes     int64                `json:"bytes"`
+	Files     []*DirTransferResult `json:"files"`
+}
+
+func (s *DirTransferSummary) add(file *DirTransferResult) {
+	s.Files = append(s.Files, file)
+	switch file.Status {
+	case TransferCompleted:
+		s.Completed++
+		s.Bytes += file.Size
+	case TransferSkipped:
+		s.Skipped++
+	case TransferFailed:
+		s.Failed++
+	}
+}
+
+// dirProgress - sums up the progress of the files of a directory transfer
+// into a single callback. The byte counts are those of the files, whatever
+// the files report while encoding or decoding.
+type dirProgress struct {
+	status       StatusCallback
+	allocationID string
+	dirPath      string
+	op           int
+	done         int64
+	running      map[string]int64
+	mutex        sync.Mutex
+}
+
+func newDirProgress(status StatusCallback, allocationID string, dirPath string, op int) *dirProgress {
+	return &dirProgress{status: status, allocationID: allocationID, dirPath: dirPath, op: op, running: make(map[string]int64)}
+}
+
+func (p *dirProgress) update(filePath string, completed int64) {
+	if p.status == nil {
+		return
+	}
+	p.mutex.Lock()
+	p.running[filePath] = completed
+	total := p.done
+	for _, c := range p.running {
+		total += c
+	}
+	p.mutex.Unlock()
+	p.status.InProgress(p.allocationID, p.dirPath, p.op, int(total))
+}
+
+func (p *dirProgress) finish(filePath string, size int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.running, filePath)
+	p.done += size
+}
+
+// fileProgress - progress of one file of the transfer
+type fileProgress struct {
+	progress *dirProgress
+	filePath string
+	size     int64
+	total    int64
+}
+
+func (f *fileProgress) Started(allocationId, filePath string, op int, totalBytes int) {
+	f.total = int64(totalBytes)
+}
+
+func (f *fileProgress) InProgress(allocationId, filePath string, op int, completedBytes int) {
+	if f.total <= 0 {
+		return
+	}
+	f.progress.update(f.filePath, f.size*int64(completedBytes)/f.total)
+}
+
+func (f *fileProgress) Error(allocationID string, filePath string, op int, err error) {}
+
+func (f *fileProgress) Completed(allocationId, filePath string, filename string, mimetype string, size int, op int) {
+}
+
+func getTransferMatchers(include []string, exclude []string) (*zboxutil.IgnoreMatcher, *zboxutil.IgnoreMatcher, error) {
+	includeMatcher, err := zboxutil.NewIgnoreMatcher(include)
+	if err != nil {
+		return nil, nil, common.NewError("invalid_option", err.Error())
+	}
+	excludeMatcher, err := zboxutil.NewIgnoreMatcher(exclude)
+	if err != nil {
+		return nil, nil, common.NewError("invalid_option", err.Error())
+	}
+	return includeMatcher, excludeMatcher, nil
+}
+
+// isTransferred - whether the patterns of the options let the file through
+func isTransferred(include *zboxutil.IgnoreMatcher, exclude *zboxutil.IgnoreMatcher, relPath string) bool {
+	if !include.Empty() && !include.Match(relPath, false) {
+		return false
+	}
+	return !exclude.Match(relPath, false)
+}
+
+// getRemoteTree - paths and types of everything under remoteDir
+func (a *Allocation) getRemoteTree(remoteDir string) (map[string]string, error) {
+	tree := make(map[string]string)
+	dirs := []string{remoteDir}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		ref, err := a.ListDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range ref.Children {
+			tree[child.Path] = child.Type
+			if child.Type == fileref.DIRECTORY {
+				dirs = append(dirs, child.Path)
+			}
+		}
+	}
+	return tree, nil
+}
+
+// runDirTransfer - transfers the pending files of the summary with at most
+// concurrency transfers at a time, and reports the aggregated progress
+func runDirTransfer(summary *DirTransferSummary, pending []*DirTransferResult, concurrency int, progress *dirProgress, transfer func(file *DirTransferResult, status StatusCallback) error) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	total := int64(0)
+	for _, file := range pending {
+		total += file.Size
+	}
+	if progress.status != nil {
+		progress.status.Started(progress.allocationID, progress.dirPath, progress.op, int(total))
+	}
+	sem := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	for _, file := range pending {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(file *DirTransferResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := transfer(file, &fileProgress{progress: progress, filePath: file.LocalPath, size: file.Size})
+			progress.finish(file.LocalPath, file.Size)
+			if err != nil {
+				Logger.Error("Transfer of ", file.LocalPath, " failed: ", err)
+				file.Status = TransferFailed
+				file.Error = err.Error()
+				return
+			}
+			file.Status = TransferCompleted
+		}(file)
+	}
+	wg.Wait()
+	for _, file := range pending {
+		summary.add(file)
+	}
+
+	if summary.Failed > 0 {
+		err := fmt.Errorf("%d of %d files failed", summary.Failed, len(summary.Files))
+		if progress.status != nil {
+			progress.status.Error(progress.allocationID, progress.dirPath, progress.op, err)
+		}
+		return err
+	}
+	if progress.status != nil {
+		_, name := path.Split(progress.dirPath)
+		progress.status.Completed(progress.allocationID, progress.dirPath, name, "", int(summary.Bytes), progress.op)
+	}
+	return nil
+}
+
+// UploadDir - uploads the files under localDir to remoteDir, keeping the
+// directory structure. Progress of the whole directory is reported through
+// status, with remoteDir as the file path. Blocks till all the files are
+// done and returns the outcome of each file, along with an error when any of
+// them failed.
+func (a *Allocation) UploadDir(localDir string, remoteDir string, opts *UploadDirOptions, status StatusCallback) (*DirTransferSummary, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	if opts == nil {
+		opts = &UploadDirOptions{}
+	}
+	optsCopy := *opts
+	opts = &optsCopy
+	if opts.Existing == "" {
+		opts.Existing = ExistingSkip
+	}
+	if opts.Existing != ExistingSkip && opts.Existing != ExistingOverwrite {
+		return nil, common.NewError("invalid_option", "Unknown policy for existing files "+opts.Existing)
+	}
+	dirInfo, err := os.Stat(localDir)
+	if err != nil {
+		return nil, fmt.Errorf("Local directory error: %s", err.Error())
+	}
+	if !dirInfo.IsDir() {
+		return nil, common.NewError("invalid_path", localDir+" is not a directory")
+	}
+	if len(remoteDir) == 0 {
+		return nil, common.NewError("invalid_path", "Invalid path for the upload")
+	}
+	remoteDir = filepath.Clean(remoteDir)
+	if !filepath.IsAbs(remoteDir) {
+		return nil, common.NewError("invalid_path", "Path should be valid and absolute")
+	}
+	include, exclude, err := getTransferMatchers(opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	remoteTree, err := a.getRemoteTree(remoteDir)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &DirTransferSummary{Files: make([]*DirTransferResult, 0)}
+	pending := make([]*DirTransferResult, 0)
+	err = filepath.Walk(localDir, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if localPath == localDir {
+				return err
+			}
+			summary.add(&DirTransferResult{LocalPath: localPath, Status: TransferFailed, Error: err.Error()})
+			return nil
+		}
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if localPath != localDir && exclude.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !isTransferred(include, exclude, rel) {
+			return nil
+		}
+		file := &DirTransferResult{LocalPath: localPath, RemotePath: path.Join(remoteDir, rel), Size: info.Size()}
+		if remoteType, ok := remoteTree[file.RemotePath]; ok {
+			if remoteType == fileref.DIRECTORY {
+				file.Status = TransferFailed
+				file.Error = "A directory exists at the remote path"
+				summary.add(file)
+				return nil
+			}
+			if opts.Existing == ExistingSkip {
+				file.Status = TransferSkipped
+				summary.add(file)
+				return nil
+			}
+		}
+		pending = append(pending, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Local directory error: %s", err.Error())
+	}
+
+	progress := newDirProgress(status, a.ID, remoteDir, OpUpload)
+	err = runDirTransfer(summary, pending, opts.MaxConcurrency, progress, func(file *DirTransferResult, status StatusCallback) error {
+		_, isUpdate := remoteTree[file.RemotePath]
+		return a.uploadDirFile(a.ctx, file, isUpdate, opts.Encrypt, status)
+	})
+	return summary, err
+}
+
+// uploadDirFile - uploads one file of UploadDir, synchronously, till ctx is
+// done
+func (a *Allocation) uploadDirFile(ctx context.Context, file *DirTransferResult, isUpdate bool, encrypt bool, progress StatusCallback) error {
+	fileInfo, err := os.Stat(file.LocalPath)
+	if err != nil {
+		return fmt.Errorf("Local file error: %s", err.Error())
+	}
+	status := &opStatus{status: progress, op: OpUpload}
+	uploadReq := a.newUploadRequest(file.RemotePath, fileInfo.Size(), status, isUpdate, encrypt)
+	uploadReq.filepath = file.LocalPath
+	uploadReq.state = newUploadState(a.ID, uploadReq, fileInfo)
+	uploadReq.ctxCncl()
+	uploadReq.ctx, uploadReq.ctxCncl = context.WithCancel(ctx)
+	uploadReq.processUpload(ctx, a)
+	if status.err != nil {
+		return status.err
+	}
+	if !status.completed {
+		return common.NewError("upload_failed", "Upload of "+file.LocalPath+" didn't complete")
+	}
+	return nil
+}
+
+// getRemoteFiles - the files under root, descending into the directories
+// listed by list
+func getRemoteFiles(root *ListResult, list func(dir *ListResult) (*ListResult, error)) ([]*ListResult, []*ListResult, error) {
+	files := make([]*ListResult, 0)
+	dirs := make([]*ListResult, 0)
+	queue := []*ListResult{root}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		for _, child := range dir.Children {
+			if child.Type != fileref.DIRECTORY {
+				files = append(files, child)
+				continue
+			}
+			ref, err := list(child)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Children of the list have the path, keep the listed one
+			ref.Path = child.Path
+			dirs = append(dirs, ref)
+			queue = append(queue, ref)
+		}
+	}
+	return files, dirs, nil
+}
+
+// DownloadDir - downloads the files under remoteDir to localDir, recreating
+// the directory structure. Progress of the whole directory is reported
+// through status, with remoteDir as the file path. Blocks till all the files
+// are done and returns the outcome of each file, along with an error when any
+// of them failed.
+func (a *Allocation) DownloadDir(remoteDir string, localDir string, opts *DownloadDirOptions, status StatusCallback) (*DirTransferSummary, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	if len(remoteDir) == 0 {
+		return nil, common.NewError("invalid_path", "Invalid path for the download")
+	}
+	remoteDir = filepath.Clean(remoteDir)
+	if !filepath.IsAbs(remoteDir) {
+		return nil, common.NewError("invalid_path", "Path should be valid and absolute")
+	}
+	root, err := a.ListDir(remoteDir)
+	if err != nil {
+		return nil, err
+	}
+	root.Path = remoteDir
+	return a.downloadDir(root, localDir, opts, status, nil, func(dir *ListResult) (*ListResult, error) {
+		return a.ListDir(dir.Path)
+	})
+}
+
+// DownloadDirFromAuthTicket - downloads the shared directory to localDir. See
+// DownloadDir.
+func (a *Allocation) DownloadDirFromAuthTicket(authTicket string, remoteLookupHash string, localDir string, opts *DownloadDirOptions, status StatusCallback) (*DirTransferSummary, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	at, err := decodeAuthTicket(authTicket)
+	if err != nil {
+		return nil, err
+	}
+	root, err := a.ListDirFromAuthTicket(authTicket, remoteLookupHash)
+	if err != nil {
+		return nil, err
+	}
+	if root.Type != fileref.DIRECTORY {
+		return nil, common.NewError("invalid_path", "The auth ticket is not for a directory")
+	}
+	return a.downloadDir(root, localDir, opts, status, at, func(dir *ListResult) (*ListResult, error) {
+		return a.ListDirFromAuthTicket(authTicket, dir.LookupHash)
+	})
+}
+
+func (a *Allocation) downloadDir(root *ListResult, localDir string, opts *DownloadDirOptions, status StatusCallback, at *marker.AuthTicket, list func(dir *ListResult) (*ListResult, error)) (*DirTransferSummary, error) {
+	if opts == nil {
+		opts = &DownloadDirOptions{}
+	}
+	optsCopy := *opts
+	opts = &optsCopy
+	if opts.Existing == "" {
+		opts.Existing = ExistingSkip
+	}
+	if opts.Existing != ExistingSkip && opts.Existing != ExistingOverwrite {
+		return nil, common.NewError("invalid_option", "Unknown policy for existing files "+opts.Existing)
+	}
+	if len(a.Blobbers) <= 1 {
+		return nil, noBLOBBERS
+	}
+	if stat, err := os.Stat(localDir); err == nil && !stat.IsDir() {
+		return nil, fmt.Errorf("Local path is not a directory '%s'", localDir)
+	}
+	include, exclude, err := getTransferMatchers(opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	files, dirs, err := getRemoteFiles(root, list)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(localDir, os.ModePerm)
+	if err == nil {
+		for _, dir := range dirs {
+			rel, dirPath, ok := getLocalPath(root.Path, dir.Path, localDir)
+			if !ok {
+				Logger.Error("Directory ", dir.Path, " is not under ", root.Path, ", skipped")
+				continue
+			}
+			if exclude.Match(rel, true) {
+				continue
+			}
+			err = os.MkdirAll(dirPath, os.ModePerm)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Can't create local directory: %s", err.Error())
+	}
+
+	summary := &DirTransferSummary{Files: make([]*DirTransferResult, 0)}
+	pending := make([]*DirTransferResult, 0)
+	lookupHashes := make(map[string]string)
+	for _, child := range files {
+		rel, localPath, ok := getLocalPath(root.Path, child.Path, localDir)
+		if !ok {
+			summary.add(&DirTransferResult{RemotePath: child.Path, Size: child.ActualSize, Status: TransferFailed, Error: "The file is not under " + root.Path})
+			continue
+		}
+		if !isTransferred(include, exclude, rel) {
+			continue
+		}
+		file := &DirTransferResult{LocalPath: localPath, RemotePath: child.Path, Size: child.ActualSize}
+		if stat, err := os.Stat(file.LocalPath); err == nil {
+			if stat.IsDir() {
+				file.Status = TransferFailed
+				file.Error = "A directory exists at the local path"
+				summary.add(file)
+				continue
+			}
+			if opts.Existing == ExistingSkip {
+				file.Status = TransferSkipped
+				summary.add(file)
+				continue
+			}
+		}
+		lookupHashes[file.RemotePath] = child.LookupHash
+		pending = append(pending, file)
+	}
+
+	progress := newDirProgress(status, a.ID, root.Path, OpDownload)
+	err = runDirTransfer(summary, pending, opts.MaxConcurrency, progress, func(file *DirTransferResult, status StatusCallback) error {
+		return a.downloadDirFile(a.ctx, file, at, lookupHashes[file.RemotePath], status)
+	})
+	return summary, err
+}
+
+// getLocalPath - the path relative to remoteRoot and the local path under
+// localDir of the remote path listed by the blobbers. Not ok if the remote
+// path is not under remoteRoot or the local path would leave localDir.
+func getLocalPath(remoteRoot string, remotePath string, localDir string) (string, string, bool) {
+	prefix := strings.TrimSuffix(path.Clean(remoteRoot), "/") + "/"
+	remotePath = path.Clean(remotePath)
+	if !strings.HasPrefix(remotePath, prefix) || len(remotePath) == len(prefix) {
+		return "", "", false
+	}
+	rel := remotePath[len(prefix):]
+	localPath := filepath.Join(localDir, filepath.FromSlash(rel))
+	localRel, err := filepath.Rel(localDir, localPath)
+	if err != nil || localRel == "." || localRel == ".." || strings.HasPrefix(localRel, ".."+string(filepath.Separator)) {
+		return "", "", false
+	}
+	return rel, localPath, true
+}
+
+// downloadDirFile - downloads one file of DownloadDir, synchronously, till
+// ctx is done. The file is written next to its local path and moved in place
+// once complete, so a failed download leaves an existing file untouched.
+func (a *Allocation) downloadDirFile(ctx context.Context, file *DirTransferResult, at *marker.AuthTicket, lookupHash string, progress StatusCallback) error {
+	tmpPath := file.LocalPath + ".part"
+	os.Remove(tmpPath)
+	status := &opStatus{status: progress, op: OpDownload}
+	downloadReq := a.newDownloadRequest(tmpPath, DOWNLOAD_CONTENT_FULL, status)
+	if at != nil {
+		downloadReq.remotefilepathhash = lookupHash
+		downloadReq.authTicket = at
+	} else {
+		downloadReq.remotefilepath = file.RemotePath
+	}
+	downloadReq.ctxCncl()
+	downloadReq.ctx, downloadReq.ctxCncl = context.WithCancel(ctx)
+	downloadReq.processDownload(ctx, a)
+	if status.err != nil {
+		os.Remove(tmpPath)
+		return status.err
+	}
+	if !status.completed {
+		os.Remove(tmpPath)
+		return common.NewError("download_failed", "Download of "+file.RemotePath+" didn't complete")
+	}
+	return os.Rename(tmpPath, file.LocalPath)
+}
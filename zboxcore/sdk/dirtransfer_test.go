@@ -0,0 +1,244 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+func TestGetLocalPath(t *testing.T) {
+	localDir := filepath.Join("tmp", "download")
+	tests := []struct {
+		root       string
+		remotePath string
+		rel        string
+		ok         bool
+	}{
+		{"/dir", "/dir/a.txt", "a.txt", true},
+		{"/dir", "/dir/sub/a.txt", "sub/a.txt", true},
+		{"/dir/", "/dir/a.txt", "a.txt", true},
+		{"/", "/a.txt", "a.txt", true},
+		{"/dir", "/dir", "", false},
+		{"/dir", "/dirx/a.txt", "", false},
+		{"/dir", "/other/a.txt", "", false},
+		{"/dir", "/dir/../other/a.txt", "", false},
+		{"/dir", "/dir/sub/../../../etc/passwd", "", false},
+		{"/dir", "dir/a.txt", "", false},
+	}
+	for _, tt := range tests {
+		rel, localPath, ok := getLocalPath(tt.root, tt.remotePath, localDir)
+		if ok != tt.ok || rel != tt.rel {
+			t.Errorf("%s under %s: %q %v, expected %q %v", tt.remotePath, tt.root, rel, ok, tt.rel, tt.ok)
+			continue
+		}
+		if ok && localPath != filepath.Join(localDir, filepath.FromSlash(tt.rel)) {
+			t.Errorf("%s under %s: local path %s", tt.remotePath, tt.root, localPath)
+		}
+	}
+}
+
+// uploadMeter - counts the upload requests to a host running at a time,
+// holding each for a while so that the concurrent ones overlap
+type uploadMeter struct {
+	next    http.RoundTripper
+	host    string
+	mutex   sync.Mutex
+	running int
+	max     int
+}
+
+func (m *uploadMeter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if zboxutil.GetOperation(req) != zboxutil.OperationUpload || req.URL.Host != m.host {
+		return m.next.RoundTrip(req)
+	}
+	m.mutex.Lock()
+	m.running++
+	if m.running > m.max {
+		m.max = m.running
+	}
+	m.mutex.Unlock()
+	defer func() {
+		m.mutex.Lock()
+		m.running--
+		m.mutex.Unlock()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	return m.next.RoundTrip(req)
+}
+
+// dirStatus - records the callbacks of a directory transfer
+type dirStatus struct {
+	mutex     sync.Mutex
+	filePaths map[string]bool
+	total     int
+	progress  []int
+	completed int
+	err       error
+}
+
+func (s *dirStatus) record(filePath string) {
+	if s.filePaths == nil {
+		s.filePaths = make(map[string]bool)
+	}
+	s.filePaths[filePath] = true
+}
+
+func (s *dirStatus) Started(allocationId, filePath string, op int, totalBytes int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record(filePath)
+	s.total = totalBytes
+}
+
+func (s *dirStatus) InProgress(allocationId, filePath string, op int, completedBytes int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record(filePath)
+	s.progress = append(s.progress, completedBytes)
+}
+
+func (s *dirStatus) Error(allocationID string, filePath string, op int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record(filePath)
+	s.err = err
+}
+
+func (s *dirStatus) Completed(allocationId, filePath string, filename string, mimetype string, size int, op int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record(filePath)
+	s.completed = size
+}
+
+func TestUploadDir(t *testing.T) {
+	ft := newFaultTest(t, "upload_dir")
+	defer ft.Close()
+	localDir := filepath.Join(ft.dir, "local")
+	files := map[string][]byte{
+		"a.txt":       randomContent(100),
+		"sub/b.bin":   randomContent(3*fileref.CHUNK_SIZE + 7),
+		"sub/c.log":   randomContent(10),
+		"cache/d.txt": randomContent(10),
+		"e.txt":       randomContent(fileref.CHUNK_SIZE),
+		"f.txt":       randomContent(1),
+		"conflict":    randomContent(10),
+	}
+	for rel, content := range files {
+		localPath := filepath.Join(localDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(localPath, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := randomContent(50)
+	oldPath := filepath.Join(ft.dir, "old.txt")
+	if err := ioutil.WriteFile(oldPath, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ft.a.UploadFileCtx(context.Background(), oldPath, "/up/a.txt", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ft.a.CreateDir("/up/conflict"); err != nil {
+		t.Fatal(err)
+	}
+	checkRemote := func(remotePath string, content []byte) {
+		data, err := ft.downloadFrom(t, remotePath, "check")
+		if err != nil {
+			t.Errorf("download of %s: %v", remotePath, err)
+			return
+		}
+		os.Remove(filepath.Join(ft.dir, "check"))
+		if !bytes.Equal(data, content) {
+			t.Errorf("content of %s: %d bytes, expected %d", remotePath, len(data), len(content))
+		}
+	}
+
+	// The existing file is skipped, the one over a directory fails and the
+	// others go up at most 2 at a time
+	meter := &uploadMeter{next: ft.injector, host: hostOf(ft.blobberURL(0))}
+	zboxutil.SetHTTPClient(&http.Client{Transport: meter})
+	status := &dirStatus{}
+	opts := &UploadDirOptions{Exclude: []string{"cache/", "*.log"}, MaxConcurrency: 2}
+	summary, err := ft.a.UploadDir(localDir, "/up", opts, status)
+	if err == nil {
+		t.Error("upload of a file over a directory didn't fail")
+	}
+	zboxutil.SetHTTPClient(ft.injector.Client())
+	if summary == nil {
+		t.Fatal("no summary")
+	}
+	bytesUp := int64(len(files["sub/b.bin"]) + len(files["e.txt"]) + len(files["f.txt"]))
+	if summary.Completed != 3 || summary.Skipped != 1 || summary.Failed != 1 || summary.Bytes != bytesUp || len(summary.Files) != 5 {
+		t.Errorf("summary %+v", summary)
+	}
+	expected := map[string]string{
+		"/up/a.txt":     TransferSkipped,
+		"/up/sub/b.bin": TransferCompleted,
+		"/up/e.txt":     TransferCompleted,
+		"/up/f.txt":     TransferCompleted,
+		"/up/conflict":  TransferFailed,
+	}
+	for _, file := range summary.Files {
+		if expected[file.RemotePath] != file.Status {
+			t.Errorf("%s: %s, expected %s", file.RemotePath, file.Status, expected[file.RemotePath])
+		}
+		if file.Status == TransferFailed && len(file.Error) == 0 {
+			t.Errorf("%s: failed without an error", file.RemotePath)
+		}
+	}
+	if meter.max != 2 {
+		t.Errorf("%d uploads at a time, expected 2", meter.max)
+	}
+	if len(status.filePaths) != 1 || !status.filePaths["/up"] {
+		t.Errorf("progress of %v", status.filePaths)
+	}
+	if status.total != int(bytesUp) || status.err == nil || status.completed != 0 {
+		t.Errorf("status %+v", status)
+	}
+	for _, completed := range status.progress {
+		if completed < 0 || completed > status.total {
+			t.Errorf("progress %d of %d", completed, status.total)
+		}
+	}
+	checkRemote("/up/a.txt", old)
+	checkRemote("/up/sub/b.bin", files["sub/b.bin"])
+	checkRemote("/up/e.txt", files["e.txt"])
+	checkRemote("/up/f.txt", files["f.txt"])
+	for _, remotePath := range []string{"/up/sub/c.log", "/up/cache/d.txt"} {
+		if ft.network.Blobbers[0].GetRef(remotePath) != nil {
+			t.Errorf("%s uploaded", remotePath)
+		}
+	}
+
+	// Overwritten when asked to
+	status = &dirStatus{}
+	opts = &UploadDirOptions{Include: []string{"a.txt"}, Existing: ExistingOverwrite}
+	summary, err = ft.a.UploadDir(localDir, "/up", opts, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Completed != 1 || summary.Skipped != 0 || summary.Failed != 0 || len(summary.Files) != 1 || summary.Files[0].RemotePath != "/up/a.txt" {
+		t.Errorf("summary of the overwrite %+v", summary)
+	}
+	if status.err != nil || status.completed != len(files["a.txt"]) {
+		t.Errorf("status of the overwrite %+v", status)
+	}
+	checkRemote("/up/a.txt", files["a.txt"])
+
+	_, err = ft.a.UploadDir(localDir, "/up", &UploadDirOptions{Existing: "merge"}, nil)
+	if err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -0,0 +1,128 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"mime/multipart"
+	"net/http"
+	"sync"
+
+	"github.com/0chain/gosdk/zboxcore/allocationchange"
+	"github.com/0chain/gosdk/zboxcore/blockchain"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+type DirRequest struct {
+	allocationID   string
+	blobbers       []*blockchain.StorageNode
+	remotefilepath string
+	ctx            context.Context
+	wg             *sync.WaitGroup
+	dirMask        uint32
+	connectionID   string
+	mutex          sync.Mutex
+	Consensus
+}
+
+func (req *DirRequest) createBlobberDir(blobber *blockchain.StorageNode, blobberIdx int) error {
+	body := new(bytes.Buffer)
+	formWriter := multipart.NewWriter(body)
+
+	_ = formWriter.WriteField("connection_id", req.connectionID)
+	formWriter.WriteField("dir_path", req.remotefilepath)
+
+	formWriter.Close()
+	httpreq, err := zboxutil.NewCreateDirRequest(blobber.Baseurl, req.allocationID, body)
+	if err != nil {
+		Logger.Error(blobber.Baseurl, "Error creating dir request", err)
+		return err
+	}
+	httpreq.Header.Add("Content-Type", formWriter.FormDataContentType())
+	ctx, cncl := context.WithCancel(req.ctx)
+	return zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
+		if err != nil {
+			Logger.Error("Create dir : ", err)
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			req.mutex.Lock()
+			req.consensus++
+			req.dirMask |= (1 << uint32(blobberIdx))
+			req.mutex.Unlock()
+			Logger.Info(blobber.Baseurl, " "+req.remotefilepath, " created.")
+		} else {
+			resp_body, err := ioutil.ReadAll(resp.Body)
+			if err == nil {
+				Logger.Error(blobber.Baseurl, "Response: ", string(resp_body))
+			}
+		}
+		return nil
+	})
+}
+
+func (req *DirRequest) ProcessDir() error {
+	numList := len(req.blobbers)
+	req.wg = &sync.WaitGroup{}
+	req.wg.Add(numList)
+	for i := 0; i < numList; i++ {
+		go func(blobberIdx int) {
+			defer req.wg.Done()
+			err := req.createBlobberDir(req.blobbers[blobberIdx], blobberIdx)
+			if err != nil {
+				Logger.Error(err.Error())
+			}
+		}(i)
+	}
+	req.wg.Wait()
+
+	if !req.isConsensusOk() {
+		return fmt.Errorf("Create dir failed: Create dir request failed. Operation failed.")
+	}
+
+	req.consensus = 0
+	wg := &sync.WaitGroup{}
+	wg.Add(bits.OnesCount32(req.dirMask))
+	commitReqs := make([]*CommitRequest, bits.OnesCount32(req.dirMask))
+	c, pos := 0, 0
+	for i := req.dirMask; i != 0; i &= ^(1 << uint32(pos)) {
+		pos = bits.TrailingZeros32(i)
+		commitReq := &CommitRequest{}
+		commitReq.allocationID = req.allocationID
+		commitReq.blobber = req.blobbers[pos]
+		newChange := &allocationchange.DirCreateChange{}
+		newChange.RemotePath = req.remotefilepath
+		newChange.NumBlocks = 0
+		newChange.Operation = allocationchange.CREATEDIR_OPERATION
+		newChange.Size = 0
+		commitReq.changes = append(commitReq.changes, newChange)
+		commitReq.connectionID = req.connectionID
+		commitReq.wg = wg
+		commitReqs[c] = commitReq
+		go AddCommitRequest(commitReq)
+		c++
+	}
+	wg.Wait()
+
+	for _, commitReq := range commitReqs {
+		if commitReq.result != nil {
+			if commitReq.result.Success {
+				Logger.Info("Commit success", commitReq.blobber.Baseurl)
+				req.consensus++
+			} else {
+				Logger.Info("Commit failed", commitReq.blobber.Baseurl, commitReq.result.ErrorMessage)
+			}
+		} else {
+			Logger.Info("Commit result not set", commitReq.blobber.Baseurl)
+		}
+	}
+
+	if !req.isConsensusOk() {
+		return fmt.Errorf("Create dir failed: Commit consensus failed")
+	}
+	return nil
+}
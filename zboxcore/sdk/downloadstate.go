@@ -0,0 +1,44 @@
+package sdk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// downloadState - sidecar of a partially downloaded file, so that a resumed
+// download can continue with the next block
+type downloadState struct {
+	LookupHash     string `json:"lookup_hash"`
+	ActualFileHash string `json:"actual_file_hash"`
+	ContentMode    string `json:"content_mode"`
+	Block          int64  `json:"block"`
+}
+
+func downloadStatePath(localpath string) string {
+	return localpath + ".zdownload"
+}
+
+func loadDownloadState(localpath string) *downloadState {
+	b, err := ioutil.ReadFile(downloadStatePath(localpath))
+	if err != nil {
+		return nil
+	}
+	state := &downloadState{}
+	if json.Unmarshal(b, state) != nil {
+		return nil
+	}
+	return state
+}
+
+func (s *downloadState) save(localpath string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(downloadStatePath(localpath), b, 0644)
+}
+
+func removeDownloadState(localpath string) {
+	os.Remove(downloadStatePath(localpath))
+}
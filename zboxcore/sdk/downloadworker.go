@@ -6,6 +6,7 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"math"
 	"math/bits"
@@ -39,17 +40,20 @@ type DownloadRequest struct {
 	numBlocks          int64
 	statusCallback     StatusCallback
 	ctx                context.Context
+	ctxCncl            context.CancelFunc
 	authTicket         *marker.AuthTicket
 	wg                 *sync.WaitGroup
 	downloadMask       uint32
 	encryptedKey       string
-	isDownloadCanceled bool
 	completedCallback  func(remotepath string, remotepathhash string)
 	contentMode        string
+	offset             int64
+	length             int64
+	isResume           bool
 	Consensus
 }
 
-func (req *DownloadRequest) downloadBlock(blockNum int64) ([]byte, error) {
+func (req *DownloadRequest) downloadBlock(blockNum int64, numBlocks int64) ([]byte, error) {
 	req.consensus = 0
 	numDownloads := bits.OnesCount32(req.downloadMask)
 	req.wg = &sync.WaitGroup{}
@@ -71,18 +75,18 @@ func (req *DownloadRequest) downloadBlock(blockNum int64) ([]byte, error) {
 		blockDownloadReq.ctx = req.ctx
 		blockDownloadReq.remotefilepath = req.remotefilepath
 		blockDownloadReq.remotefilepathhash = req.remotefilepathhash
-		blockDownloadReq.numBlocks = req.numBlocks
+		blockDownloadReq.numBlocks = numBlocks
 		go AddBlockDownloadReq(blockDownloadReq)
 		//go obj.downloadBlobberBlock(&obj.blobbers[pos], pos, path, blockNum, rspCh, isPathHash, authTicket)
 		c++
 	}
 	//req.wg.Wait()
-	shards := make([][][]byte, req.numBlocks)
-	for i := int64(0); i < req.numBlocks; i++ {
+	shards := make([][][]byte, numBlocks)
+	for i := int64(0); i < numBlocks; i++ {
 		shards[i] = make([][]byte, len(req.blobbers))
 	}
 	//shards := make([][]byte, len(req.blobbers))
-	decodeLen := make([]int, req.numBlocks)
+	decodeLen := make([]int, numBlocks)
 	var decodeNumBlocks int
 	var encscheme encryption.EncryptionScheme
 	if len(req.encryptedKey) > 0 {
@@ -143,6 +147,9 @@ func (req *DownloadRequest) downloadBlock(blockNum int64) ([]byte, error) {
 			}
 		}
 	}
+	if success < req.datashards {
+		return []byte{}, fmt.Errorf("Not enough blobbers responded. Required %d, got %d", req.datashards, success)
+	}
 	erasureencoder, err := encoder.NewEncoder(req.datashards, req.parityshards)
 	if err != nil {
 		return []byte{}, fmt.Errorf("encoder init error %s", err.Error())
@@ -157,6 +164,62 @@ func (req *DownloadRequest) downloadBlock(blockNum int64) ([]byte, error) {
 	return retData, nil
 }
 
+// removePartial - removes the partially downloaded file, unless it's kept for
+// resuming the download
+func (req *DownloadRequest) removePartial() {
+	if req.isResume {
+		return
+	}
+	os.Remove(req.localpath)
+}
+
+// prepareResume - keeps the blocks written by an earlier attempt to download
+// the same content and adds them to the file hash. The blocks of a remote
+// file that changed since are dropped and the download starts over. A local
+// file without a download state wasn't written by this download, so it is
+// left untouched and the resume fails.
+func (req *DownloadRequest) prepareResume(wrFile *os.File, fH hash.Hash, expectedHash string, blockSize int64, size int64) (*downloadState, error) {
+	state := &downloadState{LookupHash: req.remotefilepathhash, ActualFileHash: expectedHash, ContentMode: req.contentMode}
+	stat, err := wrFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		// Nothing to keep
+		return state, nil
+	}
+	prev := loadDownloadState(req.localpath)
+	if prev == nil {
+		return nil, fmt.Errorf("Local file %s isn't a partial download of the remote file", req.localpath)
+	}
+	if prev.ActualFileHash != expectedHash || prev.ContentMode != req.contentMode {
+		Logger.Info("Remote file changed. Restarting the download of ", req.localpath)
+		err = wrFile.Truncate(0)
+		if err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+	keep := int64(math.Min(float64(prev.Block*blockSize), float64(size)))
+	if stat.Size() < keep {
+		return nil, fmt.Errorf("Local file %s is shorter than its download state", req.localpath)
+	}
+	// Only the blocks after the saved ones can be cut, the sidecar is saved
+	// after they are written
+	err = wrFile.Truncate(keep)
+	if err == nil {
+		_, err = io.Copy(fH, io.NewSectionReader(wrFile, 0, keep))
+	}
+	if err == nil {
+		_, err = wrFile.Seek(keep, io.SeekStart)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state.Block = prev.Block
+	return state, nil
+}
+
 func (req *DownloadRequest) processDownload(ctx context.Context, a *Allocation) {
 	remotePathCallback := req.remotefilepath
 	if len(req.remotefilepath) == 0 {
@@ -165,13 +228,22 @@ func (req *DownloadRequest) processDownload(ctx context.Context, a *Allocation)
 	if req.completedCallback != nil {
 		defer req.completedCallback(req.remotefilepath, req.remotefilepathhash)
 	}
+	if req.ctxCncl != nil {
+		defer req.ctxCncl()
+	}
 
 	// Only download from the Blobbers passes the consensus
 	var fileRef *fileref.FileRef
 	listReq := &ListRequest{remotefilepath: req.remotefilepath, remotefilepathhash: req.remotefilepathhash, allocationID: req.allocationID, blobbers: req.blobbers, ctx: req.ctx}
 	listReq.authToken = req.authTicket
+	listReq.consensusThresh = req.consensusThresh
+	listReq.fullconsensus = req.fullconsensus
 	req.downloadMask, fileRef, _ = listReq.getFileConsensusFromBlobbers()
 	if req.downloadMask == 0 || fileRef == nil {
+		if req.ctx.Err() != nil {
+			reportCancel(req.statusCallback, req.allocationID, remotePathCallback, OpDownload)
+			return
+		}
 		if req.statusCallback != nil {
 			req.statusCallback.Error(req.allocationID, remotePathCallback, OpDownload, fmt.Errorf("No minimum consensus for file meta data of file"))
 		}
@@ -195,7 +267,24 @@ func (req *DownloadRequest) processDownload(ctx context.Context, a *Allocation)
 		perShard += chunksPerShard * (16 + (2 * 1024))
 	}
 
-	wrFile, err := os.OpenFile(req.localpath, os.O_CREATE|os.O_WRONLY, 0644)
+	// Map the requested byte range to the blocks holding it. Each block has
+	// one chunk from every data shard.
+	blockSize := chunkSizeWithHeader * int64(req.datashards)
+	rangeStart, rangeEnd := int64(0), size
+	if req.length > 0 {
+		if req.offset >= size {
+			if req.statusCallback != nil {
+				req.statusCallback.Error(req.allocationID, remotePathCallback, OpDownload, fmt.Errorf("Range offset %d is beyond the file size %d", req.offset, size))
+			}
+			return
+		}
+		rangeStart = req.offset
+		rangeEnd = int64(math.Min(float64(req.offset+req.length), float64(size)))
+	}
+	startBlock := rangeStart / blockSize
+	endBlock := (rangeEnd + blockSize - 1) / blockSize
+
+	wrFile, err := os.OpenFile(req.localpath, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		if req.statusCallback != nil {
 			Logger.Error(err.Error())
@@ -204,57 +293,88 @@ func (req *DownloadRequest) processDownload(ctx context.Context, a *Allocation)
 		return
 	}
 	defer wrFile.Close()
-	req.isDownloadCanceled = false
 	if req.statusCallback != nil {
-		req.statusCallback.Started(req.allocationID, remotePathCallback, OpDownload, int(size))
+		req.statusCallback.Started(req.allocationID, remotePathCallback, OpDownload, int(rangeEnd-rangeStart))
 	}
 
 	Logger.Info("Download Size:", size, " Shard:", perShard, " chunks/shard:", chunksPerShard)
 	downloaded := int(0)
 	fH := sha1.New()
-	mW := io.MultiWriter(fH, wrFile)
-	//batchCount := (chunksPerShard + req.numBlocks - 1) / req.numBlocks
-	for cnt := int64(0); cnt < chunksPerShard; cnt += req.numBlocks {
-		//blockSize := int64(math.Min(float64(perShard-(cnt*fileref.CHUNK_SIZE)), fileref.CHUNK_SIZE))
-		data, err := req.downloadBlock(cnt + 1)
+	expectedHash := fileRef.ActualFileHash
+	if req.contentMode == DOWNLOAD_CONTENT_THUMB {
+		expectedHash = fileRef.ActualThumbnailHash
+	}
+	var resumeState *downloadState
+	if req.isResume {
+		resumeState, err = req.prepareResume(wrFile, fH, expectedHash, blockSize, size)
 		if err != nil {
-			os.Remove(req.localpath)
 			if req.statusCallback != nil {
-				req.statusCallback.Error(req.allocationID, remotePathCallback, OpDownload, fmt.Errorf("Download failed for block %d. Error : %s", cnt+1, err.Error()))
+				req.statusCallback.Error(req.allocationID, remotePathCallback, OpDownload, fmt.Errorf("Can't resume the download: %s", err.Error()))
 			}
 			return
 		}
-		if req.isDownloadCanceled {
-			req.isDownloadCanceled = false
-			os.Remove(req.localpath)
+		startBlock = resumeState.Block
+		downloaded = int(math.Min(float64(startBlock*blockSize), float64(size)))
+		Logger.Info("Resuming download of ", remotePathCallback, " from block ", startBlock+1)
+	}
+	mW := io.MultiWriter(fH, wrFile)
+	//batchCount := (chunksPerShard + req.numBlocks - 1) / req.numBlocks
+	for cnt := startBlock; cnt < endBlock; cnt += req.numBlocks {
+		//blockSize := int64(math.Min(float64(perShard-(cnt*fileref.CHUNK_SIZE)), fileref.CHUNK_SIZE))
+		// Don't fetch (and pay for) blocks past the end of the range
+		numBlocks := int64(math.Min(float64(req.numBlocks), float64(endBlock-cnt)))
+		data, err := req.downloadBlock(cnt+1, numBlocks)
+		if req.ctx.Err() != nil {
+			// The requests to the blobbers are aborted with the context
+			req.removePartial()
+			reportCancel(req.statusCallback, req.allocationID, remotePathCallback, OpDownload)
+			return
+		}
+		if err != nil {
+			req.removePartial()
 			if req.statusCallback != nil {
-				req.statusCallback.Error(req.allocationID, remotePathCallback, OpDownload, fmt.Errorf("Download aborted by user"))
+				req.statusCallback.Error(req.allocationID, remotePathCallback, OpDownload, fmt.Errorf("Download failed for block %d. Error : %s", cnt+1, err.Error()))
 			}
 			return
 		}
 		//fmt.Println("Length of decoded data:", len(data))
-		n := int64(math.Min(float64(size), float64(len(data))))
-		_, err = mW.Write(data[:n])
+		// Trim the part of the batch outside the range and the erasure padding
+		dataStart := cnt * blockSize
+		skip := int64(math.Max(0, float64(rangeStart-dataStart)))
+		n := int64(math.Min(float64(rangeEnd-dataStart), float64(len(data))))
+		if n < skip {
+			n = skip
+		}
+		_, err = mW.Write(data[skip:n])
 		if err != nil {
-			os.Remove(req.localpath)
+			req.removePartial()
 			if req.statusCallback != nil {
 				req.statusCallback.Error(req.allocationID, remotePathCallback, OpDownload, fmt.Errorf("Write file failed : %s", err.Error()))
 			}
 			return
 		}
-		downloaded = downloaded + int(n)
-		size = size - n
+		downloaded = downloaded + int(n-skip)
+		if resumeState != nil {
+			// The blocks must be on disk before the sidecar says so
+			wrFile.Sync()
+			resumeState.Block = cnt + numBlocks
+			err = resumeState.save(req.localpath)
+			if err != nil {
+				Logger.Error("Saving download state failed: ", err)
+			}
+		}
 		if req.statusCallback != nil {
 			req.statusCallback.InProgress(req.allocationID, remotePathCallback, OpDownload, downloaded)
 		}
 
 	}
 	calcHash := hex.EncodeToString(fH.Sum(nil))
-	expectedHash := fileRef.ActualFileHash
-	if req.contentMode == DOWNLOAD_CONTENT_THUMB {
-		expectedHash = fileRef.ActualThumbnailHash
+	// The file hash can only be verified when the whole content is downloaded
+	isPartial := rangeStart > 0 || rangeEnd < size
+	if req.isResume {
+		removeDownloadState(req.localpath)
 	}
-	if calcHash != expectedHash {
+	if !isPartial && calcHash != expectedHash {
 		os.Remove(req.localpath)
 		if req.statusCallback != nil {
 			req.statusCallback.Error(req.allocationID, remotePathCallback, OpDownload, fmt.Errorf("File content didn't match with uploaded file"))
@@ -268,7 +388,7 @@ func (req *DownloadRequest) processDownload(ctx context.Context, a *Allocation)
 	wrFile.Seek(0, 0)
 	mimetype, _ := zboxutil.GetFileContentType(wrFile)
 	if req.statusCallback != nil {
-		req.statusCallback.Completed(req.allocationID, remotePathCallback, fileRef.Name, mimetype, int(fileRef.ActualFileSize), OpDownload)
+		req.statusCallback.Completed(req.allocationID, remotePathCallback, fileRef.Name, mimetype, downloaded, OpDownload)
 	}
 	return
 }
@@ -0,0 +1,320 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+// blockRecorder - records the blocks the blobbers served to the download
+// requests
+type blockRecorder struct {
+	next     http.RoundTripper
+	mutex    sync.Mutex
+	running  int
+	last     time.Time
+	requests map[[2]int64]int
+}
+
+// newBlockRecorder - the recorder of the requests sent through next. The
+// requests are given the time to complete, so that timeouts don't retry them.
+func newBlockRecorder(next http.RoundTripper) *blockRecorder {
+	zboxutil.SetRetryPolicy(&zboxutil.RetryPolicy{DefaultTimeout: 10 * time.Second})
+	return &blockRecorder{next: next, requests: make(map[[2]int64]int)}
+}
+
+func (r *blockRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if zboxutil.GetOperation(req) != zboxutil.OperationDownload {
+		return r.next.RoundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form := req.Clone(req.Context())
+	form.Body = ioutil.NopCloser(bytes.NewReader(body))
+	err = form.ParseMultipartForm(int64(len(body)) + 1024)
+	if err != nil {
+		return nil, err
+	}
+	blockNum, _ := strconv.ParseInt(form.FormValue("block_num"), 10, 64)
+	numBlocks, _ := strconv.ParseInt(form.FormValue("num_blocks"), 10, 64)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.mutex.Lock()
+	r.running++
+	r.mutex.Unlock()
+	resp, err := r.next.RoundTrip(req)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.running--
+	r.last = time.Now()
+	// Not the refusals of a read marker behind the counter of the blobber
+	if err == nil && resp.StatusCode == http.StatusOK && resp.Header.Get("Content-Type") == "application/octet-stream" {
+		r.requests[[2]int64{blockNum, numBlocks}]++
+	}
+	return resp, err
+}
+
+// take - the block numbers and counts served since the last take, once the
+// requests the downloads didn't wait for are done
+func (r *blockRecorder) take() map[[2]int64]int {
+	for wait := 0; wait < 100; wait++ {
+		r.mutex.Lock()
+		done := r.running == 0 && time.Since(r.last) > 50*time.Millisecond
+		r.mutex.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	requests := r.requests
+	r.requests = make(map[[2]int64]int)
+	return requests
+}
+
+// checkBlocks - fails unless the blocks served since the last take are the
+// expected block numbers and counts, each by at least the data shards
+func (r *blockRecorder) checkBlocks(t *testing.T, name string, dataShards int, expected ...[2]int64) {
+	requests := r.take()
+	ok := len(requests) == len(expected)
+	for _, blocks := range expected {
+		ok = ok && requests[blocks] >= dataShards
+	}
+	if !ok {
+		t.Errorf("%s: blocks served %v, expected %v", name, requests, expected)
+	}
+}
+
+func TestDownloadFileRange(t *testing.T) {
+	ft := newFaultTest(t, "download_range")
+	defer ft.Close()
+	// 3 blocks, the last one partly erasure padding
+	content := randomContent(5*fileref.CHUNK_SIZE + 100)
+	localPath := filepath.Join(ft.dir, "range.bin")
+	err := ioutil.WriteFile(localPath, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ft.a.UploadFileCtx(context.Background(), localPath, "/plain.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ft.a.EncryptAndUploadFileCtx(context.Background(), localPath, "/encrypted.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := newBlockRecorder(ft.injector)
+	zboxutil.SetHTTPClient(&http.Client{Transport: recorder})
+	size := int64(len(content))
+	// A block holds a chunk of each of the 2 data shards, less the header
+	// of each chunk when encrypted
+	chunk := int64(fileref.CHUNK_SIZE)
+	encryptedChunk := chunk - 16 - 2*1024
+	tests := []struct {
+		name       string
+		remotePath string
+		offset     int64
+		length     int64
+		blocks     int64
+		chunk      int64
+	}{
+		{"first byte", "/plain.bin", 0, 1, 1, chunk},
+		{"across the shards", "/plain.bin", chunk - 10, 20, 1, chunk},
+		{"last byte of a block", "/plain.bin", 2*chunk - 1, 1, 1, chunk},
+		{"across the blocks", "/plain.bin", 2*chunk - 10, 20, 2, chunk},
+		{"whole block", "/plain.bin", 2 * chunk, 2 * chunk, 1, chunk},
+		{"up to the padding", "/plain.bin", 4*chunk + 50, 1000, 1, chunk},
+		{"beyond the end", "/plain.bin", 0, 10 * chunk, 3, chunk},
+		{"encrypted across the shards", "/encrypted.bin", encryptedChunk - 10, 20, 1, encryptedChunk},
+		{"encrypted across the blocks", "/encrypted.bin", 2*encryptedChunk - 10, 20, 2, encryptedChunk},
+		{"encrypted last block", "/encrypted.bin", size - 5, 5, 1, encryptedChunk},
+	}
+	for i, tt := range tests {
+		recorder.take()
+		name := filepath.Join(ft.dir, fmt.Sprintf("range%d", i))
+		status := &testStatus{done: make(chan error, 1)}
+		err = ft.a.DownloadFileRange(name, tt.remotePath, tt.offset, tt.length, status)
+		if err == nil {
+			err = status.wait(t)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := tt.offset + tt.length
+		if end > size {
+			end = size
+		}
+		if !bytes.Equal(data, content[tt.offset:end]) {
+			t.Errorf("%s: %d bytes differ from the range", tt.name, len(data))
+		}
+		first := tt.offset / (2 * tt.chunk)
+		if (end-1)/(2*tt.chunk)-first+1 != tt.blocks {
+			t.Fatalf("%s: test expects %d blocks", tt.name, tt.blocks)
+		}
+		recorder.checkBlocks(t, tt.name, ft.a.DataShards, [2]int64{first + 1, tt.blocks})
+	}
+
+	// Shared files are read the same way
+	ticket, err := ft.a.GetAuthTicketForShare("/plain.bin", "plain.bin", fileref.FILE, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.take()
+	name := filepath.Join(ft.dir, "shared")
+	status := &testStatus{done: make(chan error, 1)}
+	err = ft.a.DownloadFromAuthTicketRange(name, ticket, fileref.GetReferenceLookup(ft.a.ID, "/plain.bin"), "plain.bin", 2*chunk-10, 20, status)
+	if err == nil {
+		err = status.wait(t)
+	}
+	if err != nil {
+		t.Fatalf("shared range: %v", err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content[2*chunk-10:2*chunk+10]) {
+		t.Error("shared range differs")
+	}
+	recorder.checkBlocks(t, "shared range", ft.a.DataShards, [2]int64{1, 2})
+
+	status = &testStatus{done: make(chan error, 1)}
+	err = ft.a.DownloadFileRange(filepath.Join(ft.dir, "beyond"), "/plain.bin", size, 1, status)
+	if err == nil {
+		err = status.wait(t)
+	}
+	if err == nil {
+		t.Error("range beyond the file downloaded")
+	}
+	for _, r := range [][2]int64{{-1, 10}, {0, 0}} {
+		err = ft.a.DownloadFileRange(filepath.Join(ft.dir, "invalid"), "/plain.bin", r[0], r[1], nil)
+		if err == nil {
+			t.Errorf("range %v accepted", r)
+		}
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	ft := newFaultTest(t, "resume_download")
+	defer ft.Close()
+	// A request and a saved state for each block
+	SetNumBlockDownloads(1)
+	defer SetNumBlockDownloads(10)
+	content := randomContent(5*fileref.CHUNK_SIZE + 100)
+	sourcePath := filepath.Join(ft.dir, "source.bin")
+	err := ioutil.WriteFile(sourcePath, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ft.a.UploadFileCtx(context.Background(), sourcePath, "/resume.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentHash := sha1.Sum(content)
+	actualFileHash := hex.EncodeToString(contentHash[:])
+	blockSize := 2 * fileref.CHUNK_SIZE
+	recorder := newBlockRecorder(ft.injector)
+	zboxutil.SetHTTPClient(&http.Client{Transport: recorder})
+	// partial - a local file left by an earlier download with its state
+	partial := func(name string, data []byte, state *downloadState) string {
+		localPath := filepath.Join(ft.dir, name)
+		err := ioutil.WriteFile(localPath, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != nil {
+			err = state.save(localPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return localPath
+	}
+	resume := func(localPath string) error {
+		recorder.take()
+		status := &testStatus{done: make(chan error, 1)}
+		err := ft.a.ResumeDownload(localPath, "/resume.bin", status)
+		if err == nil {
+			err = status.wait(t)
+		}
+		return err
+	}
+	checkResumed := func(name string, localPath string) {
+		data, err := ioutil.ReadFile(localPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("%s: %d bytes differ from the remote file", name, len(data))
+		}
+		if _, err := os.Stat(downloadStatePath(localPath)); !os.IsNotExist(err) {
+			t.Errorf("%s: download state left", name)
+		}
+	}
+
+	// Continues after the saved block, over the part of the next one
+	// written after the state was saved
+	data := append(append([]byte{}, content[:blockSize]...), randomContent(100)...)
+	localPath := partial("resumed.bin", data, &downloadState{ActualFileHash: actualFileHash, ContentMode: DOWNLOAD_CONTENT_FULL, Block: 1})
+	err = resume(localPath)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	recorder.checkBlocks(t, "resume", ft.a.DataShards, [2]int64{2, 1}, [2]int64{3, 1})
+	checkResumed("resume", localPath)
+
+	// Starts over when the remote file changed
+	localPath = partial("changed.bin", randomContent(blockSize), &downloadState{ActualFileHash: "old", ContentMode: DOWNLOAD_CONTENT_FULL, Block: 1})
+	err = resume(localPath)
+	if err != nil {
+		t.Fatalf("resume of a changed file: %v", err)
+	}
+	recorder.checkBlocks(t, "changed", ft.a.DataShards, [2]int64{1, 1}, [2]int64{2, 1}, [2]int64{3, 1})
+	checkResumed("changed", localPath)
+
+	// Local files that can't be resumed are left as they are
+	tests := []struct {
+		name  string
+		data  []byte
+		state *downloadState
+	}{
+		{"no state", content[:blockSize], nil},
+		{"shorter than the state", content[:blockSize], &downloadState{ActualFileHash: actualFileHash, ContentMode: DOWNLOAD_CONTENT_FULL, Block: 2}},
+	}
+	for _, tt := range tests {
+		localPath = partial(tt.name, tt.data, tt.state)
+		err = resume(localPath)
+		if err == nil {
+			t.Errorf("%s: resumed", tt.name)
+		}
+		data, err := ioutil.ReadFile(localPath)
+		if err != nil || !bytes.Equal(data, tt.data) {
+			t.Errorf("%s: local file changed", tt.name)
+		}
+		if state := loadDownloadState(localPath); !reflect.DeepEqual(state, tt.state) {
+			t.Errorf("%s: state %+v", tt.name, state)
+		}
+	}
+}
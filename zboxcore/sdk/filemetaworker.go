@@ -10,7 +10,6 @@ import (
 	"net/http"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/0chain/gosdk/zboxcore/blockchain"
 	"github.com/0chain/gosdk/zboxcore/fileref"
@@ -37,9 +36,6 @@ func (req *ListRequest) getFileMetaInfoFromBlobber(blobber *blockchain.StorageNo
 		rspCh <- &fileMetaResponse{fileref: fileRef, responseStr: s.String(), blobberIdx: blobberIdx, err: err}
 	}
 	defer fileMetaRetFn()
-	if len(req.remotefilepath) > 0 {
-		req.remotefilepathhash = fileref.GetReferenceLookup(req.allocationID, req.remotefilepath)
-	}
 	formWriter.WriteField("path_hash", req.remotefilepathhash)
 
 	if req.authToken != nil {
@@ -59,7 +55,7 @@ func (req *ListRequest) getFileMetaInfoFromBlobber(blobber *blockchain.StorageNo
 	}
 
 	httpreq.Header.Add("Content-Type", formWriter.FormDataContentType())
-	ctx, cncl := context.WithTimeout(req.ctx, (time.Second * 30))
+	ctx, cncl := context.WithCancel(req.ctx)
 	err = zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
 		if err != nil {
 			Logger.Error("GetFileMeta : ", err)
@@ -88,6 +84,10 @@ func (req *ListRequest) getFileMetaFromBlobbers() []*fileMetaResponse {
 	req.wg = &sync.WaitGroup{}
 	req.wg.Add(numList)
 	rspCh := make(chan *fileMetaResponse, numList)
+	// Set before the requests, which all read it
+	if len(req.remotefilepath) > 0 {
+		req.remotefilepathhash = fileref.GetReferenceLookup(req.allocationID, req.remotefilepath)
+	}
 	for i := 0; i < numList; i++ {
 		go req.getFileMetaInfoFromBlobber(req.blobbers[i], i, rspCh)
 	}
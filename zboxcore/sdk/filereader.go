@@ -0,0 +1,248 @@
+package sdk
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+	"sync"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+)
+
+// FileReader - streams the content of a remote file. Blocks are fetched from
+// the blobbers and decoded on demand, and the next batch of blocks is read
+// ahead in the background while the current one is consumed.
+type FileReader struct {
+	req          *DownloadRequest
+	cancel       context.CancelFunc
+	fileRef      *fileref.FileRef
+	size         int64
+	expectedHash string
+	blockSize    int64
+	totalBlocks  int64
+	offset       int64
+	current      *blockBatch
+	readAhead    *blockBatch
+	hash         hash.Hash
+	hashOffset   int64
+	hashErr      error
+	hashChecked  bool
+	closed       bool
+	mutex        sync.Mutex
+}
+
+type blockBatch struct {
+	startBlock int64
+	data       []byte
+	err        error
+	done       chan struct{}
+}
+
+var errReaderClosed = common.NewError("reader_closed", "File reader is already closed")
+
+func newFileReader(req *DownloadRequest) (*FileReader, error) {
+	cancel := req.ctxCncl
+	listReq := &ListRequest{remotefilepath: req.remotefilepath, remotefilepathhash: req.remotefilepathhash, allocationID: req.allocationID, blobbers: req.blobbers, ctx: req.ctx}
+	listReq.authToken = req.authTicket
+	listReq.consensusThresh = req.consensusThresh
+	listReq.fullconsensus = req.fullconsensus
+	consensusMask, fileRef, _ := listReq.getFileConsensusFromBlobbers()
+	// Only the blobbers of the download mask the caller set are read
+	req.downloadMask &= consensusMask
+	if bits.OnesCount32(req.downloadMask) < req.datashards || fileRef == nil {
+		cancel()
+		return nil, common.NewError("consensus_not_met", "No minimum consensus for file meta data of file")
+	}
+	req.encryptedKey = fileRef.EncryptedKey
+
+	r := &FileReader{req: req, cancel: cancel, fileRef: fileRef, hash: sha1.New()}
+	r.size = fileRef.ActualFileSize
+	r.expectedHash = fileRef.ActualFileHash
+	if req.contentMode == DOWNLOAD_CONTENT_THUMB {
+		r.size = fileRef.ActualThumbnailSize
+		r.expectedHash = fileRef.ActualThumbnailHash
+	}
+	// Every block holds one chunk from each data shard.
+	chunkSize := int64(fileref.CHUNK_SIZE)
+	if len(fileRef.EncryptedKey) > 0 {
+		chunkSize -= 16
+		chunkSize -= 2 * 1024
+	}
+	r.blockSize = chunkSize * int64(req.datashards)
+	r.totalBlocks = (r.size + r.blockSize - 1) / r.blockSize
+	return r, nil
+}
+
+// Size - size of the content being read
+func (r *FileReader) Size() int64 {
+	return r.size
+}
+
+// FileRef - reference of the remote file being read
+func (r *FileReader) FileRef() *fileref.FileRef {
+	return r.fileRef
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n, err := r.readAt(p, r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *FileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, common.NewError("invalid_offset", "Negative offset")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.readAt(p, off)
+}
+
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return 0, errReaderClosed
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, common.NewError("invalid_whence", "Invalid whence for seek")
+	}
+	if abs < 0 {
+		return 0, common.NewError("invalid_offset", "Negative position")
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+// Close - stops any block download in progress. The reader can't be used
+// after it is closed.
+func (r *FileReader) Close() error {
+	// A read holds the lock while it waits for the download, stopped first
+	r.cancel()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.current = nil
+	r.readAhead = nil
+	return nil
+}
+
+func (r *FileReader) readAt(p []byte, off int64) (int, error) {
+	if r.closed {
+		return 0, errReaderClosed
+	}
+	read := 0
+	for read < len(p) && off < r.size {
+		batch, err := r.getBatch(off)
+		if err != nil {
+			return read, err
+		}
+		batchOffset := (batch.startBlock - 1) * r.blockSize
+		end := int64(len(batch.data))
+		if batchOffset+end > r.size {
+			end = r.size - batchOffset
+		}
+		n := copy(p[read:], batch.data[off-batchOffset:end])
+		r.updateHash(p[read:read+n], off)
+		read += n
+		off += int64(n)
+	}
+	if off >= r.size {
+		if err := r.verifyHash(); err != nil {
+			return read, err
+		}
+		if read < len(p) {
+			return read, io.EOF
+		}
+	}
+	return read, nil
+}
+
+// getBatch - returns the downloaded batch holding the byte at off. Batches are
+// aligned on numBlocks so sequential and random reads share them.
+func (r *FileReader) getBatch(off int64) (*blockBatch, error) {
+	batchIdx := (off / r.blockSize) / r.req.numBlocks
+	startBlock := batchIdx*r.req.numBlocks + 1
+	if r.current == nil || r.current.startBlock != startBlock {
+		r.current = nil
+		if r.readAhead != nil {
+			// Only one batch is downloaded at a time
+			<-r.readAhead.done
+			if r.readAhead.startBlock == startBlock {
+				r.current = r.readAhead
+			}
+			r.readAhead = nil
+		}
+		if r.current == nil {
+			r.current = r.fetch(startBlock)
+			<-r.current.done
+		}
+		nextBlock := startBlock + r.req.numBlocks
+		if r.current.err == nil && nextBlock <= r.totalBlocks {
+			r.readAhead = r.fetch(nextBlock)
+		}
+	}
+	batch := r.current
+	if batch.err != nil {
+		r.current = nil
+		return nil, common.NewError("download_failed", fmt.Sprintf("Download failed for block %d. Error : %s", batch.startBlock, batch.err.Error()))
+	}
+	if int64(len(batch.data)) <= off-(startBlock-1)*r.blockSize {
+		r.current = nil
+		return nil, common.NewError("download_failed", fmt.Sprintf("Incomplete data for block %d", batch.startBlock))
+	}
+	return batch, nil
+}
+
+func (r *FileReader) fetch(startBlock int64) *blockBatch {
+	batch := &blockBatch{startBlock: startBlock, done: make(chan struct{})}
+	numBlocks := r.req.numBlocks
+	if startBlock+numBlocks-1 > r.totalBlocks {
+		numBlocks = r.totalBlocks - startBlock + 1
+	}
+	go func() {
+		defer close(batch.done)
+		batch.data, batch.err = r.req.downloadBlock(startBlock, numBlocks)
+	}()
+	return batch
+}
+
+// updateHash - hashes the content as long as it is read in sequence from the
+// start. Content skipped by a seek can't be verified.
+func (r *FileReader) updateHash(data []byte, off int64) {
+	end := off + int64(len(data))
+	if off > r.hashOffset || end <= r.hashOffset {
+		return
+	}
+	r.hash.Write(data[r.hashOffset-off:])
+	r.hashOffset = end
+}
+
+func (r *FileReader) verifyHash() error {
+	if r.hashChecked || r.hashOffset != r.size {
+		return r.hashErr
+	}
+	r.hashChecked = true
+	if hex.EncodeToString(r.hash.Sum(nil)) != r.expectedHash {
+		r.hashErr = common.NewError("hash_mismatch", "File content didn't match with uploaded file")
+	}
+	return r.hashErr
+}
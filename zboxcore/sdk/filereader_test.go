@@ -0,0 +1,195 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0chain/gosdk/zboxcore/blobbertest"
+	"github.com/0chain/gosdk/zboxcore/client"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+// openTestFile - uploads content of blocks whole blocks and a part of one,
+// and opens it for reading a block per download
+func openTestFile(t *testing.T, ft *faultTest, blocks int) ([]byte, *FileReader) {
+	blockSize := fileref.CHUNK_SIZE * ft.a.DataShards
+	content := make([]byte, blocks*blockSize+1000)
+	rand.Read(content)
+	localPath := filepath.Join(ft.dir, "read.bin")
+	err := ioutil.WriteFile(localPath, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ft.a.UploadFileCtx(context.Background(), localPath, "/read.bin", nil)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	r, err := ft.a.OpenFile("/read.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.req.numBlocks = 1
+	return content, r
+}
+
+// closeReader - closes r and waits for its block downloads, which otherwise
+// outlive the test
+func closeReader(r *FileReader) {
+	r.mutex.Lock()
+	batches := []*blockBatch{r.current, r.readAhead}
+	r.mutex.Unlock()
+	r.Close()
+	for _, batch := range batches {
+		if batch != nil {
+			<-batch.done
+		}
+	}
+}
+
+func TestFileReaderSeek(t *testing.T) {
+	ft := newFaultTest(t, "file_reader_seek")
+	defer ft.Close()
+	content, r := openTestFile(t, ft, 3)
+	defer closeReader(r)
+	size := int64(len(content))
+	tests := []struct {
+		offset int64
+		whence int
+		pos    int64
+	}{
+		{-100, io.SeekEnd, size - 100},
+		{-200, io.SeekCurrent, size - 300},
+		{10, io.SeekStart, 10},
+		{r.blockSize, io.SeekCurrent, 10 + r.blockSize},
+	}
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.pos {
+			t.Fatalf("seek %d from %d: %d %v, expected %d", tt.offset, tt.whence, pos, err, tt.pos)
+		}
+		p := make([]byte, 50)
+		n, err := r.Read(p)
+		if err != nil || n != len(p) || !bytes.Equal(p, content[pos:pos+50]) {
+			t.Fatalf("read at %d: %d %v", pos, n, err)
+		}
+		// Back to where the seek went
+		r.Seek(-50, io.SeekCurrent)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seek to a negative position")
+	}
+	r.Seek(0, io.SeekStart)
+	data, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("read from the start after seeks: %v", err)
+	}
+}
+
+func TestFileReaderReadAt(t *testing.T) {
+	ft := newFaultTest(t, "file_reader_read_at")
+	defer ft.Close()
+	content, r := openTestFile(t, ft, 3)
+	defer closeReader(r)
+	size := int64(len(content))
+	tests := []struct {
+		off    int64
+		length int64
+		n      int64
+		eof    bool
+	}{
+		// Within a block, over one boundary, over a whole block
+		{0, 100, 100, false},
+		{r.blockSize - 10, 20, 20, false},
+		{2*r.blockSize - 1, r.blockSize + 2, r.blockSize + 2, false},
+		// Into the part of the last block, and past the end
+		{3*r.blockSize - 5, 10, 10, false},
+		{size - 5, 10, 5, true},
+		{size, 10, 0, true},
+	}
+	for _, tt := range tests {
+		p := make([]byte, tt.length)
+		n, err := r.ReadAt(p, tt.off)
+		if int64(n) != tt.n || (err == io.EOF) != tt.eof || (err != nil && err != io.EOF) {
+			t.Errorf("read %d at %d: %d %v, expected %d eof %v", tt.length, tt.off, n, err, tt.n, tt.eof)
+			continue
+		}
+		if !bytes.Equal(p[:n], content[tt.off:tt.off+int64(n)]) {
+			t.Errorf("read %d at %d: content differs", tt.length, tt.off)
+		}
+	}
+}
+
+func TestFileReaderHashCheck(t *testing.T) {
+	ft := newFaultTest(t, "file_reader_hash_check")
+	defer ft.Close()
+	err := ft.network.Blobbers[0].CorruptShard("/file.bin", client.Sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The parity blobber answers last, the corrupt data shard is decoded
+	ft.injector.SetFaults(ft.blobberURL(2), blobbertest.Fault{Operations: []string{zboxutil.OperationDownload}, Latency: 50 * time.Millisecond})
+	r, err := ft.a.OpenFile("/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeReader(r)
+
+	// Reading only a part checks nothing
+	p := make([]byte, 100)
+	if _, err = r.ReadAt(p, 1000); err != nil {
+		t.Fatalf("read of a part: %v", err)
+	}
+	p = make([]byte, len(ft.content))
+	n, err := io.ReadFull(r, p)
+	if n != len(p) {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	// The error of the read filling the buffer may be held back, the next
+	// read returns it
+	if err == nil {
+		_, err = r.Read(make([]byte, 1))
+	}
+	if err == nil || err == io.EOF {
+		t.Fatalf("corrupt content read with %v", err)
+	}
+}
+
+func TestFileReaderCloseDuringRead(t *testing.T) {
+	ft := newFaultTest(t, "file_reader_close")
+	defer ft.Close()
+	r, err := ft.a.OpenFile("/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the close ends the download
+	zboxutil.SetRetryPolicy(&zboxutil.RetryPolicy{})
+	for idx := range ft.network.Blobbers {
+		ft.injector.SetFaults(ft.blobberURL(idx), blobbertest.Fault{Operations: []string{zboxutil.OperationDownload}, Latency: 10 * time.Second})
+	}
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 100))
+		readErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close waited for the read")
+	}
+	if err := <-readErr; err == nil {
+		t.Error("read of a closed reader succeeded")
+	}
+}
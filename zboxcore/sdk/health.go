@@ -0,0 +1,246 @@
+package sdk
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+)
+
+// File health, from the number of blobbers agreeing on the file
+const (
+	// On every blobber
+	HealthOK = "ok"
+	// Below full redundancy, with enough blobbers for updates to succeed
+	HealthDegraded = "degraded"
+	// Readable, but below the consensus required for updates to succeed
+	HealthBelowConsensus = "below_consensus"
+	// Fewer blobbers than data shards agree, the content can't be decoded
+	HealthUnrecoverable = "unrecoverable"
+)
+
+type FileHealth struct {
+	Path               string   `json:"path"`
+	Status             string   `json:"status"`
+	ActualFileHash     string   `json:"actual_file_hash,omitempty"`
+	Size               int64    `json:"size"`
+	HealthyBlobbers    []string `json:"healthy_blobbers"`
+	MissingBlobbers    []string `json:"missing_blobbers,omitempty"`
+	MismatchedBlobbers []string `json:"mismatched_blobbers,omitempty"`
+	// Blobbers the directory of the file couldn't be listed on, which may
+	// still hold it
+	UnreachableBlobbers []string `json:"unreachable_blobbers,omitempty"`
+	Repaired            bool     `json:"repaired,omitempty"`
+	RepairError         string   `json:"repair_error,omitempty"`
+}
+
+// HealthReport - result of CheckHealth. Files lists only the files that are
+// not on every blobber.
+type HealthReport struct {
+	AllocationID   string        `json:"allocation_id"`
+	TotalFiles     int           `json:"total_files"`
+	Healthy        int           `json:"healthy"`
+	Degraded       int           `json:"degraded"`
+	BelowConsensus int           `json:"below_consensus"`
+	Unrecoverable  int           `json:"unrecoverable"`
+	Repaired       int           `json:"repaired"`
+	Files          []*FileHealth `json:"files"`
+	// Directories none of the blobbers could list. The files under them are
+	// not checked.
+	UnreachableDirs []string `json:"unreachable_dirs,omitempty"`
+}
+
+// CheckHealth - walks the allocation and compares the file meta data listed
+// by each blobber, reporting the files below full redundancy. A directory no
+// blobber could list is reported as unreachable and the walk goes on. With
+// repair set the degraded and below consensus files are repaired, at most
+// concurrency at a time.
+func (a *Allocation) CheckHealth(repair bool, concurrency int) (*HealthReport, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	if len(a.Blobbers) <= 1 {
+		return nil, noBLOBBERS
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	report := &HealthReport{AllocationID: a.ID, Files: make([]*FileHealth, 0)}
+	dirs := []string{"/"}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		childDirs, files, err := a.checkDirHealth(dir)
+		if err != nil {
+			Logger.Error("Health check of ", dir, " skipped: ", err)
+			report.UnreachableDirs = append(report.UnreachableDirs, dir)
+			continue
+		}
+		dirs = append(dirs, childDirs...)
+		for _, file := range files {
+			report.TotalFiles++
+			switch file.Status {
+			case HealthOK:
+				report.Healthy++
+				continue
+			case HealthDegraded:
+				report.Degraded++
+			case HealthBelowConsensus:
+				report.BelowConsensus++
+			case HealthUnrecoverable:
+				report.Unrecoverable++
+			}
+			report.Files = append(report.Files, file)
+		}
+	}
+
+	if repair {
+		a.repairUnhealthy(report, concurrency)
+	}
+	return report, nil
+}
+
+func (a *Allocation) newHealthListRequest(dir string) *ListRequest {
+	listReq := &ListRequest{}
+	listReq.allocationID = a.ID
+	listReq.blobbers = a.Blobbers
+	listReq.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
+	listReq.fullconsensus = float32(a.DataShards + a.ParityShards)
+	listReq.ctx = a.ctx
+	listReq.remotefilepath = dir
+	return listReq
+}
+
+// checkDirHealth - lists dir on every blobber. Returns the sub directories
+// seen by any blobber and the health of the files in dir.
+func (a *Allocation) checkDirHealth(dir string) ([]string, []*FileHealth, error) {
+	listReq := a.newHealthListRequest(dir)
+	lR := listReq.getlistFromBlobbers()
+	dirSet := make(map[string]bool)
+	// path -> actual file hash -> blobber indexes holding it
+	versions := make(map[string]map[string][]int)
+	sizes := make(map[string]int64)
+	unreachable := make(map[int]bool)
+	listed := 0
+	for _, rsp := range lR {
+		if rsp.err != nil || rsp.ref == nil {
+			Logger.Error("Health check list ", dir, " on ", a.Blobbers[rsp.blobberIdx].Baseurl, ": ", rsp.err)
+			unreachable[rsp.blobberIdx] = true
+			continue
+		}
+		listed++
+		for _, child := range rsp.ref.Children {
+			if child.GetType() == fileref.DIRECTORY {
+				dirSet[child.GetPath()] = true
+				continue
+			}
+			fileRef, ok := child.(*fileref.FileRef)
+			if !ok {
+				continue
+			}
+			if _, ok := versions[fileRef.Path]; !ok {
+				versions[fileRef.Path] = make(map[string][]int)
+			}
+			versions[fileRef.Path][fileRef.ActualFileHash] = append(versions[fileRef.Path][fileRef.ActualFileHash], rsp.blobberIdx)
+			sizes[fileRef.Path+":"+fileRef.ActualFileHash] = fileRef.ActualFileSize
+		}
+	}
+
+	if listed == 0 {
+		return nil, nil, common.NewError("list_failed", "None of the blobbers could list "+dir)
+	}
+
+	childDirs := make([]string, 0, len(dirSet))
+	for childDir := range dirSet {
+		childDirs = append(childDirs, childDir)
+	}
+	sort.Strings(childDirs)
+	files := make([]*FileHealth, 0, len(versions))
+	for path, fileVersions := range versions {
+		files = append(files, a.getFileHealth(path, fileVersions, sizes, unreachable))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return childDirs, files, nil
+}
+
+func (a *Allocation) getFileHealth(path string, versions map[string][]int, sizes map[string]int64, unreachable map[int]bool) *FileHealth {
+	file := &FileHealth{Path: path}
+	var agreed []int
+	for hash, blobberIdxs := range versions {
+		if len(blobberIdxs) > len(agreed) || (len(blobberIdxs) == len(agreed) && hash < file.ActualFileHash) {
+			agreed = blobberIdxs
+			file.ActualFileHash = hash
+		}
+	}
+	file.Size = sizes[path+":"+file.ActualFileHash]
+	holders := make(map[int]bool)
+	for hash, blobberIdxs := range versions {
+		for _, idx := range blobberIdxs {
+			holders[idx] = true
+			if hash != file.ActualFileHash {
+				file.MismatchedBlobbers = append(file.MismatchedBlobbers, a.Blobbers[idx].ID)
+			}
+		}
+	}
+	for _, idx := range agreed {
+		file.HealthyBlobbers = append(file.HealthyBlobbers, a.Blobbers[idx].ID)
+	}
+	for idx, blobber := range a.Blobbers {
+		switch {
+		case unreachable[idx]:
+			file.UnreachableBlobbers = append(file.UnreachableBlobbers, blobber.ID)
+		case !holders[idx]:
+			file.MissingBlobbers = append(file.MissingBlobbers, blobber.ID)
+		}
+	}
+
+	consensus := &Consensus{}
+	consensus.consensus = float32(len(agreed))
+	consensus.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
+	consensus.fullconsensus = float32(a.DataShards + a.ParityShards)
+	switch {
+	case len(agreed) == len(a.Blobbers):
+		file.Status = HealthOK
+	case consensus.isConsensusOk():
+		file.Status = HealthDegraded
+	case consensus.isConsensusMin():
+		file.Status = HealthBelowConsensus
+	default:
+		file.Status = HealthUnrecoverable
+	}
+	return file
+}
+
+// repairUnhealthy - repairs the recoverable files of the report, with at most
+// concurrency repairs running at a time
+func (a *Allocation) repairUnhealthy(report *HealthReport, concurrency int) {
+	sem := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	mutex := &sync.Mutex{}
+	for _, file := range report.Files {
+		if file.Status != HealthDegraded && file.Status != HealthBelowConsensus {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(file *FileHealth) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := a.repairFile(file.Path, nil)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				Logger.Error("Repair of ", file.Path, " failed: ", err)
+				file.RepairError = err.Error()
+				return
+			}
+			file.Repaired = true
+			report.Repaired++
+		}(file)
+	}
+	wg.Wait()
+}
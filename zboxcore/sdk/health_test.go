@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/0chain/gosdk/zboxcore/client"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+// listFailer - fails the listings of a directory on every blobber
+type listFailer struct {
+	next     http.RoundTripper
+	pathHash string
+}
+
+func (f *listFailer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if zboxutil.GetOperation(req) == zboxutil.OperationList && req.URL.Query().Get("path_hash") == f.pathHash {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, errors.New("listing failed by the test")
+	}
+	return f.next.RoundTrip(req)
+}
+
+func TestCheckHealth(t *testing.T) {
+	ft := newFaultTest(t, "check_health")
+	defer ft.Close()
+	localPath := filepath.Join(ft.dir, "file.bin")
+	upload := func(remotePath string) {
+		_, err := ft.a.UploadFileCtx(context.Background(), localPath, remotePath, nil)
+		if err != nil {
+			t.Fatalf("upload of %s: %v", remotePath, err)
+		}
+	}
+	upload("/a/ok.bin")
+	upload("/b/hidden.bin")
+	upload("/a/lost.bin")
+	// Lost by the last blobber
+	if err := ft.network.Blobbers[2].RemoveFile("/a/lost.bin", client.Sign); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files under /b can't be checked, the others are
+	zboxutil.SetHTTPClient(&http.Client{Transport: &listFailer{next: ft.injector, pathHash: fileref.GetReferenceLookup(ft.a.ID, "/b")}})
+	report, err := ft.a.CheckHealth(false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalFiles != 3 || report.Healthy != 2 || report.BelowConsensus != 1 || report.Repaired != 0 {
+		t.Errorf("report %+v", report)
+	}
+	if !reflect.DeepEqual(report.UnreachableDirs, []string{"/b"}) {
+		t.Errorf("unreachable dirs %v", report.UnreachableDirs)
+	}
+	if len(report.Files) != 1 {
+		t.Fatalf("files %v", report.Files)
+	}
+	file := report.Files[0]
+	missing := []string{ft.a.Blobbers[2].ID}
+	if file.Path != "/a/lost.bin" || file.Status != HealthBelowConsensus || len(file.HealthyBlobbers) != 2 || !reflect.DeepEqual(file.MissingBlobbers, missing) || file.Repaired {
+		t.Errorf("file %+v", file)
+	}
+
+	// The repair restores the file on the last blobber
+	report, err = ft.a.CheckHealth(true, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired != 1 || len(report.Files) != 1 || !report.Files[0].Repaired || len(report.Files[0].RepairError) > 0 {
+		t.Fatalf("repair report %+v", report)
+	}
+	if ft.network.Blobbers[2].GetRef("/a/lost.bin") == nil {
+		t.Error("file not repaired")
+	}
+
+	zboxutil.SetHTTPClient(ft.injector.Client())
+	report, err = ft.a.CheckHealth(false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalFiles != 4 || report.Healthy != 4 || len(report.Files) != 0 || len(report.UnreachableDirs) != 0 {
+		t.Errorf("report after the repair %+v", report)
+	}
+}
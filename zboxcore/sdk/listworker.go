@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/0chain/gosdk/core/encryption"
 	"github.com/0chain/gosdk/zboxcore/blockchain"
@@ -41,6 +40,8 @@ type ListResult struct {
 	Path          string        `json:"path,omitempty"`
 	Type          string        `json:"type"`
 	Size          int64         `json:"size"`
+	ActualSize    int64         `json:"actual_size,omitempty"`
+	UpdatedAt     string        `json:"updated_at,omitempty"`
 	Hash          string        `json:"hash,omitempty"`
 	MimeType      string        `json:"mimetype,omitempty"`
 	NumBlocks     int64         `json:"num_blocks"`
@@ -87,7 +88,7 @@ func (req *ListRequest) getListInfoFromBlobber(blobber *blockchain.StorageNode,
 	}
 
 	//httpreq.Header.Add("Content-Type", formWriter.FormDataContentType())
-	ctx, cncl := context.WithTimeout(req.ctx, (time.Second * 30))
+	ctx, cncl := context.WithCancel(req.ctx)
 	err = zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
 		if err != nil {
 			Logger.Error("List : ", err)
@@ -175,6 +176,10 @@ func (req *ListRequest) GetListFromBlobbers() *ListResult {
 				childResult.Hash = (child.(*fileref.FileRef)).ActualFileHash
 				childResult.MimeType = (child.(*fileref.FileRef)).MimeType
 				childResult.EncryptionKey = (child.(*fileref.FileRef)).EncryptedKey
+				childResult.ActualSize = (child.(*fileref.FileRef)).ActualFileSize
+				if updatedAt := (child.(*fileref.FileRef)).UpdatedAt; updatedAt > childResult.UpdatedAt {
+					childResult.UpdatedAt = updatedAt
+				}
 			}
 			childResult.Size += child.GetSize()
 			childResult.NumBlocks += child.GetNumBlocks()
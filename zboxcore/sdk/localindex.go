@@ -0,0 +1,138 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/0chain/gosdk/zboxcore/logger"
+)
+
+const localIndexVersion = 1
+
+type localIndexEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+	Inode   uint64 `json:"inode"`
+	Hash    string `json:"hash"`
+}
+
+// localIndex - hashes of the local files from the previous scans. A file is
+// hashed again only when its size, modification time or inode changed.
+type localIndex struct {
+	Version int                         `json:"version"`
+	Entries map[string]*localIndexEntry `json:"entries"`
+	path    string
+	seen    map[string]bool
+}
+
+// loadLocalIndex - loads the index saved at indexPath. The index is kept in
+// memory only when indexPath is empty, and starts empty when it can't be
+// read.
+func loadLocalIndex(indexPath string) *localIndex {
+	idx := &localIndex{Version: localIndexVersion, Entries: make(map[string]*localIndexEntry), path: indexPath, seen: make(map[string]bool)}
+	if len(indexPath) == 0 {
+		return idx
+	}
+	b, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Logger.Error("Reading the local index failed: ", err)
+		}
+		return idx
+	}
+	saved := &localIndex{}
+	err = json.Unmarshal(b, saved)
+	if err != nil || saved.Version != localIndexVersion || saved.Entries == nil {
+		Logger.Error("Local index ", indexPath, " is not valid, rebuilding it")
+		return idx
+	}
+	idx.Entries = saved.Entries
+	return idx
+}
+
+// hash - hash of the file at absPath, from the index when it didn't change
+func (idx *localIndex) hash(relPath string, absPath string, info os.FileInfo) (string, error) {
+	idx.seen[relPath] = true
+	inode := fileInode(info)
+	if e, ok := idx.Entries[relPath]; ok && e.Size == info.Size() && e.ModTime == info.ModTime().UnixNano() && e.Inode == inode {
+		return e.Hash, nil
+	}
+	hash, err := calcFileHash(absPath)
+	if err != nil {
+		delete(idx.Entries, relPath)
+		return "", err
+	}
+	idx.Entries[relPath] = &localIndexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Inode: inode, Hash: hash}
+	return hash, nil
+}
+
+// save - saves the index. After a scan of the whole tree prune drops the
+// entries of the files not seen by it.
+func (idx *localIndex) save(prune bool) error {
+	if len(idx.path) == 0 {
+		return nil
+	}
+	for relPath := range idx.Entries {
+		if prune && !idx.seen[relPath] {
+			delete(idx.Entries, relPath)
+		}
+	}
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(idx.path), 0700)
+	if err != nil {
+		return err
+	}
+	tmpPath := idx.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, idx.path)
+}
+
+// LocalScanError - local paths that couldn't be read while computing the
+// diff. They are left out of the diff on both sides, so that a file which
+// can't be read is not taken as deleted.
+type LocalScanError struct {
+	Errors map[string]error
+}
+
+func (e *LocalScanError) Error() string {
+	paths := make([]string, 0, len(e.Errors))
+	for p := range e.Errors {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	msgs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		msgs = append(msgs, p+": "+e.Errors[p].Error())
+	}
+	return fmt.Sprintf("%d local paths couldn't be read. %s", len(paths), strings.Join(msgs, "; "))
+}
+
+// excludePaths - removes the paths and everything under them from the map
+func excludePaths(fMap map[string]fileInfo, paths map[string]error) {
+	for fPath := range fMap {
+		if isExcluded(fPath, paths) {
+			delete(fMap, fPath)
+		}
+	}
+}
+
+// isExcluded - whether fPath is one of the paths or under one of them
+func isExcluded(fPath string, paths map[string]error) bool {
+	for p := range paths {
+		if fPath == p || strings.HasPrefix(fPath, strings.TrimRight(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package sdk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalIndexHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	absPath := filepath.Join(dir, "a.txt")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write := func(content string) os.FileInfo {
+		err := ioutil.WriteFile(absPath, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(absPath, modTime, modTime)
+		info, err := os.Lstat(absPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	idx := loadLocalIndex("")
+	info := write("first")
+	hash, err := idx.hash("/a.txt", absPath, info)
+	expected, _ := calcFileHash(absPath)
+	if err != nil || hash != expected {
+		t.Fatalf("hash %s %v, expected %s", hash, err, expected)
+	}
+	// Only the index tells the hash of an unchanged file
+	idx.Entries["/a.txt"].Hash = "indexed"
+	if hash, _ = idx.hash("/a.txt", absPath, info); hash != "indexed" {
+		t.Errorf("unchanged file hashed again")
+	}
+
+	// Same size and modification time, the content written in place
+	info = write("other")
+	if hash, _ = idx.hash("/a.txt", absPath, info); hash != "indexed" {
+		t.Errorf("file of the same stat hashed again")
+	}
+	modTime = modTime.Add(time.Second)
+	info = write("other")
+	expected, _ = calcFileHash(absPath)
+	if hash, _ = idx.hash("/a.txt", absPath, info); hash != expected {
+		t.Errorf("modified file not hashed again")
+	}
+	idx.Entries["/a.txt"].Hash = "indexed"
+	info = write("longer")
+	expected, _ = calcFileHash(absPath)
+	if hash, _ = idx.hash("/a.txt", absPath, info); hash != expected {
+		t.Errorf("resized file not hashed again")
+	}
+
+	// A file replaced by one of the same size and modification time
+	if fileInode(info) != 0 {
+		idx.Entries["/a.txt"].Hash = "indexed"
+		tmpPath := filepath.Join(dir, "b.txt")
+		err = ioutil.WriteFile(tmpPath, []byte("latter"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(tmpPath, modTime, modTime)
+		err = os.Rename(tmpPath, absPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, _ = os.Lstat(absPath)
+		expected, _ = calcFileHash(absPath)
+		if hash, _ = idx.hash("/a.txt", absPath, info); hash != expected {
+			t.Errorf("replaced file not hashed again")
+		}
+	}
+
+	// A file modified and gone since the stat
+	modTime = modTime.Add(time.Second)
+	info = write("latest")
+	os.Remove(absPath)
+	if _, err = idx.hash("/a.txt", absPath, info); err == nil {
+		t.Error("hash of a deleted file")
+	}
+	if _, ok := idx.Entries["/a.txt"]; ok {
+		t.Error("deleted file left in the index")
+	}
+}
+
+func TestLocalIndexSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	indexPath := filepath.Join(dir, "state.index")
+	idx := loadLocalIndex(indexPath)
+	idx.Entries["/seen"] = &localIndexEntry{Hash: "1"}
+	idx.Entries["/unseen"] = &localIndexEntry{Hash: "2"}
+	idx.seen["/seen"] = true
+
+	err = idx.save(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded := loadLocalIndex(indexPath); len(loaded.Entries) != 2 {
+		t.Errorf("saved without pruning: %v", loaded.Entries)
+	}
+	err = idx.save(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := loadLocalIndex(indexPath)
+	if len(loaded.Entries) != 1 || loaded.Entries["/seen"] == nil || loaded.Entries["/seen"].Hash != "1" {
+		t.Errorf("saved after a scan: %v", loaded.Entries)
+	}
+
+	ioutil.WriteFile(indexPath, []byte(`{"version":2,"entries":{}}`), 0600)
+	if loaded = loadLocalIndex(indexPath); len(loaded.Entries) != 0 {
+		t.Error("index of another version loaded")
+	}
+}
+
+func TestLocalScanError(t *testing.T) {
+	st := newSyncTest(t, "local_scan_error")
+	defer st.Close()
+	// The synced file can't be read, and a new one neither
+	filePath := filepath.Join(st.root, "file.bin")
+	os.Remove(filePath)
+	err := os.Symlink(filepath.Join(st.dir, "missing"), filePath)
+	if err != nil {
+		t.Skip("symlinks not supported: ", err)
+	}
+	os.Symlink(filepath.Join(st.dir, "missing"), filepath.Join(st.root, "new.bin"))
+	st.writeLocal(t, "/readable.bin", randomContent(100))
+
+	diff, scanErr, err := st.a.GetAllocationDiffWithScanErrors(st.statePath, st.root, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanErr == nil || len(scanErr.Errors) != 2 || scanErr.Errors["/file.bin"] == nil || scanErr.Errors["/new.bin"] == nil {
+		t.Fatalf("scan errors %v", scanErr)
+	}
+	// The file is not taken as deleted, the rest of the tree is synced
+	expected := []FileDiff{{Op: Upload, Path: "/readable.bin", Type: "f"}}
+	if len(diff) != 1 || diff[0] != expected[0] {
+		t.Errorf("diff %v, expected %v", diff, expected)
+	}
+	if _, err = st.a.GetAllocationDiff(st.statePath, st.root, nil, nil); err == nil {
+		t.Error("diff without the scan errors")
+	} else if _, ok := err.(*LocalScanError); !ok {
+		t.Errorf("error %T", err)
+	}
+
+	// Nor after a sync of the rest
+	report, err := st.a.ApplyDiff(diff, st.root, &ApplyDiffOptions{SnapshotPath: st.statePath})
+	if err != nil || report.Completed != 1 {
+		t.Fatalf("apply: %v", err)
+	}
+	os.Remove(filePath)
+	err = st.a.DownloadFileCtx(context.Background(), filePath, "/file.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(st.root, "new.bin"))
+	if diff = st.diff(t); len(diff) > 0 {
+		t.Errorf("diff after the file is readable again %v", diff)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package sdk
+
+import "os"
+
+// fileInode - file IDs are not part of the stat info on windows, the index
+// relies on the size and modification time
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,181 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"sync"
+
+	"github.com/0chain/gosdk/zboxcore/allocationchange"
+	"github.com/0chain/gosdk/zboxcore/blockchain"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+)
+
+// MoveRequest - copies the object to the destination directory and deletes
+// the source under the same connection, so that each blobber commits the
+// move with a single write marker
+type MoveRequest struct {
+	allocationID   string
+	blobbers       []*blockchain.StorageNode
+	remotefilepath string
+	destPath       string
+	ctx            context.Context
+	wg             *sync.WaitGroup
+	moveMask       uint32
+	connectionID   string
+	mutex          sync.Mutex
+	Consensus
+}
+
+func (req *MoveRequest) moveBlobberObject(blobber *blockchain.StorageNode, blobberIdx int) (fileref.RefEntity, error) {
+	copyReq := &CopyRequest{}
+	copyReq.allocationID = req.allocationID
+	copyReq.blobbers = req.blobbers
+	copyReq.remotefilepath = req.remotefilepath
+	copyReq.destPath = req.destPath
+	copyReq.ctx = req.ctx
+	copyReq.connectionID = req.connectionID
+	refEntity, err := copyReq.copyBlobberObject(blobber, blobberIdx)
+	if err != nil {
+		return nil, err
+	}
+	if copyReq.copyMask == 0 {
+		return nil, fmt.Errorf("%s copy of %s failed", blobber.Baseurl, req.remotefilepath)
+	}
+
+	deleteReq := &DeleteRequest{}
+	deleteReq.allocationID = req.allocationID
+	deleteReq.blobbers = req.blobbers
+	deleteReq.remotefilepath = req.remotefilepath
+	deleteReq.ctx = req.ctx
+	deleteReq.connectionID = req.connectionID
+	deleteReq.wg = &sync.WaitGroup{}
+	deleteReq.wg.Add(1)
+	deleteReq.deleteBlobberFile(blobber, blobberIdx, refEntity)
+	if deleteReq.deleteMask == 0 {
+		// Drop the copy queued in the connection
+		err = deleteConnection(req.ctx, blobber, req.allocationID, req.connectionID)
+		if err != nil {
+			Logger.Error(blobber.Baseurl, " dropping the copy of the failed move failed: ", err)
+		}
+		return nil, fmt.Errorf("%s delete of %s failed", blobber.Baseurl, req.remotefilepath)
+	}
+
+	req.mutex.Lock()
+	defer req.mutex.Unlock()
+	req.consensus++
+	req.moveMask |= (1 << uint32(blobberIdx))
+	return refEntity, nil
+}
+
+func (req *MoveRequest) ProcessMove() error {
+	numList := len(req.blobbers)
+	objectTreeRefs := make([]fileref.RefEntity, numList)
+	req.wg = &sync.WaitGroup{}
+	req.wg.Add(numList)
+	for i := 0; i < numList; i++ {
+		go func(blobberIdx int) {
+			defer req.wg.Done()
+			refEntity, err := req.moveBlobberObject(req.blobbers[blobberIdx], blobberIdx)
+			if err != nil {
+				Logger.Error(err.Error())
+				return
+			}
+			objectTreeRefs[blobberIdx] = refEntity
+		}(i)
+	}
+	req.wg.Wait()
+
+	if !req.isConsensusOk() {
+		req.dropConnection()
+		return fmt.Errorf("Move failed: Move request failed. Operation failed.")
+	}
+
+	req.consensus = 0
+	wg := &sync.WaitGroup{}
+	wg.Add(bits.OnesCount32(req.moveMask))
+	commitReqs := make([]*CommitRequest, bits.OnesCount32(req.moveMask))
+	c, pos := 0, 0
+	for i := req.moveMask; i != 0; i &= ^(1 << uint32(pos)) {
+		pos = bits.TrailingZeros32(i)
+		commitReq := &CommitRequest{}
+		commitReq.allocationID = req.allocationID
+		commitReq.blobber = req.blobbers[pos]
+		// The delete has to be processed first. It finds the source by the
+		// object tree paths, which the copy change then rewrites to the
+		// destination in its own copy of the tree.
+		deleteChange := &allocationchange.DeleteFileChange{}
+		deleteChange.ObjectTree = objectTreeRefs[pos]
+		deleteChange.NumBlocks = deleteChange.ObjectTree.GetNumBlocks()
+		deleteChange.Operation = allocationchange.DELETE_OPERATION
+		deleteChange.Size = deleteChange.ObjectTree.GetSize()
+		commitReq.changes = append(commitReq.changes, deleteChange)
+		copyChange := &allocationchange.CopyFileChange{}
+		copyChange.DestPath = req.destPath
+		copyChange.ObjectTree = cloneObjectTree(objectTreeRefs[pos])
+		copyChange.NumBlocks = 0
+		copyChange.Operation = allocationchange.COPY_OPERATION
+		copyChange.Size = 0
+		commitReq.changes = append(commitReq.changes, copyChange)
+		commitReq.connectionID = req.connectionID
+		commitReq.wg = wg
+		commitReqs[c] = commitReq
+		go AddCommitRequest(commitReq)
+		c++
+	}
+	wg.Wait()
+
+	for _, commitReq := range commitReqs {
+		if commitReq.result != nil {
+			if commitReq.result.Success {
+				Logger.Info("Commit success", commitReq.blobber.Baseurl)
+				req.consensus++
+			} else {
+				Logger.Info("Commit failed", commitReq.blobber.Baseurl, commitReq.result.ErrorMessage)
+			}
+		} else {
+			Logger.Info("Commit result not set", commitReq.blobber.Baseurl)
+		}
+	}
+
+	if !req.isConsensusOk() {
+		return fmt.Errorf("Move failed: Commit consensus failed")
+	}
+	return nil
+}
+
+// dropConnection - drops the changes of the move queued on the blobbers
+func (req *MoveRequest) dropConnection() {
+	wg := &sync.WaitGroup{}
+	pos := 0
+	for i := req.moveMask; i != 0; i &= ^(1 << uint32(pos)) {
+		pos = bits.TrailingZeros32(i)
+		wg.Add(1)
+		go func(blobber *blockchain.StorageNode) {
+			defer wg.Done()
+			err := deleteConnection(req.ctx, blobber, req.allocationID, req.connectionID)
+			if err != nil {
+				Logger.Error(blobber.Baseurl, " dropping the failed move failed: ", err)
+			}
+		}(req.blobbers[pos])
+	}
+	wg.Wait()
+}
+
+// cloneObjectTree - a copy of the object tree, for a change that rewrites it
+func cloneObjectTree(ref fileref.RefEntity) fileref.RefEntity {
+	switch r := ref.(type) {
+	case *fileref.FileRef:
+		clone := *r
+		return &clone
+	case *fileref.Ref:
+		clone := *r
+		clone.Children = make([]fileref.RefEntity, len(r.Children))
+		for i, child := range r.Children {
+			clone.Children[i] = cloneObjectTree(child)
+		}
+		return &clone
+	}
+	return ref
+}
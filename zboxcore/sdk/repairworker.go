@@ -0,0 +1,202 @@
+package sdk
+
+import (
+	"math/bits"
+	"sync"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+)
+
+// opStatus - collects the outcome of an operation running the upload flow
+// synchronously, and forwards the progress to the caller as op
+type opStatus struct {
+	status    StatusCallback
+	op        int
+	err       error
+	completed bool
+	mutex     sync.Mutex
+}
+
+func (s *opStatus) Started(allocationId, filePath string, op int, totalBytes int) {
+	if s.status != nil {
+		s.status.Started(allocationId, filePath, s.op, totalBytes)
+	}
+}
+
+func (s *opStatus) InProgress(allocationId, filePath string, op int, completedBytes int) {
+	if s.status != nil {
+		s.status.InProgress(allocationId, filePath, s.op, completedBytes)
+	}
+}
+
+func (s *opStatus) Error(allocationID string, filePath string, op int, err error) {
+	s.mutex.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mutex.Unlock()
+	if s.status != nil {
+		s.status.Error(allocationID, filePath, s.op, err)
+	}
+}
+
+func (s *opStatus) Cancelled(allocationID string, filePath string, op int) {
+	s.mutex.Lock()
+	if s.err == nil {
+		s.err = ErrCancelled
+	}
+	s.mutex.Unlock()
+	reportCancel(s.status, allocationID, filePath, s.op)
+}
+
+func (s *opStatus) Completed(allocationId, filePath string, filename string, mimetype string, size int, op int) {
+	s.mutex.Lock()
+	s.completed = true
+	s.mutex.Unlock()
+	if s.status != nil {
+		s.status.Completed(allocationId, filePath, filename, mimetype, size, s.op)
+	}
+}
+
+// repairFile - uploads the file again to the blobbers out of consensus. The
+// content is read back from the blobbers agreeing on the file and the shards
+// are sent only to the blobbers missing the file, holding another version or
+// holding a shard that doesn't match the content.
+func (a *Allocation) repairFile(remotePath string, status StatusCallback) error {
+	listReq := &ListRequest{}
+	listReq.allocationID = a.ID
+	listReq.blobbers = a.Blobbers
+	listReq.consensusThresh = (float32(a.DataShards) * 100) / float32(a.DataShards+a.ParityShards)
+	listReq.fullconsensus = float32(a.DataShards + a.ParityShards)
+	listReq.ctx = a.ctx
+	listReq.remotefilepath = remotePath
+	foundMask, fileRef, metaResponses := listReq.getFileConsensusFromBlobbers()
+	if fileRef == nil {
+		return common.NewError("consensus_not_met", "No minimum consensus for file meta data of file "+remotePath)
+	}
+	if len(fileRef.EncryptedKey) > 0 {
+		return common.NewError("repair_not_supported", "Repair of encrypted files is not supported")
+	}
+	// The same file may be held with a corrupt or stale shard
+	shards, err := a.getShardHashes(remotePath, fileRef, foundMask)
+	if err != nil {
+		return err
+	}
+	for _, rsp := range metaResponses {
+		if rsp.fileref == nil || (foundMask&(1<<uint32(rsp.blobberIdx))) == 0 {
+			continue
+		}
+		shard := shards[rsp.blobberIdx]
+		if rsp.fileref.ContentHash != shard.ContentHash || rsp.fileref.MerkleRoot != shard.MerkleRoot {
+			Logger.Info("Shard of ", remotePath, " on ", a.Blobbers[rsp.blobberIdx].Baseurl, " doesn't match the content")
+			foundMask &^= (1 << uint32(rsp.blobberIdx))
+		}
+	}
+	repairMask := uint32((1<<uint32(len(a.Blobbers)))-1) &^ foundMask
+	if repairMask == 0 {
+		return nil
+	}
+	// Blobbers with another version of the file get an update
+	updateMask := uint32(0)
+	for _, rsp := range metaResponses {
+		if rsp.fileref != nil && (repairMask&(1<<uint32(rsp.blobberIdx))) != 0 {
+			updateMask |= (1 << uint32(rsp.blobberIdx))
+		}
+	}
+	Logger.Info("Repairing ", remotePath, " on ", bits.OnesCount32(repairMask), " blobbers")
+
+	// The content is read back from the blobbers with a matching shard only
+	reader, err := a.openFileFrom(remotePath, DOWNLOAD_CONTENT_FULL, foundMask)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	repairStatus := &opStatus{status: status, op: OpRepair}
+	uploadReq := a.newUploadRequest(remotePath, fileRef.ActualFileSize, repairStatus, false, false)
+	uploadReq.isRepair = true
+	uploadReq.fileReader = reader
+	uploadReq.filemeta.Hash = fileRef.ActualFileHash
+	uploadReq.filemeta.MimeType = fileRef.MimeType
+	uploadReq.uploadMask = repairMask
+	uploadReq.updateMask = updateMask
+	uploadReq.fullconsensus = float32(bits.OnesCount32(repairMask))
+	// Every blobber being repaired has to take the file
+	uploadReq.consensusThresh = 100 - additionalSuccessRate
+	if fileRef.ActualThumbnailSize > 0 {
+		thumbReader, err := a.openFileFrom(remotePath, DOWNLOAD_CONTENT_THUMB, foundMask)
+		if err != nil {
+			return err
+		}
+		defer thumbReader.Close()
+		uploadReq.thumbnailReader = thumbReader
+		uploadReq.filemeta.ThumbnailSize = fileRef.ActualThumbnailSize
+		uploadReq.filemeta.ThumbnailHash = fileRef.ActualThumbnailHash
+		uploadReq.thumbRemaining = fileRef.ActualThumbnailSize
+	}
+	uploadReq.processUpload(a.ctx, a)
+	if repairStatus.err != nil {
+		return repairStatus.err
+	}
+	if !repairStatus.completed {
+		return common.NewError("repair_failed", "Repair of "+remotePath+" didn't complete")
+	}
+	return nil
+}
+
+// getShardHashes - the content hash and merkle root of the shard of each
+// blobber, from the content read back from the blobbers of the mask and
+// encoded again. Content not matching its hash is read again without each of
+// the blobbers in turn, since one of them may hold a corrupt shard.
+func (a *Allocation) getShardHashes(remotePath string, fileRef *fileref.FileRef, mask uint32) ([]*fileref.FileRef, error) {
+	shards, err := a.hashShards(remotePath, fileRef, mask)
+	if err == nil {
+		return shards, nil
+	}
+	pos := 0
+	for i := mask; i != 0; i &= ^(1 << uint32(pos)) {
+		pos = bits.TrailingZeros32(i)
+		readMask := mask &^ (1 << uint32(pos))
+		if bits.OnesCount32(readMask) < a.DataShards {
+			break
+		}
+		shards, retryErr := a.hashShards(remotePath, fileRef, readMask)
+		if retryErr == nil {
+			Logger.Info("Content of ", remotePath, " read back without ", a.Blobbers[pos].Baseurl)
+			return shards, nil
+		}
+	}
+	return nil, err
+}
+
+// hashShards - the hashes of the shards of the content read back from the
+// blobbers of the mask
+func (a *Allocation) hashShards(remotePath string, fileRef *fileref.FileRef, mask uint32) ([]*fileref.FileRef, error) {
+	reader, err := a.openFileFrom(remotePath, DOWNLOAD_CONTENT_FULL, mask)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	hashStatus := &opStatus{op: OpRepair}
+	hashReq := a.newUploadRequest(remotePath, fileRef.ActualFileSize, hashStatus, false, false)
+	defer hashReq.ctxCncl()
+	hashReq.isRepair = true
+	hashReq.hashOnly = true
+	hashReq.fileReader = reader
+	hashReq.filemeta.Hash = fileRef.ActualFileHash
+	hashReq.filemeta.MimeType = fileRef.MimeType
+	// Every shard is needed to compare
+	hashReq.consensusThresh = 100 - additionalSuccessRate
+	_, ok := hashReq.pushUpload(a.ctx, a)
+	if !ok {
+		if hashStatus.err != nil {
+			return nil, hashStatus.err
+		}
+		return nil, common.NewError("repair_failed", "Hashing the shards of "+remotePath+" failed")
+	}
+	// The upload mask has every blobber, the shards are in their order
+	return hashReq.file, nil
+}
@@ -0,0 +1,43 @@
+package sdk
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/0chain/gosdk/zboxcore/blobbertest"
+	"github.com/0chain/gosdk/zboxcore/client"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+func TestRepairCorruptShard(t *testing.T) {
+	ft := newFaultTest(t, "repair_corrupt_shard")
+	defer ft.Close()
+	corrupt := ft.network.Blobbers[0]
+	shard := corrupt.GetRef("/file.bin").(*fileref.FileRef).ContentHash
+	err := corrupt.CorruptShard("/file.bin", client.Sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The parity blobber answers last, the corrupt data shard is decoded
+	ft.injector.SetFaults(ft.blobberURL(2), blobbertest.Fault{Operations: []string{zboxutil.OperationDownload}, Latency: 50 * time.Millisecond})
+	if _, err = ft.download(t, "corrupt.bin"); err == nil {
+		t.Fatal("content of the corrupt shard passed the hash check")
+	}
+
+	err = ft.a.RepairFile("/file.bin", nil)
+	if err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if hash := corrupt.GetRef("/file.bin").(*fileref.FileRef).ContentHash; hash != shard {
+		t.Errorf("repaired shard hash %s, expected %s", hash, shard)
+	}
+	data, err := ft.download(t, "repaired.bin")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if !bytes.Equal(data, ft.content) {
+		t.Fatal("repaired content differs")
+	}
+}
@@ -3,6 +3,7 @@ package sdk
 import (
 	"encoding/base64"
 	"encoding/json"
+	"net/http"
 	"os"
 	"time"
 
@@ -33,8 +34,28 @@ type StatusCallback interface {
 	Completed(allocationId, filePath string, filename string, mimetype string, size int, op int)
 }
 
+// CancelCallback - implemented by a StatusCallback to be told of a cancel
+// apart from the errors. Otherwise the cancel is reported to Error as
+// ErrCancelled.
+type CancelCallback interface {
+	Cancelled(allocationID string, filePath string, op int)
+}
+
+// reportCancel - reports the cancel of the operation to the status callback
+func reportCancel(status StatusCallback, allocationID string, filePath string, op int) {
+	if status == nil {
+		return
+	}
+	if cb, ok := status.(CancelCallback); ok {
+		cb.Cancelled(allocationID, filePath, op)
+		return
+	}
+	status.Error(allocationID, filePath, op, ErrCancelled)
+}
+
 var numBlockDownloads = 10
 var sdkInitialized = false
+var uploadStateDir = ""
 
 // GetVersion - returns version string
 func GetVersion() string {
@@ -113,6 +134,24 @@ func SetNumBlockDownloads(num int) {
 	return
 }
 
+// SetRetryPolicy - sets the timeouts and retries of the requests to the
+// blobbers, zboxutil.DefaultRetryPolicy() if nil
+func SetRetryPolicy(policy *zboxutil.RetryPolicy) {
+	zboxutil.SetRetryPolicy(policy)
+}
+
+// SetHTTPClient - sets the client the requests to the blobbers and the
+// sharders are sent with, the default client if nil
+func SetHTTPClient(client *http.Client) {
+	zboxutil.SetHTTPClient(client)
+}
+
+// SetUploadStateDir - directory where the progress of uploads is saved, for
+// ResumeUpload. Defaults to a directory under os.TempDir()
+func SetUploadStateDir(dir string) {
+	uploadStateDir = dir
+}
+
 func GetAllocations() ([]*Allocation, error) {
 	return GetAllocationsForClient(client.GetClientID())
 }
@@ -3,19 +3,18 @@ package sdk
 import (
 	"crypto/sha1"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"sort"
 
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/0chain/gosdk/core/common"
 	"github.com/0chain/gosdk/zboxcore/fileref"
 	. "github.com/0chain/gosdk/zboxcore/logger"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
 )
 
 // For sync app
@@ -28,10 +27,16 @@ const (
 	LocalDelete = "LocalDelete"
 )
 
+// SyncIgnoreFile - gitignore style patterns of the paths left out of the
+// sync, read from the local root
+const SyncIgnoreFile = ".zboxignore"
+
 type fileInfo struct {
-	Size int64  `json:"size"`
-	Hash string `json:"hash"`
-	Type string `json:"type"`
+	Size       int64  `json:"size"`
+	Hash       string `json:"hash"`
+	Type       string `json:"type"`
+	ModTime    int64  `json:"mod_time,omitempty"`
+	LookupHash string `json:"lookup_hash,omitempty"`
 }
 
 type FileDiff struct {
@@ -40,7 +45,7 @@ type FileDiff struct {
 	Type string `json:"type"`
 }
 
-func (a *Allocation) getRemoteFilesAndDirs(dirList []string, fMap map[string]fileInfo, exclMap map[string]int) ([]string, error) {
+func (a *Allocation) getRemoteFilesAndDirs(dirList []string, fMap map[string]fileInfo, ignore *zboxutil.IgnoreMatcher) ([]string, error) {
 	childDirList := make([]string, 0)
 	for _, dir := range dirList {
 		ref, err := a.ListDir(dir)
@@ -48,10 +53,15 @@ func (a *Allocation) getRemoteFilesAndDirs(dirList []string, fMap map[string]fil
 			return []string{}, err
 		}
 		for _, child := range ref.Children {
-			if _, ok := exclMap[child.Path]; ok {
+			if ignore.Match(child.Path, child.Type == fileref.DIRECTORY) {
 				continue
 			}
-			fMap[child.Path] = fileInfo{Size: child.Size, Hash: child.Hash, Type: child.Type}
+			info := fileInfo{Size: child.Size, Hash: child.Hash, Type: child.Type, LookupHash: child.LookupHash}
+			if child.Type == fileref.FILE {
+				// Same as the size of the local file
+				info.Size = child.ActualSize
+			}
+			fMap[child.Path] = info
 			if child.Type == fileref.DIRECTORY {
 				childDirList = append(childDirList, child.Path)
 			}
@@ -60,13 +70,13 @@ func (a *Allocation) getRemoteFilesAndDirs(dirList []string, fMap map[string]fil
 	return childDirList, nil
 }
 
-func (a *Allocation) getRemoteFileMap(exclMap map[string]int) (map[string]fileInfo, error) {
+func (a *Allocation) getRemoteFileMap(ignore *zboxutil.IgnoreMatcher) (map[string]fileInfo, error) {
 	// 1. Iteratively get dir and files seperately till no more dirs left
 	remoteList := make(map[string]fileInfo)
 	dirs := []string{"/"}
 	var err error
 	for {
-		dirs, err = a.getRemoteFilesAndDirs(dirs, remoteList, exclMap)
+		dirs, err = a.getRemoteFilesAndDirs(dirs, remoteList, ignore)
 		if err != nil {
 			Logger.Error(err.Error())
 			break
@@ -79,71 +89,112 @@ func (a *Allocation) getRemoteFileMap(exclMap map[string]int) (map[string]fileIn
 	return remoteList, err
 }
 
-func calcFileHash(filePath string) string {
+func calcFileHash(filePath string) (string, error) {
 	fp, err := os.Open(filePath)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	defer fp.Close()
 
 	h := sha1.New()
 	if _, err := io.Copy(h, fp); err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return hex.EncodeToString(h.Sum(nil))
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func getRemoteExcludeMap(exclPath []string) map[string]int {
-	exclMap := make(map[string]int)
-	for idx, path := range exclPath {
-		exclMap[strings.TrimRight(path, "/")] = idx
+// getSyncIgnore - the matcher for the paths left out of the sync on both
+// sides. The exclude paths are relative to the root, the filters match at any
+// depth and the patterns of the ignore file at the local root come last, so
+// they can include again what the others exclude. All of them may use the
+// gitignore pattern syntax.
+func getSyncIgnore(localRootPath string, filters []string, exclPath []string) (*zboxutil.IgnoreMatcher, error) {
+	patterns := make([]string, 0, len(exclPath)+len(filters))
+	for _, p := range exclPath {
+		patterns = append(patterns, "/"+strings.TrimLeft(p, "/"))
 	}
-	return exclMap
+	patterns = append(patterns, filters...)
+	if len(localRootPath) > 0 {
+		lines, err := zboxutil.ReadIgnoreFile(filepath.Join(localRootPath, SyncIgnoreFile))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("can't read %s. %v", SyncIgnoreFile, err)
+		}
+		patterns = append(patterns, lines...)
+	}
+	ignore, err := zboxutil.NewIgnoreMatcher(patterns)
+	if err != nil {
+		return nil, common.NewError("invalid_option", err.Error())
+	}
+	return ignore, nil
 }
 
-func addLocalFileList(root string, fMap map[string]fileInfo, dirList *[]string, filter map[string]bool, exclMap map[string]int) filepath.WalkFunc {
+func addLocalFileList(root string, fMap map[string]fileInfo, dirList *[]string, ignore *zboxutil.IgnoreMatcher, idx *localIndex, scanErrs map[string]error) filepath.WalkFunc {
 	return func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			Logger.Error("Local file list error for path", path, err.Error())
-			return nil
-		}
-		// Filter out
-		if _, ok := filter[info.Name()]; ok {
+			if path == root {
+				return err
+			}
+			if lPath, relErr := filepath.Rel(root, path); relErr == nil {
+				scanErrs["/"+filepath.ToSlash(lPath)] = err
+			}
 			return nil
 		}
 		lPath, err := filepath.Rel(root, path)
 		if err != nil {
 			Logger.Error("getting relative path failed", err)
 		}
-		lPath = "/" + lPath
-		// Exclude
-		if _, ok := exclMap[lPath]; ok {
+		lPath = "/" + filepath.ToSlash(lPath)
+		// Filter out
+		if ignore.Match(lPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		// Add to list
 		if info.IsDir() {
 			*dirList = append(*dirList, lPath)
 		} else {
-			fMap[lPath] = fileInfo{Size: info.Size(), Hash: calcFileHash(path), Type: fileref.FILE}
+			hash, err := idx.hash(lPath, path, info)
+			if err != nil {
+				Logger.Error("Local file hash error for path", path, err.Error())
+				scanErrs[lPath] = err
+				return nil
+			}
+			fMap[lPath] = fileInfo{Size: info.Size(), Hash: hash, Type: fileref.FILE, ModTime: info.ModTime().UnixNano()}
 		}
 		return nil
 	}
 }
 
-func getLocalFileMap(rootPath string, filters []string, exclMap map[string]int) (map[string]fileInfo, error) {
+func getLocalFileMap(rootPath string, ignore *zboxutil.IgnoreMatcher, idx *localIndex) (map[string]fileInfo, map[string]error, error) {
 	localMap := make(map[string]fileInfo)
+	scanErrs := make(map[string]error)
 	var dirList []string
-	filterMap := make(map[string]bool)
-	for _, f := range filters {
-		filterMap[f] = true
-	}
-	err := filepath.Walk(rootPath, addLocalFileList(rootPath, localMap, &dirList, filterMap, exclMap))
+	err := filepath.Walk(rootPath, addLocalFileList(rootPath, localMap, &dirList, ignore, idx, scanErrs))
 	// Add the dirs at the end of the list for dir deletiion after all file deletion
 	for _, d := range dirList {
 		localMap[d] = fileInfo{Type: fileref.DIRECTORY}
 	}
 	Logger.Debug("Local List: ", localMap)
-	return localMap, err
+	return localMap, scanErrs, err
+}
+
+// addLocalPath - adds the local entries at relPath and under it
+func addLocalPath(rootPath string, relPath string, lMap map[string]fileInfo, ignore *zboxutil.IgnoreMatcher, idx *localIndex, scanErrs map[string]error) {
+	absPath := filepath.Join(rootPath, filepath.FromSlash(relPath))
+	if _, err := os.Lstat(absPath); err != nil {
+		if !os.IsNotExist(err) {
+			scanErrs[relPath] = err
+		}
+		return
+	}
+	var dirList []string
+	filepath.Walk(absPath, addLocalFileList(rootPath, lMap, &dirList, ignore, idx, scanErrs))
+	for _, d := range dirList {
+		lMap[d] = fileInfo{Type: fileref.DIRECTORY}
+	}
 }
 
 func isParentFolderExists(lFDiff []FileDiff, path string) bool {
@@ -160,87 +211,66 @@ func isParentFolderExists(lFDiff []FileDiff, path string) bool {
 	return false
 }
 
-func findDelta(rMap map[string]fileInfo, lMap map[string]fileInfo, prevMap map[string]fileInfo, localRootPath string) []FileDiff {
+// findDelta - three way diff of the remote and local maps against the state
+// of the last sync. A path changed on one side only is copied to the other,
+// one changed on both sides to different contents is a conflict.
+func findDelta(rMap map[string]fileInfo, lMap map[string]fileInfo, prevMap map[string]*syncStateEntry) []FileDiff {
 	var lFDiff []FileDiff
-
-	// Create a remote hash map and find modifications
-	rMod := make(map[string]fileInfo)
-	for rFile, rInfo := range rMap {
-		if pm, ok := prevMap[rFile]; ok {
-			// Remote file existed in previous sync also
-			if pm.Hash != rInfo.Hash {
-				// File modified in remote
-				rMod[rFile] = rInfo
-			}
-		}
+	paths := make(map[string]bool)
+	for p := range rMap {
+		paths[p] = true
 	}
-
-	// Create a local hash map and find modification
-	lMod := make(map[string]fileInfo)
-	for lFile, lInfo := range lMap {
-		if pm, ok := prevMap[lFile]; ok {
-			// Local file existed in previous sync also
-			if pm.Hash != lInfo.Hash {
-				// File modified in local
-				lMod[lFile] = lInfo
-			}
-		}
+	for p := range lMap {
+		paths[p] = true
 	}
-
-	// Iterate remote list and get diff
-	rDelMap := make(map[string]string)
-	for rPath, _ := range rMap {
-		op := Download
-		bRemoteModified := false
-		bLocalModified := false
-		if _, ok := rMod[rPath]; ok {
-			bRemoteModified = true
-		}
-		if _, ok := lMod[rPath]; ok {
-			bLocalModified = true
-			delete(lMap, rPath)
-		}
-		if bRemoteModified && bLocalModified {
-			op = Conflict
-		} else if bLocalModified {
-			op = Update
-		} else if _, ok := lMap[rPath]; ok {
-			// No conflicts and file exists locally
-			delete(lMap, rPath)
-			continue
-		} else if _, ok := prevMap[rPath]; ok {
-			op = Delete
-			// Remote allows delete directory skip individual file deletion
-			rDelMap[rPath] = "d"
-			rDir, _ := filepath.Split(rPath)
-			rDir = strings.TrimRight(rDir, "/")
-			if _, ok := rDelMap[rDir]; ok {
-				continue
-			}
-		}
-		lFDiff = append(lFDiff, FileDiff{Path: rPath, Op: op, Type: rMap[rPath].Type})
+	for p := range prevMap {
+		paths[p] = true
 	}
 
-	// Upload all local files
-	for lPath, _ := range lMap {
-		op := Upload
-		if _, ok := lMod[lPath]; ok {
-			op = Update
-		} else if _, ok := prevMap[lPath]; ok {
-			op = LocalDelete
+	for p := range paths {
+		r, rok := rMap[p]
+		l, lok := lMap[p]
+		prev, pok := prevMap[p]
+		if (rok && r.Type == fileref.DIRECTORY) || (lok && l.Type == fileref.DIRECTORY) {
+			// Directories are created along with their files, only the
+			// deletes are synced
+			if pok && rok && !lok {
+				lFDiff = append(lFDiff, FileDiff{Path: p, Op: Delete, Type: fileref.DIRECTORY})
+			} else if pok && lok && !rok {
+				lFDiff = append(lFDiff, FileDiff{Path: p, Op: LocalDelete, Type: fileref.DIRECTORY})
+			}
+			continue
 		}
-		if op != LocalDelete {
-			// Skip if it is a directory
-			lAbsPath := filepath.Join(localRootPath, lPath)
-			fInfo, err := os.Stat(lAbsPath)
-			if err != nil {
+		bRemoteModified := rok && (!pok || prev.RemoteHash != r.Hash)
+		bLocalModified := lok && (!pok || prev.LocalHash != l.Hash)
+		op := ""
+		switch {
+		case rok && lok:
+			if r.Hash == l.Hash {
 				continue
 			}
-			if fInfo.IsDir() {
-				continue
+			if bLocalModified && !bRemoteModified {
+				op = Update
+			} else if bRemoteModified && !bLocalModified {
+				op = Download
+			} else {
+				op = Conflict
+			}
+		case lok:
+			// A file modified after the remote delete is uploaded again
+			op = Upload
+			if pok && !bLocalModified {
+				op = LocalDelete
 			}
+		case rok:
+			op = Download
+			if pok && !bRemoteModified {
+				op = Delete
+			}
+		default:
+			continue
 		}
-		lFDiff = append(lFDiff, FileDiff{Path: lPath, Op: op, Type: lMap[lPath].Type})
+		lFDiff = append(lFDiff, FileDiff{Path: p, Op: op, Type: fileref.FILE})
 	}
 
 	// If there are differences, remove childs if the parent folder is deleted
@@ -250,14 +280,16 @@ func findDelta(rMap map[string]fileInfo, lMap map[string]fileInfo, prevMap map[s
 		var newlFDiff []FileDiff
 		for _, f := range lFDiff {
 			if f.Op == LocalDelete || f.Op == Delete {
+				if f.Type == fileref.DIRECTORY && hasTransferUnder(lFDiff, f.Path) {
+					// Files added under the directory on the other side
+					// keep it
+					continue
+				}
 				if isParentFolderExists(newlFDiff, f.Path) == false {
 					newlFDiff = append(newlFDiff, f)
 				}
 			} else {
-				// Add only files for other Op
-				if f.Type == fileref.FILE {
-					newlFDiff = append(newlFDiff, f)
-				}
+				newlFDiff = append(newlFDiff, f)
 			}
 		}
 		return newlFDiff
@@ -265,85 +297,111 @@ func findDelta(rMap map[string]fileInfo, lMap map[string]fileInfo, prevMap map[s
 	return lFDiff
 }
 
+func hasTransferUnder(lFDiff []FileDiff, dir string) bool {
+	for _, f := range lFDiff {
+		if f.Op != LocalDelete && f.Op != Delete && strings.HasPrefix(f.Path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllocationDiff - diff of the local tree at localRootPath against the
+// allocation, since the sync state at lastSyncCachePath. The state records
+// both sides of every path as they were when last synced, and a remote
+// snapshot saved by the previous versions is migrated to it. The hashes of the
+// local files are kept in an index next to the state, so only the files that
+// changed since the previous call are hashed again. The paths matching the
+// filters, the exclude paths or the patterns of SyncIgnoreFile at the local
+// root are left out on both sides. Fails with a *LocalScanError when local
+// paths can't be read, see GetAllocationDiffWithScanErrors to sync the rest.
 func (a *Allocation) GetAllocationDiff(lastSyncCachePath string, localRootPath string, localFileFilters []string, remoteExcludePath []string) ([]FileDiff, error) {
+	lFdiff, scanErr, err := a.GetAllocationDiffWithScanErrors(lastSyncCachePath, localRootPath, localFileFilters, remoteExcludePath)
+	if err != nil {
+		return lFdiff, err
+	}
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return lFdiff, nil
+}
+
+// GetAllocationDiffWithScanErrors - GetAllocationDiff of the local paths that
+// can be read. The paths that can't are left out of the diff and returned in
+// the *LocalScanError, nil if there are none.
+func (a *Allocation) GetAllocationDiffWithScanErrors(lastSyncCachePath string, localRootPath string, localFileFilters []string, remoteExcludePath []string) ([]FileDiff, *LocalScanError, error) {
 	var lFdiff []FileDiff
-	prevRemoteFileMap := make(map[string]fileInfo)
-	// 1. Validate localSycnCachePath
-	if len(lastSyncCachePath) > 0 {
-		// Validate cache path
-		fileInfo, err := os.Stat(lastSyncCachePath)
-		if err == nil {
-			if fileInfo.IsDir() {
-				return lFdiff, fmt.Errorf("invalid file cache. %v", err)
-			}
-			content, err := ioutil.ReadFile(lastSyncCachePath)
-			if err != nil {
-				return lFdiff, fmt.Errorf("can't read cache file.")
-			}
-			err = json.Unmarshal(content, &prevRemoteFileMap)
-			if err != nil {
-				return lFdiff, fmt.Errorf("invalid cache content.")
-			}
-		}
+	// 1. Load the state of the last sync
+	state, err := loadSyncState(lastSyncCachePath)
+	if err != nil {
+		return lFdiff, nil, err
 	}
 
-	// 2. Build a map for exclude path
-	exclMap := getRemoteExcludeMap(remoteExcludePath)
+	// 2. Build the matcher for the paths left out
+	localRootPath = strings.TrimRight(localRootPath, "/")
+	ignore, err := getSyncIgnore(localRootPath, localFileFilters, remoteExcludePath)
+	if err != nil {
+		return lFdiff, nil, err
+	}
 
 	// 3. Get flat file list from remote
-	remoteFileMap, err := a.getRemoteFileMap(exclMap)
+	remoteFileMap, err := a.getRemoteFileMap(ignore)
 	if err != nil {
-		return lFdiff, fmt.Errorf("error getting list dir from remote. %v", err)
+		return lFdiff, nil, fmt.Errorf("error getting list dir from remote. %v", err)
 	}
 
 	// 4. Get flat file list on the local filesystem
-	localRootPath = strings.TrimRight(localRootPath, "/")
-	localFileList, err := getLocalFileMap(localRootPath, localFileFilters, exclMap)
+	idx := loadLocalIndex(getLocalIndexPath(lastSyncCachePath))
+	localFileList, scanErrs, err := getLocalFileMap(localRootPath, ignore, idx)
 	if err != nil {
-		return lFdiff, fmt.Errorf("error getting list dir from local. %v", err)
+		return lFdiff, nil, fmt.Errorf("error getting list dir from local. %v", err)
 	}
+	err = idx.save(true)
+	if err != nil {
+		Logger.Error("Saving the local index failed: ", err)
+	}
+	excludePaths(remoteFileMap, scanErrs)
+	prevMap := state.getEntries([]string{"/"}, scanErrs)
 
 	// 5. Get the file diff with operation
-	lFdiff = findDelta(remoteFileMap, localFileList, prevRemoteFileMap, localRootPath)
+	lFdiff = findDelta(remoteFileMap, localFileList, prevMap)
 	Logger.Debug("Diff: ", lFdiff)
-	return lFdiff, nil
+	if len(scanErrs) > 0 {
+		return lFdiff, &LocalScanError{Errors: scanErrs}, nil
+	}
+	return lFdiff, nil, nil
 }
 
-// SaveRemoteSnapShot - Saves the remote current information to the given file
-// This file can be passed to GetAllocationDiff to exactly find the previous sync state to current.
+// getLocalIndexPath - the local index is kept next to the remote snapshot
+func getLocalIndexPath(lastSyncCachePath string) string {
+	if len(lastSyncCachePath) == 0 {
+		return ""
+	}
+	return lastSyncCachePath + ".index"
+}
+
+// SaveRemoteSnapShot - Saves the remote tree as the state of the last sync,
+// taking the local tree to be the same. The file can be passed to
+// GetAllocationDiff to find the changes since.
 func (a *Allocation) SaveRemoteSnapshot(pathToSave string, remoteExcludePath []string) error {
-	bIsFileExists := false
 	// Validate path
 	fileInfo, err := os.Stat(pathToSave)
-	if err == nil {
-		if fileInfo.IsDir() {
-			return fmt.Errorf("invalid file path to save. %v", err)
-		}
-		bIsFileExists = true
+	if err == nil && fileInfo.IsDir() {
+		return fmt.Errorf("invalid file path to save. %s is a directory", pathToSave)
 	}
 
 	// Get flat file list from remote
-	exclMap := getRemoteExcludeMap(remoteExcludePath)
-	remoteFileList, err := a.getRemoteFileMap(exclMap)
+	ignore, err := getSyncIgnore("", nil, remoteExcludePath)
 	if err != nil {
-		return fmt.Errorf("error getting list dir from remote. %v", err)
-	}
-
-	// Now we got the list from remote, delete the file if exists
-	if bIsFileExists {
-		err = os.Remove(pathToSave)
-		if err != nil {
-			return fmt.Errorf("error deleting previous cache. %v", err)
-		}
+		return err
 	}
-	by, err := json.Marshal(remoteFileList)
+	remoteFileList, err := a.getRemoteFileMap(ignore)
 	if err != nil {
-		return fmt.Errorf("failed to convert JSON. %v", err)
+		return fmt.Errorf("error getting list dir from remote. %v", err)
 	}
-	err = ioutil.WriteFile(pathToSave, by, 0644)
-	if err != nil {
-		return fmt.Errorf("error saving file. %v", err)
+	state := newSyncState()
+	for p, info := range remoteFileList {
+		state.Entries[p] = newSyncStateEntry(info, info)
 	}
-	// Successfully saved
-	return nil
+	return state.save(pathToSave)
 }
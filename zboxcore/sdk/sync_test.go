@@ -0,0 +1,164 @@
+package sdk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/0chain/gosdk/zboxcore/fileref"
+)
+
+func syncFile(hash string) fileInfo {
+	return fileInfo{Type: fileref.FILE, Hash: hash}
+}
+
+func syncDir() fileInfo {
+	return fileInfo{Type: fileref.DIRECTORY}
+}
+
+// syncEntry - the entry of a path synced with the local and remote hashes
+func syncEntry(typ string, localHash string, remoteHash string) *syncStateEntry {
+	return &syncStateEntry{Type: typ, LocalHash: localHash, RemoteHash: remoteHash}
+}
+
+func TestFindDelta(t *testing.T) {
+	f := fileref.FILE
+	d := fileref.DIRECTORY
+	tests := []struct {
+		name   string
+		remote map[string]fileInfo
+		local  map[string]fileInfo
+		prev   map[string]*syncStateEntry
+		diff   []FileDiff
+	}{
+		{
+			name:   "unchanged",
+			remote: map[string]fileInfo{"/a": syncFile("1")},
+			local:  map[string]fileInfo{"/a": syncFile("1")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+		},
+		{
+			name:  "local add",
+			local: map[string]fileInfo{"/a": syncFile("1")},
+			diff:  []FileDiff{{Op: Upload, Path: "/a", Type: f}},
+		},
+		{
+			name:   "remote add",
+			remote: map[string]fileInfo{"/a": syncFile("1")},
+			diff:   []FileDiff{{Op: Download, Path: "/a", Type: f}},
+		},
+		{
+			name:   "same add on both sides",
+			remote: map[string]fileInfo{"/a": syncFile("1")},
+			local:  map[string]fileInfo{"/a": syncFile("1")},
+		},
+		{
+			name:   "different adds on both sides",
+			remote: map[string]fileInfo{"/a": syncFile("1")},
+			local:  map[string]fileInfo{"/a": syncFile("2")},
+			diff:   []FileDiff{{Op: Conflict, Path: "/a", Type: f}},
+		},
+		{
+			name:   "local change",
+			remote: map[string]fileInfo{"/a": syncFile("1")},
+			local:  map[string]fileInfo{"/a": syncFile("2")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			diff:   []FileDiff{{Op: Update, Path: "/a", Type: f}},
+		},
+		{
+			name:   "remote change",
+			remote: map[string]fileInfo{"/a": syncFile("2")},
+			local:  map[string]fileInfo{"/a": syncFile("1")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			diff:   []FileDiff{{Op: Download, Path: "/a", Type: f}},
+		},
+		{
+			// The local and remote hashes of a synced file may differ, the
+			// remote one being of the shards
+			name:   "local change of a file hashed differently on both sides",
+			remote: map[string]fileInfo{"/a": syncFile("r1")},
+			local:  map[string]fileInfo{"/a": syncFile("l2")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "l1", "r1")},
+			diff:   []FileDiff{{Op: Update, Path: "/a", Type: f}},
+		},
+		{
+			name:   "conflicting changes",
+			remote: map[string]fileInfo{"/a": syncFile("2")},
+			local:  map[string]fileInfo{"/a": syncFile("3")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			diff:   []FileDiff{{Op: Conflict, Path: "/a", Type: f}},
+		},
+		{
+			name:   "same change on both sides",
+			remote: map[string]fileInfo{"/a": syncFile("2")},
+			local:  map[string]fileInfo{"/a": syncFile("2")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+		},
+		{
+			name:   "local delete",
+			remote: map[string]fileInfo{"/a": syncFile("1")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			diff:   []FileDiff{{Op: Delete, Path: "/a", Type: f}},
+		},
+		{
+			name:  "remote delete",
+			local: map[string]fileInfo{"/a": syncFile("1")},
+			prev:  map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			diff:  []FileDiff{{Op: LocalDelete, Path: "/a", Type: f}},
+		},
+		{
+			name:   "local delete and remote add",
+			remote: map[string]fileInfo{"/a": syncFile("1"), "/b": syncFile("2")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			diff:   []FileDiff{{Op: Delete, Path: "/a", Type: f}, {Op: Download, Path: "/b", Type: f}},
+		},
+		{
+			name:   "local delete of a file changed remotely",
+			remote: map[string]fileInfo{"/a": syncFile("2")},
+			prev:   map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			diff:   []FileDiff{{Op: Download, Path: "/a", Type: f}},
+		},
+		{
+			name:  "remote delete of a file changed locally",
+			local: map[string]fileInfo{"/a": syncFile("2")},
+			prev:  map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			diff:  []FileDiff{{Op: Upload, Path: "/a", Type: f}},
+		},
+		{
+			name:  "deleted on both sides",
+			prev:  map[string]*syncStateEntry{"/a": syncEntry(f, "1", "1")},
+			local: map[string]fileInfo{},
+		},
+		{
+			name:   "local dir delete",
+			remote: map[string]fileInfo{"/d": syncDir(), "/d/x": syncFile("1")},
+			prev:   map[string]*syncStateEntry{"/d": syncEntry(d, "", ""), "/d/x": syncEntry(f, "1", "1")},
+			diff:   []FileDiff{{Op: Delete, Path: "/d", Type: d}},
+		},
+		{
+			name:   "local dir delete with a remote add under it",
+			remote: map[string]fileInfo{"/d": syncDir(), "/d/new": syncFile("2"), "/d/x": syncFile("1")},
+			prev:   map[string]*syncStateEntry{"/d": syncEntry(d, "", ""), "/d/x": syncEntry(f, "1", "1")},
+			diff:   []FileDiff{{Op: Download, Path: "/d/new", Type: f}, {Op: Delete, Path: "/d/x", Type: f}},
+		},
+		{
+			name:  "remote dir delete with a local add under it",
+			local: map[string]fileInfo{"/d": syncDir(), "/d/new": syncFile("2"), "/d/x": syncFile("1")},
+			prev:  map[string]*syncStateEntry{"/d": syncEntry(d, "", ""), "/d/x": syncEntry(f, "1", "1")},
+			diff:  []FileDiff{{Op: Upload, Path: "/d/new", Type: f}, {Op: LocalDelete, Path: "/d/x", Type: f}},
+		},
+		{
+			name:   "new dirs",
+			remote: map[string]fileInfo{"/r": syncDir()},
+			local:  map[string]fileInfo{"/l": syncDir()},
+		},
+	}
+	for _, tt := range tests {
+		diff := findDelta(tt.remote, tt.local, tt.prev)
+		if len(diff) == 0 && len(tt.diff) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(diff, tt.diff) {
+			t.Errorf("%s: %v, expected %v", tt.name, diff, tt.diff)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0chain/gosdk/core/common"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+)
+
+// Directions ApplyDiff applies the diff in
+const (
+	SyncBidirectional = "bidirectional"
+	// Only the operations changing the allocation
+	SyncPushOnly = "push"
+	// Only the operations changing the local tree
+	SyncPullOnly = "pull"
+)
+
+// Policies resolving the paths changed on both sides since the last sync
+const (
+	// Leave the conflict to the caller
+	ConflictSkip = "skip"
+	// Keep the local version under a new name, next to the remote version
+	ConflictKeepBoth   = "keep_both"
+	ConflictLocalWins  = "local_wins"
+	ConflictRemoteWins = "remote_wins"
+	// The version modified last wins
+	ConflictNewestWins = "newest_wins"
+)
+
+const defaultConflictSuffix = ".conflict"
+
+// SyncConflict - both versions of a conflicting path
+type SyncConflict struct {
+	Path         string
+	LocalPath    string
+	LocalSize    int64
+	LocalModTime time.Time
+	RemoteSize   int64
+	RemoteHash   string
+	// Zero when the blobbers don't report it
+	RemoteModTime time.Time
+}
+
+// ConflictResolver - decides the policy resolving a conflict
+type ConflictResolver func(conflict *SyncConflict) string
+
+type ApplyDiffOptions struct {
+	// SyncBidirectional, SyncPushOnly or SyncPullOnly, SyncBidirectional if
+	// not set
+	Mode string
+	// Sync state given to GetAllocationDiff, updated with the paths synced.
+	// Not updated when empty.
+	SnapshotPath string
+	// The filters and exclude paths given to GetAllocationDiff, left out of
+	// the state along with the patterns of SyncIgnoreFile
+	LocalFileFilters  []string
+	RemoteExcludePath []string
+	// Policy for the conflicts, ConflictSkip if not set
+	ConflictPolicy string
+	// Decides the policy per conflict instead of ConflictPolicy when set
+	ConflictResolver ConflictResolver
+	// Inserted before the extension of the local version kept by
+	// ConflictKeepBoth, ".conflict" if not set
+	ConflictSuffix string
+}
+
+type SyncResult struct {
+	FileDiff
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Policy applied to a conflict
+	Resolution string `json:"resolution,omitempty"`
+	// Path the local version was kept under by ConflictKeepBoth
+	ConflictCopy string `json:"conflict_copy,omitempty"`
+}
+
+type SyncReport struct {
+	Completed       int           `json:"completed"`
+	Skipped         int           `json:"skipped"`
+	Failed          int           `json:"failed"`
+	SnapshotUpdated bool          `json:"snapshot_updated"`
+	Results         []*SyncResult `json:"results"`
+}
+
+func (r *SyncReport) add(result *SyncResult) {
+	r.Results = append(r.Results, result)
+	switch result.Status {
+	case TransferCompleted:
+		r.Completed++
+	case TransferSkipped:
+		r.Skipped++
+	case TransferFailed:
+		r.Failed++
+	}
+}
+
+// isPush - whether the operation changes the allocation
+func isPush(op string) bool {
+	return op == Upload || op == Update || op == Delete
+}
+
+// ApplyDiff - applies the operations of GetAllocationDiff, with localRoot as
+// the local root path given to it. The transfers run first and the deletes
+// after them. Conflicts are resolved by the conflict policy of the options,
+// and the ones left, as well as the operations outside the mode, are
+// reported as skipped. The sync state records the paths left the same on
+// both sides, the ones skipped or failed are found again by the next diff.
+func (a *Allocation) ApplyDiff(diffs []FileDiff, localRoot string, opts *ApplyDiffOptions) (*SyncReport, error) {
+	return a.ApplyDiffCtx(a.ctx, diffs, localRoot, opts)
+}
+
+// ApplyDiffCtx - ApplyDiff till ctx is done. Canceling ctx aborts the
+// transfer in progress, and the operations not done yet fail with the error
+// of ctx.
+func (a *Allocation) ApplyDiffCtx(ctx context.Context, diffs []FileDiff, localRoot string, opts *ApplyDiffOptions) (*SyncReport, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	if opts == nil {
+		opts = &ApplyDiffOptions{}
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = SyncBidirectional
+	}
+	if mode != SyncBidirectional && mode != SyncPushOnly && mode != SyncPullOnly {
+		return nil, common.NewError("invalid_option", "Unknown sync mode "+mode)
+	}
+	localRoot = filepath.Clean(localRoot)
+	ignore, err := getSyncIgnore(localRoot, opts.LocalFileFilters, opts.RemoteExcludePath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SyncReport{Results: make([]*SyncResult, 0, len(diffs))}
+	transfers := make([]*SyncResult, 0)
+	deletes := make([]*SyncResult, 0)
+	for _, diff := range diffs {
+		result := &SyncResult{FileDiff: diff}
+		switch diff.Op {
+		case Upload, Update, Download, Conflict:
+			transfers = append(transfers, result)
+		case Delete, LocalDelete:
+			deletes = append(deletes, result)
+		default:
+			result.Status = TransferSkipped
+			report.add(result)
+			continue
+		}
+		if diff.Op == Conflict {
+			// The direction depends on the resolution
+			continue
+		}
+		if (mode == SyncPushOnly && !isPush(diff.Op)) || (mode == SyncPullOnly && isPush(diff.Op)) {
+			result.Status = TransferSkipped
+		}
+	}
+
+	for _, result := range append(transfers, deletes...) {
+		if result.Status != TransferSkipped {
+			err := ctx.Err()
+			if err == nil && result.Op == Conflict {
+				err = a.resolveConflict(ctx, result, localRoot, mode, opts)
+			} else if err == nil {
+				err = a.applyFileDiff(ctx, result.FileDiff, localRoot)
+			}
+			if err != nil {
+				Logger.Error("Sync ", result.Op, " of ", result.Path, " failed: ", err)
+				result.Status = TransferFailed
+				result.Error = err.Error()
+			} else if result.Status != TransferSkipped {
+				result.Status = TransferCompleted
+			}
+		}
+		report.add(result)
+	}
+
+	if len(opts.SnapshotPath) > 0 {
+		err := a.updateSyncState(opts.SnapshotPath, localRoot, ignore, []string{"/"})
+		if err != nil {
+			return report, err
+		}
+		report.SnapshotUpdated = true
+	}
+	if report.Failed > 0 {
+		return report, fmt.Errorf("%d of %d sync operations failed", report.Failed, len(diffs))
+	}
+	return report, nil
+}
+
+func (a *Allocation) applyFileDiff(ctx context.Context, diff FileDiff, localRoot string) error {
+	localPath := filepath.Join(localRoot, filepath.FromSlash(diff.Path))
+	file := &DirTransferResult{LocalPath: localPath, RemotePath: diff.Path}
+	switch diff.Op {
+	case Upload:
+		return a.uploadDirFile(ctx, file, false, false, nil)
+	case Update:
+		return a.uploadDirFile(ctx, file, true, false, nil)
+	case Download:
+		err := os.MkdirAll(filepath.Dir(localPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		return a.downloadDirFile(ctx, file, nil, "", nil)
+	case Delete:
+		return a.DeleteFileCtx(ctx, diff.Path)
+	case LocalDelete:
+		return os.RemoveAll(localPath)
+	}
+	return common.NewError("invalid_operation", "Unknown sync operation "+diff.Op)
+}
+
+// getSyncConflict - the local and remote versions of the conflicting path
+func (a *Allocation) getSyncConflict(ctx context.Context, remotePath string, localPath string) (*SyncConflict, error) {
+	conflict := &SyncConflict{Path: remotePath, LocalPath: localPath}
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("Local file error: %s", err.Error())
+	}
+	conflict.LocalSize = stat.Size()
+	conflict.LocalModTime = stat.ModTime()
+	dir, _ := path.Split(remotePath)
+	ref, err := a.ListDirCtx(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range ref.Children {
+		if child.Path != remotePath {
+			continue
+		}
+		conflict.RemoteSize = child.ActualSize
+		conflict.RemoteHash = child.Hash
+		if len(child.UpdatedAt) > 0 {
+			conflict.RemoteModTime, err = time.Parse(time.RFC3339Nano, child.UpdatedAt)
+			if err != nil {
+				Logger.Error("Invalid modification time of ", remotePath, ": ", child.UpdatedAt)
+			}
+		}
+		return conflict, nil
+	}
+	return nil, common.NewError("remote_path_not_found", "No consensus on the remote file "+remotePath)
+}
+
+// getConflictCopyPath - a free path for the local version kept by
+// ConflictKeepBoth, with the suffix before the extension
+func getConflictCopyPath(localPath string, suffix string) string {
+	ext := filepath.Ext(localPath)
+	base := strings.TrimSuffix(localPath, ext)
+	copyPath := base + suffix + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(copyPath); os.IsNotExist(err) {
+			return copyPath
+		}
+		copyPath = base + suffix + "-" + strconv.Itoa(i) + ext
+	}
+}
+
+// resolveConflict - applies the conflict policy to the path. Resolutions
+// needing a direction outside the mode are skipped.
+func (a *Allocation) resolveConflict(ctx context.Context, result *SyncResult, localRoot string, mode string, opts *ApplyDiffOptions) error {
+	localPath := filepath.Join(localRoot, filepath.FromSlash(result.Path))
+	policy := opts.ConflictPolicy
+	if policy == "" {
+		policy = ConflictSkip
+	}
+	if opts.ConflictResolver != nil || policy == ConflictNewestWins {
+		conflict, err := a.getSyncConflict(ctx, result.Path, localPath)
+		if err != nil {
+			return err
+		}
+		if opts.ConflictResolver != nil {
+			policy = opts.ConflictResolver(conflict)
+		}
+		if policy == ConflictNewestWins {
+			if conflict.RemoteModTime.IsZero() {
+				return common.NewError("conflict_unresolved", "Modification time of the remote file is unknown")
+			}
+			policy = ConflictRemoteWins
+			if !conflict.LocalModTime.Before(conflict.RemoteModTime) {
+				policy = ConflictLocalWins
+			}
+		}
+	}
+	result.Resolution = policy
+
+	var push, pull bool
+	switch policy {
+	case ConflictSkip:
+		result.Status = TransferSkipped
+		return nil
+	case ConflictLocalWins:
+		push = true
+	case ConflictRemoteWins:
+		pull = true
+	case ConflictKeepBoth:
+		push, pull = true, true
+	default:
+		return common.NewError("invalid_option", "Unknown conflict policy "+policy)
+	}
+	if (push && mode == SyncPullOnly) || (pull && mode == SyncPushOnly) {
+		result.Status = TransferSkipped
+		return nil
+	}
+
+	file := &DirTransferResult{LocalPath: localPath, RemotePath: result.Path}
+	switch policy {
+	case ConflictLocalWins:
+		return a.uploadDirFile(ctx, file, true, false, nil)
+	case ConflictRemoteWins:
+		return a.downloadDirFile(ctx, file, nil, "", nil)
+	}
+	suffix := opts.ConflictSuffix
+	if suffix == "" {
+		suffix = defaultConflictSuffix
+	}
+	copyPath := getConflictCopyPath(localPath, suffix)
+	err := os.Rename(localPath, copyPath)
+	if err != nil {
+		return err
+	}
+	result.ConflictCopy = copyPath
+	rel, err := filepath.Rel(localRoot, copyPath)
+	if err != nil {
+		return err
+	}
+	copyFile := &DirTransferResult{LocalPath: copyPath, RemotePath: "/" + filepath.ToSlash(rel)}
+	err = a.uploadDirFile(ctx, copyFile, false, false, nil)
+	if err != nil {
+		return err
+	}
+	return a.downloadDirFile(ctx, file, nil, "", nil)
+}
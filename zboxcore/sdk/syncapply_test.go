@@ -0,0 +1,292 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// syncTest - a fault test with /file.bin synced to the local root
+type syncTest struct {
+	*faultTest
+	root      string
+	statePath string
+}
+
+func newSyncTest(t *testing.T, allocationID string) *syncTest {
+	st := &syncTest{faultTest: newFaultTest(t, allocationID)}
+	st.root = filepath.Join(st.dir, "root")
+	st.statePath = filepath.Join(st.dir, "state")
+	err := os.Mkdir(st.root, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.a.DownloadFileCtx(context.Background(), filepath.Join(st.root, "file.bin"), "/file.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.a.SaveRemoteSnapshot(st.statePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func (st *syncTest) diff(t *testing.T) []FileDiff {
+	diff, err := st.a.GetAllocationDiff(st.statePath, st.root, nil, nil)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	return diff
+}
+
+// apply - applies the diff of the local root and the allocation
+func (st *syncTest) apply(t *testing.T, opts *ApplyDiffOptions) *SyncReport {
+	opts.SnapshotPath = st.statePath
+	report, err := st.a.ApplyDiff(st.diff(t), st.root, opts)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	return report
+}
+
+// writeLocal - writes content to the local root
+func (st *syncTest) writeLocal(t *testing.T, remotePath string, content []byte) {
+	err := ioutil.WriteFile(filepath.Join(st.root, filepath.FromSlash(remotePath)), content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (st *syncTest) readLocal(t *testing.T, remotePath string) []byte {
+	content, err := ioutil.ReadFile(filepath.Join(st.root, filepath.FromSlash(remotePath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// writeRemote - uploads content from outside the local root
+func (st *syncTest) writeRemote(t *testing.T, remotePath string, content []byte, update bool) {
+	localPath := filepath.Join(st.dir, "remote.tmp")
+	err := ioutil.WriteFile(localPath, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update {
+		_, err = st.a.UpdateFileCtx(context.Background(), localPath, remotePath, nil)
+	} else {
+		_, err = st.a.UploadFileCtx(context.Background(), localPath, remotePath, nil)
+	}
+	if err != nil {
+		t.Fatalf("upload of %s: %v", remotePath, err)
+	}
+}
+
+func (st *syncTest) readRemote(t *testing.T, remotePath string) []byte {
+	content, err := st.downloadFrom(t, remotePath, "remote.read")
+	if err != nil {
+		t.Fatalf("download of %s: %v", remotePath, err)
+	}
+	os.Remove(filepath.Join(st.dir, "remote.read"))
+	return content
+}
+
+func randomContent(size int) []byte {
+	content := make([]byte, size)
+	rand.Read(content)
+	return content
+}
+
+// operations - the operations of the results in the order they ran
+func operations(report *SyncReport) []string {
+	if report == nil {
+		return nil
+	}
+	ops := make([]string, 0, len(report.Results))
+	for _, result := range report.Results {
+		ops = append(ops, result.Op+" "+result.Path+" "+result.Status)
+	}
+	return ops
+}
+
+func TestApplyDiffOrder(t *testing.T) {
+	st := newSyncTest(t, "apply_diff_order")
+	defer st.Close()
+	// The local file moved, and a file added on the remote
+	content := st.readLocal(t, "/file.bin")
+	err := os.Rename(filepath.Join(st.root, "file.bin"), filepath.Join(st.root, "moved.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := randomContent(1000)
+	st.writeRemote(t, "/added.bin", added, false)
+
+	report := st.apply(t, &ApplyDiffOptions{})
+	expected := []string{
+		Download + " /added.bin " + TransferCompleted,
+		Upload + " /moved.bin " + TransferCompleted,
+		Delete + " /file.bin " + TransferCompleted,
+	}
+	if ops := operations(report); !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("%v, expected %v", ops, expected)
+	}
+	if !report.SnapshotUpdated || report.Completed != 3 {
+		t.Errorf("report %+v", report)
+	}
+	if !bytes.Equal(st.readRemote(t, "/moved.bin"), content) || !bytes.Equal(st.readLocal(t, "/added.bin"), added) {
+		t.Error("content differs after the sync")
+	}
+	if _, err := st.downloadFrom(t, "/file.bin", "deleted.bin"); err == nil {
+		t.Error("the local delete is not synced")
+	}
+	if diff := st.diff(t); len(diff) > 0 {
+		t.Errorf("diff after the sync: %v", diff)
+	}
+}
+
+func TestApplyDiffModes(t *testing.T) {
+	st := newSyncTest(t, "apply_diff_modes")
+	defer st.Close()
+	local := randomContent(1000)
+	st.writeLocal(t, "/local.bin", local)
+	remote := randomContent(1000)
+	st.writeRemote(t, "/remote.bin", remote, false)
+
+	report := st.apply(t, &ApplyDiffOptions{Mode: SyncPushOnly})
+	expected := []string{
+		Upload + " /local.bin " + TransferCompleted,
+		Download + " /remote.bin " + TransferSkipped,
+	}
+	if ops := operations(report); !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("push only: %v, expected %v", ops, expected)
+	}
+	if _, err := os.Stat(filepath.Join(st.root, "remote.bin")); !os.IsNotExist(err) {
+		t.Errorf("push only changed the local tree: %v", err)
+	}
+
+	// The skipped download is found again, and nothing else
+	st.writeLocal(t, "/later.bin", randomContent(100))
+	report = st.apply(t, &ApplyDiffOptions{Mode: SyncPullOnly})
+	expected = []string{
+		Upload + " /later.bin " + TransferSkipped,
+		Download + " /remote.bin " + TransferCompleted,
+	}
+	if ops := operations(report); !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("pull only: %v, expected %v", ops, expected)
+	}
+	if !bytes.Equal(st.readRemote(t, "/local.bin"), local) || !bytes.Equal(st.readLocal(t, "/remote.bin"), remote) {
+		t.Error("content differs after the sync")
+	}
+
+	diff := st.diff(t)
+	expectedDiff := []FileDiff{{Op: Upload, Path: "/later.bin", Type: "f"}}
+	if !reflect.DeepEqual(diff, expectedDiff) {
+		t.Errorf("diff after the sync: %v, expected %v", diff, expectedDiff)
+	}
+
+	if _, err := st.a.ApplyDiff(diff, st.root, &ApplyDiffOptions{Mode: "both"}); err == nil {
+		t.Error("unknown mode")
+	}
+}
+
+func TestApplyDiffConflicts(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	var resolved *SyncConflict
+	tests := []struct {
+		name     string
+		opts     ApplyDiffOptions
+		modTime  time.Time
+		status   string
+		policy   string
+		winner   string
+		keepBoth bool
+	}{
+		{name: "default", status: TransferSkipped, policy: ConflictSkip},
+		{name: "local wins", opts: ApplyDiffOptions{ConflictPolicy: ConflictLocalWins}, status: TransferCompleted, policy: ConflictLocalWins, winner: "local"},
+		{name: "remote wins", opts: ApplyDiffOptions{ConflictPolicy: ConflictRemoteWins}, status: TransferCompleted, policy: ConflictRemoteWins, winner: "remote"},
+		{name: "keep both", opts: ApplyDiffOptions{ConflictPolicy: ConflictKeepBoth}, status: TransferCompleted, policy: ConflictKeepBoth, winner: "remote", keepBoth: true},
+		{name: "newest wins, local older", opts: ApplyDiffOptions{ConflictPolicy: ConflictNewestWins}, modTime: past, status: TransferCompleted, policy: ConflictRemoteWins, winner: "remote"},
+		{name: "newest wins, local newer", opts: ApplyDiffOptions{ConflictPolicy: ConflictNewestWins}, modTime: future, status: TransferCompleted, policy: ConflictLocalWins, winner: "local"},
+		{name: "local wins in pull only", opts: ApplyDiffOptions{ConflictPolicy: ConflictLocalWins, Mode: SyncPullOnly}, status: TransferSkipped, policy: ConflictLocalWins},
+		{name: "keep both in push only", opts: ApplyDiffOptions{ConflictPolicy: ConflictKeepBoth, Mode: SyncPushOnly}, status: TransferSkipped, policy: ConflictKeepBoth},
+		{
+			name: "resolver",
+			opts: ApplyDiffOptions{ConflictPolicy: ConflictRemoteWins, ConflictResolver: func(conflict *SyncConflict) string {
+				resolved = conflict
+				return ConflictLocalWins
+			}},
+			status: TransferCompleted, policy: ConflictLocalWins, winner: "local",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newSyncTest(t, "apply_diff_conflicts")
+			defer st.Close()
+			local := randomContent(1000)
+			remote := randomContent(2000)
+			st.writeLocal(t, "/file.bin", local)
+			if !tt.modTime.IsZero() {
+				os.Chtimes(filepath.Join(st.root, "file.bin"), tt.modTime, tt.modTime)
+			}
+			st.writeRemote(t, "/file.bin", remote, true)
+
+			resolved = nil
+			report := st.apply(t, &tt.opts)
+			if len(report.Results) != 1 {
+				t.Fatalf("results %v", operations(report))
+			}
+			result := report.Results[0]
+			if result.Op != Conflict || result.Status != tt.status || result.Resolution != tt.policy {
+				t.Fatalf("result %+v", result)
+			}
+			if tt.opts.ConflictResolver != nil && (resolved == nil || resolved.LocalSize != 1000 || resolved.RemoteSize != 2000 || resolved.RemoteModTime.IsZero()) {
+				t.Errorf("conflict given to the resolver %+v", resolved)
+			}
+
+			localAfter := st.readLocal(t, "/file.bin")
+			remoteAfter := st.readRemote(t, "/file.bin")
+			switch tt.winner {
+			case "local":
+				if !bytes.Equal(localAfter, local) || !bytes.Equal(remoteAfter, local) {
+					t.Error("the local version did not win")
+				}
+			case "remote":
+				if !bytes.Equal(localAfter, remote) || !bytes.Equal(remoteAfter, remote) {
+					t.Error("the remote version did not win")
+				}
+			default:
+				if !bytes.Equal(localAfter, local) || !bytes.Equal(remoteAfter, remote) {
+					t.Error("a skipped conflict changed a version")
+				}
+			}
+
+			if tt.keepBoth {
+				copyPath := filepath.Join(st.root, "file"+defaultConflictSuffix+".bin")
+				if result.ConflictCopy != copyPath {
+					t.Errorf("conflict copy %s, expected %s", result.ConflictCopy, copyPath)
+				}
+				if !bytes.Equal(st.readLocal(t, "/file.conflict.bin"), local) || !bytes.Equal(st.readRemote(t, "/file.conflict.bin"), local) {
+					t.Error("the local version is not kept on both sides")
+				}
+			}
+
+			diff := st.diff(t)
+			if tt.winner == "" {
+				expected := []FileDiff{{Op: Conflict, Path: "/file.bin", Type: "f"}}
+				if !reflect.DeepEqual(diff, expected) {
+					t.Errorf("diff after the skip %v", diff)
+				}
+			} else if len(diff) > 0 {
+				t.Errorf("diff after the resolution %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+const syncStateVersion = 1
+
+// syncStateEntry - a path as it was on both sides when it was last synced
+type syncStateEntry struct {
+	Type         string `json:"type"`
+	LocalHash    string `json:"local_hash,omitempty"`
+	LocalSize    int64  `json:"local_size"`
+	LocalModTime int64  `json:"local_mod_time,omitempty"`
+	RemoteHash   string `json:"remote_hash,omitempty"`
+	RemoteSize   int64  `json:"remote_size"`
+	LookupHash   string `json:"lookup_hash,omitempty"`
+}
+
+// syncState - the last synced state of the paths, kept at the sync cache path.
+// The diff compares each side against it, so a change is told apart from the
+// same change made on the other side, and a delete from an add.
+type syncState struct {
+	Version int                        `json:"version"`
+	Entries map[string]*syncStateEntry `json:"entries"`
+}
+
+func newSyncState() *syncState {
+	return &syncState{Version: syncStateVersion, Entries: make(map[string]*syncStateEntry)}
+}
+
+func newSyncStateEntry(local fileInfo, remote fileInfo) *syncStateEntry {
+	return &syncStateEntry{
+		Type:         remote.Type,
+		LocalHash:    local.Hash,
+		LocalSize:    local.Size,
+		LocalModTime: local.ModTime,
+		RemoteHash:   remote.Hash,
+		RemoteSize:   remote.Size,
+		LookupHash:   remote.LookupHash,
+	}
+}
+
+// loadSyncState - loads the state saved at statePath, empty when there is
+// none. A remote snapshot saved by the previous versions is migrated, taking
+// the local tree to be the same as the remote one at the time.
+func loadSyncState(statePath string) (*syncState, error) {
+	state := newSyncState()
+	if len(statePath) == 0 {
+		return state, nil
+	}
+	stat, err := os.Stat(statePath)
+	if err != nil {
+		return state, nil
+	}
+	if stat.IsDir() {
+		return state, fmt.Errorf("invalid file cache. %s is a directory", statePath)
+	}
+	content, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		return state, fmt.Errorf("can't read cache file. %v", err)
+	}
+	saved := &syncState{}
+	err = json.Unmarshal(content, saved)
+	if err != nil {
+		return state, fmt.Errorf("invalid cache content. %v", err)
+	}
+	if saved.Version > syncStateVersion {
+		return state, fmt.Errorf("unsupported cache version %d", saved.Version)
+	}
+	if saved.Version > 0 {
+		if saved.Entries != nil {
+			state.Entries = saved.Entries
+		}
+		return state, nil
+	}
+	snapshot := make(map[string]fileInfo)
+	err = json.Unmarshal(content, &snapshot)
+	if err != nil {
+		return state, fmt.Errorf("invalid cache content. %v", err)
+	}
+	for p, info := range snapshot {
+		state.Entries[p] = newSyncStateEntry(info, info)
+	}
+	Logger.Info("Migrated the remote snapshot ", statePath, " to the sync state")
+	return state, nil
+}
+
+func (s *syncState) save(statePath string) error {
+	by, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to convert JSON. %v", err)
+	}
+	tmpPath := statePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, by, 0644)
+	if err != nil {
+		return fmt.Errorf("error saving file. %v", err)
+	}
+	return os.Rename(tmpPath, statePath)
+}
+
+// getEntries - the entries at the paths of the scope and under them, without
+// the excluded paths
+func (s *syncState) getEntries(scope []string, excluded map[string]error) map[string]*syncStateEntry {
+	entries := make(map[string]*syncStateEntry)
+	for p, e := range s.Entries {
+		for _, dir := range scope {
+			if isSameOrUnder(p, dir) {
+				entries[p] = e
+				break
+			}
+		}
+	}
+	for p := range entries {
+		if isExcluded(p, excluded) {
+			delete(entries, p)
+		}
+	}
+	return entries
+}
+
+// update - records the paths that are the same on both sides and drops the
+// ones gone from both. The others keep their entry, so that an operation
+// skipped or failed is found again by the next diff, and so do the excluded
+// paths.
+func (s *syncState) update(scope []string, rMap map[string]fileInfo, lMap map[string]fileInfo, excluded map[string]error) {
+	for p := range s.Entries {
+		_, rok := rMap[p]
+		_, lok := lMap[p]
+		if rok || lok || isExcluded(p, excluded) {
+			continue
+		}
+		for _, dir := range scope {
+			if isSameOrUnder(p, dir) {
+				delete(s.Entries, p)
+				break
+			}
+		}
+	}
+	for p, r := range rMap {
+		l, ok := lMap[p]
+		if !ok || l.Type != r.Type {
+			continue
+		}
+		if r.Type == fileref.FILE && l.Hash != r.Hash {
+			continue
+		}
+		s.Entries[p] = newSyncStateEntry(l, r)
+	}
+}
+
+// updateSyncState - records the state of the paths of the scope after a sync
+func (a *Allocation) updateSyncState(statePath string, localRootPath string, ignore *zboxutil.IgnoreMatcher, scope []string) error {
+	state, err := loadSyncState(statePath)
+	if err != nil {
+		return err
+	}
+	idx := loadLocalIndex(getLocalIndexPath(statePath))
+	rMap := make(map[string]fileInfo)
+	lMap := make(map[string]fileInfo)
+	scanErrs := make(map[string]error)
+	for _, p := range scope {
+		remote, err := a.getRemotePathMap(p, ignore)
+		if err != nil {
+			return err
+		}
+		for k, v := range remote {
+			rMap[k] = v
+		}
+		addLocalPath(localRootPath, p, lMap, ignore, idx, scanErrs)
+	}
+	err = idx.save(false)
+	if err != nil {
+		Logger.Error("Saving the local index failed: ", err)
+	}
+	state.update(scope, rMap, lMap, scanErrs)
+	return state.save(statePath)
+}
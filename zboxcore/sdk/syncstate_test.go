@@ -0,0 +1,163 @@
+package sdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/0chain/gosdk/zboxcore/fileref"
+)
+
+func TestLoadSyncState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name    string
+		content string
+		entries map[string]*syncStateEntry
+		err     bool
+	}{
+		{
+			name:    "current version",
+			content: `{"version":1,"entries":{"/a":{"type":"f","local_hash":"l","local_size":3,"remote_hash":"r","remote_size":3}}}`,
+			entries: map[string]*syncStateEntry{"/a": {Type: fileref.FILE, LocalHash: "l", LocalSize: 3, RemoteHash: "r", RemoteSize: 3}},
+		},
+		{
+			name:    "current version without entries",
+			content: `{"version":1}`,
+			entries: map[string]*syncStateEntry{},
+		},
+		{
+			name:    "remote snapshot",
+			content: `{"/d":{"size":0,"hash":"dh","type":"d"},"/d/a":{"size":3,"hash":"h","type":"f","lookup_hash":"lh"}}`,
+			entries: map[string]*syncStateEntry{
+				"/d":   {Type: fileref.DIRECTORY, LocalHash: "dh", RemoteHash: "dh"},
+				"/d/a": {Type: fileref.FILE, LocalHash: "h", LocalSize: 3, RemoteHash: "h", RemoteSize: 3, LookupHash: "lh"},
+			},
+		},
+		{
+			name:    "empty remote snapshot",
+			content: `{}`,
+			entries: map[string]*syncStateEntry{},
+		},
+		{
+			name:    "newer version",
+			content: `{"version":2,"entries":{}}`,
+			err:     true,
+		},
+		{
+			name:    "invalid",
+			content: `{"version":`,
+			err:     true,
+		},
+	}
+	for _, tt := range tests {
+		statePath := filepath.Join(dir, "state")
+		err := ioutil.WriteFile(statePath, []byte(tt.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := loadSyncState(statePath)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(state.Entries, tt.entries) {
+			t.Errorf("%s: %v, expected %v", tt.name, state.Entries, tt.entries)
+		}
+		if err == nil && state.Version != syncStateVersion {
+			t.Errorf("%s: version %d", tt.name, state.Version)
+		}
+	}
+
+	for _, statePath := range []string{"", filepath.Join(dir, "missing")} {
+		state, err := loadSyncState(statePath)
+		if err != nil || len(state.Entries) != 0 {
+			t.Errorf("no state at %q: %v %v", statePath, state.Entries, err)
+		}
+	}
+	if _, err := loadSyncState(dir); err == nil {
+		t.Error("state at a directory")
+	}
+}
+
+// A file changed locally since the remote snapshot was saved is uploaded,
+// the others left alone
+func TestLoadSyncStateSnapshotDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state")
+	content := `{"/a":{"size":3,"hash":"1","type":"f"},"/b":{"size":3,"hash":"1","type":"f"},"/c":{"size":3,"hash":"1","type":"f"}}`
+	err = ioutil.WriteFile(statePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadSyncState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := map[string]fileInfo{"/a": syncFile("1"), "/b": syncFile("1"), "/c": syncFile("2")}
+	local := map[string]fileInfo{"/a": syncFile("1"), "/b": syncFile("2"), "/c": syncFile("1")}
+	diff := findDelta(remote, local, state.getEntries([]string{"/"}, nil))
+	expected := []FileDiff{{Op: Update, Path: "/b", Type: fileref.FILE}, {Op: Download, Path: "/c", Type: fileref.FILE}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("%v, expected %v", diff, expected)
+	}
+}
+
+func TestSyncStateUpdate(t *testing.T) {
+	f := fileref.FILE
+	state := newSyncState()
+	state.Entries = map[string]*syncStateEntry{
+		"/same":        syncEntry(f, "0", "0"),
+		"/conflict":    syncEntry(f, "0", "0"),
+		"/gone":        syncEntry(f, "0", "0"),
+		"/excluded/a":  syncEntry(f, "0", "0"),
+		"/remote-only": syncEntry(f, "0", "0"),
+		"/local-only":  syncEntry(f, "0", "0"),
+		"/out/a":       syncEntry(f, "0", "0"),
+	}
+	remote := map[string]fileInfo{
+		"/same":        syncFile("1"),
+		"/conflict":    syncFile("1"),
+		"/remote-only": syncFile("1"),
+		"/new":         syncFile("1"),
+		"/d":           syncDir(),
+	}
+	local := map[string]fileInfo{
+		"/same":       syncFile("1"),
+		"/conflict":   syncFile("2"),
+		"/local-only": syncFile("1"),
+		"/new":        syncFile("1"),
+		"/d":          syncDir(),
+	}
+	excluded := map[string]error{"/excluded": os.ErrPermission}
+	state.update([]string{"/same", "/conflict", "/gone", "/excluded", "/remote-only", "/local-only", "/new", "/d"}, remote, local, excluded)
+	expected := map[string]*syncStateEntry{
+		// The same on both sides
+		"/same": syncEntry(f, "1", "1"),
+		"/new":  syncEntry(f, "1", "1"),
+		"/d":    syncEntry(fileref.DIRECTORY, "", ""),
+		// Left for the next diff
+		"/conflict":    syncEntry(f, "0", "0"),
+		"/remote-only": syncEntry(f, "0", "0"),
+		"/local-only":  syncEntry(f, "0", "0"),
+		"/excluded/a":  syncEntry(f, "0", "0"),
+		// Out of the scope
+		"/out/a": syncEntry(f, "0", "0"),
+	}
+	if !reflect.DeepEqual(state.Entries, expected) {
+		for p, e := range state.Entries {
+			t.Logf("%s: %+v", p, e)
+		}
+		t.Errorf("entries after the update differ")
+	}
+}
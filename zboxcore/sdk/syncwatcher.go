@@ -0,0 +1,521 @@
+package sdk
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+const (
+	defaultSyncDebounce         = 2 * time.Second
+	defaultSyncRemotePoll       = time.Minute
+	defaultSyncLocalPoll        = 10 * time.Second
+	syncWatcherEventsBufferSize = 1024
+)
+
+type SyncWatcherOptions struct {
+	LastSyncCachePath string
+	LocalRootPath     string
+	LocalFileFilters  []string
+	RemoteExcludePath []string
+	// Quiet time after a local change before the changed paths are pushed,
+	// 2 seconds if not set
+	Debounce time.Duration
+	// Interval of the checks of the allocation root for remote changes, a
+	// minute if not set
+	RemotePollInterval time.Duration
+	// Interval of the local scans where inotify is not available, 10
+	// seconds if not set
+	LocalPollInterval time.Duration
+	// Mode and conflict policy of the syncs. The snapshot is kept by the
+	// watcher at LastSyncCachePath.
+	ApplyOptions *ApplyDiffOptions
+	// Called after every sync with its report
+	OnSync func(report *SyncReport, err error)
+}
+
+// SyncWatcher - keeps the local tree in sync with the allocation. Local
+// changes are pushed path by path once they settle, and the whole tree is
+// synced when the allocation root changes.
+type SyncWatcher struct {
+	allocation *Allocation
+	opts       SyncWatcherOptions
+	ignore     *zboxutil.IgnoreMatcher
+	// Hashes of the allocation root by blobber at the last sync
+	rootHashes map[string]string
+	// Local paths written by the syncs, whose events are not local changes
+	written map[string]writtenEntry
+}
+
+// writtenEntry - state a sync left a local path in
+type writtenEntry struct {
+	exists  bool
+	isDir   bool
+	size    int64
+	modTime time.Time
+	// When the sync wrote the path
+	at time.Time
+}
+
+// localWatcher - reports the local paths that changed
+type localWatcher interface {
+	Events() <-chan string
+	Close() error
+}
+
+// NewSyncWatcher - creates a watcher for the local root of the options
+func (a *Allocation) NewSyncWatcher(opts SyncWatcherOptions) (*SyncWatcher, error) {
+	if !a.isInitialized() {
+		return nil, notInitialized
+	}
+	if len(opts.LastSyncCachePath) == 0 {
+		return nil, common.NewError("invalid_option", "Sync cache path is not set")
+	}
+	stat, err := os.Stat(opts.LocalRootPath)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, common.NewError("invalid_path", opts.LocalRootPath+" is not a directory")
+	}
+	opts.LocalRootPath = filepath.Clean(opts.LocalRootPath)
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultSyncDebounce
+	}
+	if opts.RemotePollInterval <= 0 {
+		opts.RemotePollInterval = defaultSyncRemotePoll
+	}
+	if opts.LocalPollInterval <= 0 {
+		opts.LocalPollInterval = defaultSyncLocalPoll
+	}
+	applyOpts := ApplyDiffOptions{}
+	if opts.ApplyOptions != nil {
+		applyOpts = *opts.ApplyOptions
+	}
+	applyOpts.LocalFileFilters = opts.LocalFileFilters
+	applyOpts.RemoteExcludePath = opts.RemoteExcludePath
+	opts.ApplyOptions = &applyOpts
+
+	w := &SyncWatcher{allocation: a, opts: opts, written: make(map[string]writtenEntry)}
+	w.ignore, err = getSyncIgnore(opts.LocalRootPath, opts.LocalFileFilters, opts.RemoteExcludePath)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Run - syncs the whole tree, then watches for changes till ctx is done. The
+// syncs run till ctx is done too, Run returns once the one in progress
+// stopped.
+func (w *SyncWatcher) Run(ctx context.Context) error {
+	lw, err := newInotifyWatcher(w.opts.LocalRootPath)
+	if err != nil {
+		Logger.Info("Watching ", w.opts.LocalRootPath, " by polling: ", err)
+		lw = newPollWatcher(w.opts.LocalRootPath, w.opts.LocalPollInterval)
+	}
+	defer lw.Close()
+	return w.watch(ctx, lw)
+}
+
+// watch - syncs the whole tree, then the changes lw reports and the changes
+// of the allocation root till ctx is done
+func (w *SyncWatcher) watch(ctx context.Context, lw localWatcher) error {
+	w.syncAll(ctx)
+	remoteTicker := time.NewTicker(w.opts.RemotePollInterval)
+	defer remoteTicker.Stop()
+	debounce := time.NewTimer(w.opts.Debounce)
+	debounce.Stop()
+	pending := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case absPath, ok := <-lw.Events():
+			if !ok {
+				return common.NewError("watch_failed", "Watching the local tree stopped")
+			}
+			if w.isSyncWrite(absPath) {
+				continue
+			}
+			rel, err := filepath.Rel(w.opts.LocalRootPath, absPath)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+				continue
+			}
+			if rel == "." {
+				rel = ""
+			}
+			pending["/"+filepath.ToSlash(rel)] = true
+			if !debounce.Stop() {
+				select {
+				case <-debounce.C:
+				default:
+				}
+			}
+			debounce.Reset(w.opts.Debounce)
+		case <-debounce.C:
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, path.Clean(p))
+			}
+			pending = make(map[string]bool)
+			if w.isIgnoreFileChanged(paths) {
+				// Paths may have been included or left out anywhere
+				w.syncAll(ctx)
+				continue
+			}
+			w.syncPaths(ctx, paths)
+		case <-remoteTicker.C:
+			rootHashes, err := w.allocation.getRemoteRootHashes(ctx)
+			if err != nil {
+				Logger.Error("Sync watcher remote poll: ", err)
+				continue
+			}
+			if w.isRemoteChanged(rootHashes) {
+				w.syncAll(ctx)
+			}
+		}
+	}
+}
+
+func (w *SyncWatcher) report(report *SyncReport, err error) {
+	if err != nil {
+		Logger.Error("Sync watcher: ", err)
+	}
+	if w.opts.OnSync != nil {
+		w.opts.OnSync(report, err)
+	}
+}
+
+// isIgnoreFileChanged - reloads the patterns when the ignore file is among the
+// changed paths
+func (w *SyncWatcher) isIgnoreFileChanged(paths []string) bool {
+	for _, p := range paths {
+		if p != "/"+SyncIgnoreFile {
+			continue
+		}
+		ignore, err := getSyncIgnore(w.opts.LocalRootPath, w.opts.LocalFileFilters, w.opts.RemoteExcludePath)
+		if err != nil {
+			Logger.Error("Sync watcher: keeping the previous patterns: ", err)
+			return false
+		}
+		w.ignore = ignore
+		return true
+	}
+	return false
+}
+
+// syncAll - syncs the whole tree and updates the sync state
+func (w *SyncWatcher) syncAll(ctx context.Context) {
+	a := w.allocation
+	rootHashes, err := a.getRemoteRootHashes(ctx)
+	if err != nil {
+		w.report(nil, err)
+		return
+	}
+	diffs, scanErr, err := a.GetAllocationDiffWithScanErrors(w.opts.LastSyncCachePath, w.opts.LocalRootPath, w.opts.LocalFileFilters, w.opts.RemoteExcludePath)
+	if err != nil {
+		w.report(nil, err)
+		return
+	}
+	applyOpts := *w.opts.ApplyOptions
+	applyOpts.SnapshotPath = w.opts.LastSyncCachePath
+	report, err := a.ApplyDiffCtx(ctx, diffs, w.opts.LocalRootPath, &applyOpts)
+	if err == nil && scanErr != nil {
+		err = scanErr
+	}
+	w.rootHashes = rootHashes
+	if report != nil && report.Completed > 0 {
+		// Changes made by the sync itself don't trigger another one
+		if newHashes, err := a.getRemoteRootHashes(ctx); err == nil {
+			w.rootHashes = newHashes
+		}
+	}
+	w.recordWrites(report)
+	w.report(report, err)
+}
+
+// syncPaths - syncs only the paths changed locally and the entries under
+// them, without walking the rest of the trees
+func (w *SyncWatcher) syncPaths(ctx context.Context, paths []string) {
+	a := w.allocation
+	state, err := loadSyncState(w.opts.LastSyncCachePath)
+	if err != nil {
+		w.report(nil, err)
+		return
+	}
+	idx := loadLocalIndex(getLocalIndexPath(w.opts.LastSyncCachePath))
+	rMap := make(map[string]fileInfo)
+	lMap := make(map[string]fileInfo)
+	scanErrs := make(map[string]error)
+	for _, p := range paths {
+		remote, err := a.getRemotePathMap(p, w.ignore)
+		if err != nil {
+			w.report(nil, err)
+			return
+		}
+		for k, v := range remote {
+			rMap[k] = v
+		}
+		addLocalPath(w.opts.LocalRootPath, p, lMap, w.ignore, idx, scanErrs)
+	}
+	err = idx.save(false)
+	if err != nil {
+		Logger.Error("Saving the local index failed: ", err)
+	}
+	excludePaths(rMap, scanErrs)
+
+	diffs := findDelta(rMap, lMap, state.getEntries(paths, scanErrs))
+	if len(diffs) == 0 {
+		return
+	}
+	applyOpts := *w.opts.ApplyOptions
+	applyOpts.SnapshotPath = ""
+	report, err := a.ApplyDiffCtx(ctx, diffs, w.opts.LocalRootPath, &applyOpts)
+	if err == nil && len(scanErrs) > 0 {
+		err = &LocalScanError{Errors: scanErrs}
+	}
+	stateErr := a.updateSyncState(w.opts.LastSyncCachePath, w.opts.LocalRootPath, w.ignore, paths)
+	if stateErr != nil && err == nil {
+		err = stateErr
+	}
+	if report != nil && report.Completed > 0 {
+		// The root changed by the push
+		if rootHashes, err := a.getRemoteRootHashes(ctx); err == nil {
+			w.rootHashes = rootHashes
+		}
+	}
+	w.recordWrites(report)
+	w.report(report, err)
+}
+
+func statWritten(p string) writtenEntry {
+	stat, err := os.Stat(p)
+	if err != nil {
+		return writtenEntry{}
+	}
+	return writtenEntry{exists: true, isDir: stat.IsDir(), size: stat.Size(), modTime: stat.ModTime()}
+}
+
+// matches - whether the path is still as the sync left it. The time of a
+// directory changes with its entries, which have events of their own.
+func (e writtenEntry) matches(cur writtenEntry) bool {
+	if e.exists != cur.exists || e.isDir != cur.isDir {
+		return false
+	}
+	return !e.exists || e.isDir || (e.size == cur.size && e.modTime.Equal(cur.modTime))
+}
+
+// recordWrites - records the local paths the sync wrote, along with the
+// temporary files and the directories of the downloads
+func (w *SyncWatcher) recordWrites(report *SyncReport) {
+	now := time.Now()
+	// The poll watcher reports a change by the next scan
+	for p, e := range w.written {
+		if now.Sub(e.at) > 2*w.opts.LocalPollInterval {
+			delete(w.written, p)
+		}
+	}
+	if report == nil {
+		return
+	}
+	record := func(p string) {
+		e := statWritten(p)
+		e.at = now
+		w.written[p] = e
+	}
+	for _, result := range report.Results {
+		if result.Status != TransferCompleted {
+			continue
+		}
+		if result.ConflictCopy != "" {
+			record(result.ConflictCopy)
+		}
+		switch result.Op {
+		case Download, LocalDelete, Conflict:
+		default:
+			continue
+		}
+		localPath := filepath.Join(w.opts.LocalRootPath, filepath.FromSlash(result.Path))
+		record(localPath)
+		if result.Op == LocalDelete {
+			continue
+		}
+		tmpPath := localPath + ".part"
+		record(tmpPath)
+		record(downloadStatePath(tmpPath))
+		for dir := filepath.Dir(localPath); len(dir) > len(w.opts.LocalRootPath); dir = filepath.Dir(dir) {
+			record(dir)
+		}
+	}
+}
+
+// isSyncWrite - whether the event of the local path comes from a write of
+// a sync. The entries under a directory the sync deleted are gone with it.
+func (w *SyncWatcher) isSyncWrite(p string) bool {
+	cur := statWritten(p)
+	if e, ok := w.written[p]; ok {
+		if e.matches(cur) {
+			return true
+		}
+		delete(w.written, p)
+		return false
+	}
+	if cur.exists {
+		return false
+	}
+	for dir := filepath.Dir(p); len(dir) > len(w.opts.LocalRootPath); dir = filepath.Dir(dir) {
+		if e, ok := w.written[dir]; ok && !e.exists {
+			return !statWritten(dir).exists
+		}
+	}
+	return false
+}
+
+func isSameOrUnder(p string, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// getRemotePathMap - the remote entries at remotePath and under it
+func (a *Allocation) getRemotePathMap(remotePath string, ignore *zboxutil.IgnoreMatcher) (map[string]fileInfo, error) {
+	fMap := make(map[string]fileInfo)
+	dirs := []string{remotePath}
+	if remotePath != "/" {
+		parent, _ := path.Split(remotePath)
+		parentMap := make(map[string]fileInfo)
+		_, err := a.getRemoteFilesAndDirs([]string{path.Clean(parent)}, parentMap, ignore)
+		if err != nil {
+			return nil, err
+		}
+		info, ok := parentMap[remotePath]
+		if !ok {
+			return fMap, nil
+		}
+		fMap[remotePath] = info
+		if info.Type != fileref.DIRECTORY {
+			return fMap, nil
+		}
+	}
+	for len(dirs) > 0 {
+		var err error
+		dirs, err = a.getRemoteFilesAndDirs(dirs, fMap, ignore)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fMap, nil
+}
+
+// isRemoteChanged - whether the root of a blobber changed since the last
+// sync. A blobber that didn't answer is not taken as changed.
+func (w *SyncWatcher) isRemoteChanged(rootHashes map[string]string) bool {
+	for id, hash := range rootHashes {
+		if w.rootHashes[id] != hash {
+			return true
+		}
+	}
+	return false
+}
+
+// getRemoteRootHashes - hashes of the root directory by blobber. Each root
+// covers the shards of its blobber, so the hashes differ between the
+// blobbers even when they hold the same files.
+func (a *Allocation) getRemoteRootHashes(ctx context.Context) (map[string]string, error) {
+	listReq := a.newHealthListRequest("/")
+	listReq.ctx = ctx
+	lR := listReq.getlistFromBlobbers()
+	rootHashes := make(map[string]string)
+	for _, rsp := range lR {
+		if rsp.err != nil || rsp.ref == nil {
+			continue
+		}
+		rootHashes[a.Blobbers[rsp.blobberIdx].ID] = rsp.ref.Hash
+	}
+	consensus := &Consensus{}
+	consensus.consensus = float32(len(rootHashes))
+	consensus.consensusThresh = listReq.consensusThresh
+	consensus.fullconsensus = listReq.fullconsensus
+	if !consensus.isConsensusMin() {
+		return nil, common.NewError("consensus_not_met", "Not enough blobbers listed the allocation root")
+	}
+	return rootHashes, nil
+}
+
+// pollWatcher - finds the local changes by scanning the tree, where
+// inotify is not available
+type pollWatcher struct {
+	root   string
+	events chan string
+	done   chan struct{}
+}
+
+type pollEntry struct {
+	size    int64
+	modTime time.Time
+}
+
+func newPollWatcher(root string, interval time.Duration) *pollWatcher {
+	w := &pollWatcher{root: root, events: make(chan string, syncWatcherEventsBufferSize), done: make(chan struct{})}
+	go w.run(interval)
+	return w
+}
+
+func (w *pollWatcher) scan() map[string]pollEntry {
+	entries := make(map[string]pollEntry)
+	filepath.Walk(w.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		entries[p] = pollEntry{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return entries
+}
+
+func (w *pollWatcher) run(interval time.Duration) {
+	prev := w.scan()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		cur := w.scan()
+		for p, e := range cur {
+			if old, ok := prev[p]; !ok || old != e {
+				w.send(p)
+			}
+		}
+		for p := range prev {
+			if _, ok := cur[p]; !ok {
+				w.send(p)
+			}
+		}
+		prev = cur
+	}
+}
+
+func (w *pollWatcher) send(p string) {
+	select {
+	case w.events <- p:
+	case <-w.done:
+	}
+}
+
+func (w *pollWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
@@ -0,0 +1,127 @@
+package sdk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	. "github.com/0chain/gosdk/zboxcore/logger"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF
+
+// inotifyWatcher - watches every directory of the tree with inotify, adding
+// the directories created later on
+type inotifyWatcher struct {
+	root    string
+	fd      int
+	file    *os.File
+	watches map[int32]string
+	events  chan string
+	done    chan struct{}
+	mutex   sync.Mutex
+}
+
+func newInotifyWatcher(root string) (localWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{root: root, fd: fd, watches: make(map[int32]string), events: make(chan string, syncWatcherEventsBufferSize), done: make(chan struct{})}
+	// A non blocking descriptor goes through the runtime poller, so that
+	// closing the file ends a pending read
+	w.file = os.NewFile(uintptr(fd), "inotify")
+	err = w.addTree(root)
+	if err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, inotifyMask)
+		if err != nil {
+			Logger.Error("Watching ", p, " failed: ", err)
+			return nil
+		}
+		w.mutex.Lock()
+		w.watches[int32(wd)] = p
+		w.mutex.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) run() {
+	defer close(w.events)
+	buf := make([]byte, syscall.SizeofInotifyEvent*4096)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				Logger.Error("Reading inotify events failed: ", err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// Events were lost, have the whole tree checked
+				w.send(w.root)
+				continue
+			}
+			w.mutex.Lock()
+			dir, ok := w.watches[event.Wd]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.watches, event.Wd)
+			}
+			w.mutex.Unlock()
+			if !ok {
+				continue
+			}
+			p := dir
+			if name := string(bytes.TrimRight(nameBytes, "\x00")); len(name) > 0 {
+				p = filepath.Join(dir, name)
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				w.addTree(p)
+			}
+			w.send(p)
+		}
+	}
+}
+
+func (w *inotifyWatcher) send(p string) {
+	select {
+	case w.events <- p:
+	case <-w.done:
+	}
+}
+
+func (w *inotifyWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
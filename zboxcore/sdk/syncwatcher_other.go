@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package sdk
+
+import "github.com/0chain/gosdk/core/common"
+
+func newInotifyWatcher(root string) (localWatcher, error) {
+	return nil, common.NewError("not_supported", "inotify is available on linux only")
+}
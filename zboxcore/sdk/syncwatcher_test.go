@@ -0,0 +1,240 @@
+package sdk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/0chain/gosdk/zboxcore/blobbertest"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+type syncEvent struct {
+	report *SyncReport
+	err    error
+	at     time.Time
+}
+
+// startWatcher - watches the local root of st by polling, the syncs sent to
+// the returned channel. The watch stops with the returned cancel.
+func startWatcher(t *testing.T, st *syncTest, opts SyncWatcherOptions) (chan syncEvent, context.CancelFunc, chan error) {
+	syncs := make(chan syncEvent, 16)
+	opts.LastSyncCachePath = st.statePath
+	opts.LocalRootPath = st.root
+	opts.OnSync = func(report *SyncReport, err error) {
+		syncs <- syncEvent{report: report, err: err, at: time.Now()}
+	}
+	w, err := st.a.NewSyncWatcher(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lw := newPollWatcher(st.root, w.opts.LocalPollInterval)
+	done := make(chan error, 1)
+	go func() {
+		defer lw.Close()
+		done <- w.watch(ctx, lw)
+	}()
+	return syncs, cancel, done
+}
+
+func waitSync(t *testing.T, syncs chan syncEvent) syncEvent {
+	select {
+	case e := <-syncs:
+		return e
+	case <-time.After(10 * time.Second):
+		t.Fatal("no sync")
+	}
+	return syncEvent{}
+}
+
+func expectNoSync(t *testing.T, syncs chan syncEvent, wait time.Duration) {
+	select {
+	case e := <-syncs:
+		t.Fatalf("unexpected sync %v %v", operations(e.report), e.err)
+	case <-time.After(wait):
+	}
+}
+
+func stopWatcher(t *testing.T, cancel context.CancelFunc, done chan error) {
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch didn't stop")
+	}
+}
+
+func sortedOperations(report *SyncReport) []string {
+	ops := operations(report)
+	sort.Strings(ops)
+	return ops
+}
+
+func TestSyncWatcherDebounce(t *testing.T) {
+	st := newSyncTest(t, "sync_watcher_debounce")
+	defer st.Close()
+	debounce := 300 * time.Millisecond
+	syncs, cancel, done := startWatcher(t, st, SyncWatcherOptions{Debounce: debounce, LocalPollInterval: 20 * time.Millisecond, RemotePollInterval: time.Hour})
+	defer stopWatcher(t, cancel, done)
+	if e := waitSync(t, syncs); e.err != nil || len(e.report.Results) != 0 {
+		t.Fatalf("first sync %v %v", operations(e.report), e.err)
+	}
+
+	// Changes closer than the debounce are pushed together once they
+	// settle
+	var last time.Time
+	for _, name := range []string{"/a.bin", "/b.bin", "/a.bin"} {
+		st.writeLocal(t, name, randomContent(100+len(last.String())))
+		last = time.Now()
+		time.Sleep(debounce / 3)
+	}
+	e := waitSync(t, syncs)
+	if e.err != nil {
+		t.Fatal(e.err)
+	}
+	if e.at.Sub(last) < debounce {
+		t.Errorf("sync %v after the last change", e.at.Sub(last))
+	}
+	expected := []string{Upload + " /a.bin " + TransferCompleted, Upload + " /b.bin " + TransferCompleted}
+	if ops := sortedOperations(e.report); !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("%v, expected %v", ops, expected)
+	}
+	expectNoSync(t, syncs, 2*debounce)
+}
+
+func TestSyncWatcherEcho(t *testing.T) {
+	st := newSyncTest(t, "sync_watcher_echo")
+	defer st.Close()
+	remote := randomContent(1000)
+	st.writeRemote(t, "/dir/remote.bin", remote, false)
+
+	debounce := 100 * time.Millisecond
+	syncs, cancel, done := startWatcher(t, st, SyncWatcherOptions{Debounce: debounce, LocalPollInterval: 10 * time.Millisecond, RemotePollInterval: time.Hour})
+	defer stopWatcher(t, cancel, done)
+	e := waitSync(t, syncs)
+	expected := []string{Download + " /dir/remote.bin " + TransferCompleted}
+	if ops := operations(e.report); e.err != nil || !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("first sync %v %v, expected %v", ops, e.err, expected)
+	}
+	// The files and directories written by the download are not pushed
+	// back
+	expectNoSync(t, syncs, 5*debounce)
+
+	// A change after the download is
+	st.writeLocal(t, "/dir/remote.bin", randomContent(2000))
+	e = waitSync(t, syncs)
+	expected = []string{Update + " /dir/remote.bin " + TransferCompleted}
+	if ops := operations(e.report); e.err != nil || !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("%v %v, expected %v", ops, e.err, expected)
+	}
+}
+
+func TestSyncWatcherRemoteChange(t *testing.T) {
+	st := newSyncTest(t, "sync_watcher_remote_change")
+	defer st.Close()
+	poll := 50 * time.Millisecond
+	syncs, cancel, done := startWatcher(t, st, SyncWatcherOptions{Debounce: poll, LocalPollInterval: poll, RemotePollInterval: poll})
+	defer stopWatcher(t, cancel, done)
+	if e := waitSync(t, syncs); e.err != nil {
+		t.Fatal(e.err)
+	}
+	expectNoSync(t, syncs, 5*poll)
+
+	remote := randomContent(1000)
+	st.writeRemote(t, "/remote.bin", remote, false)
+	e := waitSync(t, syncs)
+	expected := []string{Download + " /remote.bin " + TransferCompleted}
+	if ops := operations(e.report); e.err != nil || !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("%v %v, expected %v", ops, e.err, expected)
+	}
+	// Nor does a push of the watcher trigger a sync of the whole tree
+	st.writeLocal(t, "/local.bin", randomContent(1000))
+	e = waitSync(t, syncs)
+	expected = []string{Upload + " /local.bin " + TransferCompleted}
+	if ops := operations(e.report); e.err != nil || !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("%v %v, expected %v", ops, e.err, expected)
+	}
+	expectNoSync(t, syncs, 5*poll)
+}
+
+func TestSyncWatcherCancel(t *testing.T) {
+	st := newSyncTest(t, "sync_watcher_cancel")
+	defer st.Close()
+	st.writeLocal(t, "/a.bin", randomContent(1000))
+	st.writeLocal(t, "/b.bin", randomContent(1000))
+	for idx := range st.network.Blobbers {
+		st.injector.SetFaults(st.blobberURL(idx), blobbertest.Fault{Operations: []string{zboxutil.OperationUpload}, Latency: time.Minute})
+	}
+
+	syncs, cancel, done := startWatcher(t, st, SyncWatcherOptions{RemotePollInterval: time.Hour})
+	time.Sleep(200 * time.Millisecond)
+	stopWatcher(t, cancel, done)
+	// The upload in progress is aborted, the other one not started
+	e := waitSync(t, syncs)
+	if e.err == nil || e.report == nil || e.report.Failed != 2 {
+		t.Fatalf("sync %v %v", operations(e.report), e.err)
+	}
+	for _, result := range e.report.Results {
+		if result.Status != TransferFailed || len(result.Error) == 0 {
+			t.Errorf("result %+v", result)
+		}
+	}
+}
+
+func TestIsSyncWrite(t *testing.T) {
+	root, err := ioutil.TempDir("", "syncwrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	local := func(p string) string {
+		return filepath.Join(root, filepath.FromSlash(p))
+	}
+	os.MkdirAll(local("/dir"), 0755)
+	ioutil.WriteFile(local("/dir/downloaded"), []byte("content"), 0644)
+	os.MkdirAll(local("/kept"), 0755)
+
+	w := &SyncWatcher{opts: SyncWatcherOptions{LocalRootPath: root, LocalPollInterval: time.Minute}, written: make(map[string]writtenEntry)}
+	w.recordWrites(&SyncReport{Results: []*SyncResult{
+		{FileDiff: FileDiff{Op: Download, Path: "/dir/downloaded"}, Status: TransferCompleted},
+		{FileDiff: FileDiff{Op: LocalDelete, Path: "/deleted"}, Status: TransferCompleted},
+		{FileDiff: FileDiff{Op: Download, Path: "/failed"}, Status: TransferFailed},
+		{FileDiff: FileDiff{Op: Upload, Path: "/kept/uploaded"}, Status: TransferCompleted},
+	}})
+	tests := []struct {
+		name   string
+		change func()
+		path   string
+		echo   bool
+	}{
+		{"downloaded file", nil, "/dir/downloaded", true},
+		{"directory of the download", nil, "/dir", true},
+		{"temporary file of the download", nil, "/dir/downloaded.part", true},
+		{"deleted directory", nil, "/deleted", true},
+		{"file of the deleted directory", nil, "/deleted/file", true},
+		{"failed download", nil, "/failed", false},
+		{"uploaded file", nil, "/kept/uploaded", false},
+		{"other path", nil, "/kept", false},
+		{"downloaded file modified", func() { ioutil.WriteFile(local("/dir/downloaded"), []byte("modified"), 0644) }, "/dir/downloaded", false},
+		{"downloaded file after the modification", nil, "/dir/downloaded", false},
+		{"deleted directory created again", func() { os.MkdirAll(local("/deleted"), 0755) }, "/deleted", false},
+		{"file created in the directory created again", func() { ioutil.WriteFile(local("/deleted/file"), nil, 0644) }, "/deleted/file", false},
+	}
+	for _, tt := range tests {
+		if tt.change != nil {
+			tt.change()
+		}
+		if echo := w.isSyncWrite(local(tt.path)); echo != tt.echo {
+			t.Errorf("%s: %v, expected %v", tt.name, echo, tt.echo)
+		}
+	}
+}
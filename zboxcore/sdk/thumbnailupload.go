@@ -3,6 +3,7 @@ package sdk
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"math"
 	"math/bits"
@@ -48,25 +49,37 @@ func (req *UploadRequest) pushThumbnailData(data []byte) error {
 	}
 	for i := req.uploadMask; i != 0; i &= ^(1 << uint32(pos)) {
 		pos = bits.TrailingZeros32(i)
-		req.uploadThumbCh[c] <- shards[pos]
+		err = req.sendShard(req.uploadThumbCh[c], shards[pos])
+		if err != nil {
+			return err
+		}
 		c++
 	}
 	return nil
 }
 
+// processThumbnail - pushes the thumbnail to the blobbers. A thumbnail not
+// of the size declared fails the push.
 func (req *UploadRequest) processThumbnail(a *Allocation, wg *sync.WaitGroup) {
 	defer wg.Done()
-	var inFile *os.File
-	inFile, err := os.Open(req.thumbnailpath)
-	if err != nil {
-		return
+	var inFile io.Reader
+	if req.thumbnailReader != nil {
+		inFile = req.thumbnailReader
+	} else {
+		file, err := os.Open(req.thumbnailpath)
+		if err != nil {
+			req.failPush(fmt.Errorf("Open thumbnail failed: %s", err.Error()))
+			return
+		}
+		defer file.Close()
+		inFile = file
 	}
 	size := req.filemeta.ThumbnailSize
 	// Calculate number of bytes per shard.
 	perShard := (size + int64(a.DataShards) - 1) / int64(a.DataShards)
 	// Pad data to Shards*perShard.
 	padding := make([]byte, (int64(a.DataShards)*perShard)-size)
-	dataReader := io.MultiReader(inFile, bytes.NewBuffer(padding))
+	dataReader := io.MultiReader(io.LimitReader(inFile, size), bytes.NewBuffer(padding))
 	chunkSizeWithHeader := int64(fileref.CHUNK_SIZE)
 	if req.isEncrypted {
 		chunkSizeWithHeader -= 16
@@ -79,20 +92,26 @@ func (req *UploadRequest) processThumbnail(a *Allocation, wg *sync.WaitGroup) {
 	for ctr := int64(0); ctr < chunksPerShard; ctr++ {
 		remaining := int64(math.Min(float64(perShard-(ctr*chunkSizeWithHeader)), float64(chunkSizeWithHeader)))
 		b1 := make([]byte, remaining*int64(a.DataShards))
-		_, err = dataReader.Read(b1)
+		_, err := io.ReadFull(dataReader, b1)
 		if err != nil {
+			req.failPush(fmt.Errorf("Thumbnail read failed: %s", err.Error()))
 			return
 		}
 		err = req.pushThumbnailData(b1)
 		if err != nil {
+			req.failPush(fmt.Errorf("Thumbnail push error: %s", err.Error()))
 			return
 		}
 		//sent = sent + int(remaining*int64(a.DataShards+a.ParityShards))
 	}
-	err = req.completeThumbnailPush()
-	if err != nil {
+	if n, _ := inFile.Read(make([]byte, 1)); n > 0 {
+		req.failPush(fmt.Errorf("Thumbnail read failed: thumbnail is larger than %d bytes", size))
 		return
 	}
+	err := req.completeThumbnailPush()
+	if err != nil {
+		req.failPush(fmt.Errorf("Thumbnail push error: %s", err.Error()))
+	}
 }
 
 func (req *UploadRequest) completeThumbnailPush() error {
@@ -102,7 +121,10 @@ func (req *UploadRequest) completeThumbnailPush() error {
 		c, pos := 0, 0
 		for i := req.uploadMask; i != 0; i &= ^(1 << uint32(pos)) {
 			pos = bits.TrailingZeros32(i)
-			req.uploadThumbCh[c] <- []byte("done")
+			err := req.sendShard(req.uploadThumbCh[c], []byte("done"))
+			if err != nil {
+				return err
+			}
 			c++
 		}
 	}
@@ -0,0 +1,51 @@
+package sdk
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/0chain/gosdk/zboxcore/fileref"
+)
+
+func TestUploadThumbnailSize(t *testing.T) {
+	ft := newFaultTest(t, "upload_thumbnail_size")
+	defer ft.Close()
+	content := make([]byte, 2*fileref.CHUNK_SIZE)
+	rand.Read(content)
+	thumbnail := make([]byte, 1000)
+	rand.Read(thumbnail)
+	tests := []struct {
+		name      string
+		thumbnail []byte
+		size      int64
+		ok        bool
+	}{
+		{"short", thumbnail[:500], 1000, false},
+		{"long", thumbnail, 500, false},
+		{"exact", thumbnail, 1000, true},
+	}
+	for _, tt := range tests {
+		remotePath := "/" + tt.name + ".bin"
+		status := &testStatus{done: make(chan error, 1)}
+		err := ft.a.UploadFromReaderWithThumbnail(bytes.NewReader(content), int64(len(content)), remotePath, bytes.NewReader(tt.thumbnail), tt.size, status)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		err = status.wait(t)
+		_, metaErr := ft.a.GetFileMeta(remotePath)
+		if tt.ok {
+			if err != nil || metaErr != nil {
+				t.Errorf("%s: upload failed: %v, %v", tt.name, err, metaErr)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "Thumbnail read failed") {
+			t.Errorf("%s: thumbnail of %d bytes declared %d, upload error %v", tt.name, len(tt.thumbnail), tt.size, err)
+		}
+		if metaErr == nil {
+			t.Errorf("%s: failed upload was committed", tt.name)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/0chain/gosdk/core/encryption"
+	"github.com/0chain/gosdk/zboxcore/blockchain"
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	. "github.com/0chain/gosdk/zboxcore/logger"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+// uploadState - progress of an upload saved to the upload state directory,
+// so that an interrupted upload can be resumed after the process restarts.
+// Uploaded holds the file refs of the blobbers that acknowledged the whole
+// shard and Acknowledged the index of the last chunk acknowledged by the
+// others taking the shard in parts, by blobber id. Kept for the uploads of
+// local files only, the state needs the file to read it again.
+type uploadState struct {
+	AllocationID  string                      `json:"allocation_id"`
+	ConnectionID  string                      `json:"connection_id"`
+	LocalPath     string                      `json:"local_path"`
+	ThumbnailPath string                      `json:"thumbnail_path,omitempty"`
+	RemotePath    string                      `json:"remote_path"`
+	IsUpdate      bool                        `json:"is_update"`
+	IsEncrypted   bool                        `json:"is_encrypted"`
+	Size          int64                       `json:"size"`
+	ModTime       int64                       `json:"mod_time"`
+	Uploaded      map[string]*fileref.FileRef `json:"uploaded"`
+	Acknowledged  map[string]int64            `json:"acknowledged"`
+	mutex         sync.Mutex
+}
+
+type connectionDetails struct {
+	ConnectionID string              `json:"connection_id"`
+	AllocationID string              `json:"allocation_id"`
+	Size         int64               `json:"size"`
+	Changes      []*connectionChange `json:"changes"`
+}
+
+type connectionChange struct {
+	Operation string `json:"operation"`
+	Size      int64  `json:"size"`
+	Input     string `json:"input"`
+}
+
+func getUploadStateDir() string {
+	if len(uploadStateDir) > 0 {
+		return uploadStateDir
+	}
+	return filepath.Join(os.TempDir(), "zbox", "uploads")
+}
+
+func uploadStatePath(allocationID string, remotepath string) string {
+	return filepath.Join(getUploadStateDir(), encryption.Hash(allocationID+":"+remotepath)+".json")
+}
+
+func newUploadState(allocationID string, req *UploadRequest, fileInfo os.FileInfo) *uploadState {
+	state := &uploadState{}
+	state.AllocationID = allocationID
+	state.ConnectionID = req.connectionID
+	state.LocalPath = req.filepath
+	state.ThumbnailPath = req.thumbnailpath
+	state.RemotePath = req.remotefilepath
+	state.IsUpdate = req.isUpdate
+	state.IsEncrypted = req.isEncrypted
+	state.Size = fileInfo.Size()
+	state.ModTime = fileInfo.ModTime().UnixNano()
+	state.Uploaded = make(map[string]*fileref.FileRef)
+	state.Acknowledged = make(map[string]int64)
+	return state
+}
+
+func loadUploadState(allocationID string, remotepath string) (*uploadState, error) {
+	b, err := ioutil.ReadFile(uploadStatePath(allocationID, remotepath))
+	if err != nil {
+		return nil, err
+	}
+	state := &uploadState{}
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		return nil, fmt.Errorf("upload state parse error: %s", err.Error())
+	}
+	if state.Uploaded == nil {
+		state.Uploaded = make(map[string]*fileref.FileRef)
+	}
+	if state.Acknowledged == nil {
+		state.Acknowledged = make(map[string]int64)
+	}
+	return state, nil
+}
+
+func (s *uploadState) save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.saveLocked()
+}
+
+func (s *uploadState) saveLocked() error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	statePath := uploadStatePath(s.AllocationID, s.RemotePath)
+	err = os.MkdirAll(filepath.Dir(statePath), 0700)
+	if err != nil {
+		return err
+	}
+	// Write and rename, a crash must not leave a truncated state behind
+	tmpPath := statePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, statePath)
+}
+
+// setUploaded - records the file ref acknowledged by the blobber
+func (s *uploadState) setUploaded(blobberID string, ref *fileref.FileRef) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Uploaded[blobberID] = ref
+	err := s.saveLocked()
+	if err != nil {
+		Logger.Error("Saving upload state failed: ", err)
+	}
+}
+
+// setAcknowledged - records the last chunk of the shard acknowledged by the
+// blobber
+func (s *uploadState) setAcknowledged(blobberID string, chunkIndex int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Acknowledged[blobberID] = chunkIndex
+	err := s.saveLocked()
+	if err != nil {
+		Logger.Error("Saving upload state failed: ", err)
+	}
+}
+
+func (s *uploadState) remove() {
+	os.Remove(uploadStatePath(s.AllocationID, s.RemotePath))
+}
+
+// getPendingUpload - the meta of the last request of the upload of
+// remotepath the blobber holds in the uncommitted connection, nil if none
+func getPendingUpload(ctx context.Context, blobber *blockchain.StorageNode, allocationID string, connectionID string, remotepath string) *uploadFormData {
+	details, err := getConnectionDetails(ctx, blobber, allocationID, connectionID)
+	if err != nil {
+		Logger.Error(blobber.Baseurl, " connection details: ", err)
+		return nil
+	}
+	for _, change := range details.Changes {
+		var formData uploadFormData
+		if json.Unmarshal([]byte(change.Input), &formData) != nil {
+			continue
+		}
+		if formData.Path == remotepath && formData.ConnectionID == connectionID {
+			return &formData
+		}
+	}
+	return nil
+}
+
+// getConnectionDetails - the uncommitted changes of the connection on the
+// blobber. Records the upload capabilities the blobber answers with.
+func getConnectionDetails(ctx context.Context, blobber *blockchain.StorageNode, allocationID string, connectionID string) (*connectionDetails, error) {
+	httpreq, err := zboxutil.NewConnectionRequest(blobber.Baseurl, allocationID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	details := &connectionDetails{}
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+	err = zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		setChunkedUpload(blobber.ID, hasCapability(resp.Header, zboxutil.CHUNKED_UPLOAD_CAPABILITY))
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Connection details error response: Status: %d - %s ", resp.StatusCode, string(respBody))
+		}
+		return json.Unmarshal(respBody, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// Whether the blobbers take uploads in parts, by blobber id
+var chunkedUploads = make(map[string]bool)
+var chunkedUploadsMutex sync.Mutex
+
+func setChunkedUpload(blobberID string, chunked bool) {
+	chunkedUploadsMutex.Lock()
+	defer chunkedUploadsMutex.Unlock()
+	chunkedUploads[blobberID] = chunked
+}
+
+func hasCapability(header http.Header, capability string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(zboxutil.UPLOAD_CAPABILITIES_HEADER)] {
+		for _, c := range strings.Split(value, ",") {
+			if strings.TrimSpace(c) == capability {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// supportsChunkedUpload - whether the blobber takes the shard of an upload in
+// parts. Blobbers are asked once, through the connection details. The ones
+// not telling, or not answering, get the whole shard in one request.
+func supportsChunkedUpload(ctx context.Context, blobber *blockchain.StorageNode, allocationID string, connectionID string) bool {
+	chunkedUploadsMutex.Lock()
+	chunked, ok := chunkedUploads[blobber.ID]
+	chunkedUploadsMutex.Unlock()
+	if ok {
+		return chunked
+	}
+	// The connection is new, the response tells only the capabilities
+	getConnectionDetails(ctx, blobber, allocationID, connectionID)
+	chunkedUploadsMutex.Lock()
+	defer chunkedUploadsMutex.Unlock()
+	return chunkedUploads[blobber.ID]
+}
+
+// getChunkedUploadMask - the blobbers of the mask taking uploads in parts
+func (a *Allocation) getChunkedUploadMask(ctx context.Context, mask uint32, connectionID string) uint32 {
+	chunkedMask := uint32(0)
+	mutex := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	pos := 0
+	for i := mask; i != 0; i &= ^(1 << uint32(pos)) {
+		pos = bits.TrailingZeros32(i)
+		wg.Add(1)
+		go func(pos int) {
+			defer wg.Done()
+			if supportsChunkedUpload(ctx, a.Blobbers[pos], a.ID, connectionID) {
+				mutex.Lock()
+				chunkedMask |= (1 << uint32(pos))
+				mutex.Unlock()
+			}
+		}(pos)
+	}
+	wg.Wait()
+	return chunkedMask
+}
+
+// deleteConnection - drops the shards uploaded in the connection and not
+// committed from the blobber
+func deleteConnection(ctx context.Context, blobber *blockchain.StorageNode, allocationID string, connectionID string) error {
+	httpreq, err := zboxutil.NewConnectionDeleteRequest(blobber.Baseurl, allocationID, connectionID)
+	if err != nil {
+		return err
+	}
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+	return zboxutil.HttpDo(ctx, cncl, httpreq, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("Connection delete error response: Status: %d - %s ", resp.StatusCode, string(respBody))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,258 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0chain/gosdk/zboxcore/fileref"
+	"github.com/0chain/gosdk/zboxcore/zboxutil"
+)
+
+// uploadCounter - counts the upload requests to each blobber
+type uploadCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func (c *uploadCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if zboxutil.GetOperation(req) == zboxutil.OperationUpload {
+		c.mutex.Lock()
+		c.counts[req.URL.Host]++
+		c.mutex.Unlock()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (c *uploadCounter) count(blobberURL string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.counts[hostOf(blobberURL)]
+}
+
+func hostOf(blobberURL string) string {
+	u, err := url.Parse(blobberURL)
+	if err != nil {
+		return blobberURL
+	}
+	return u.Host
+}
+
+// testStatus - tells the end of an operation, calling inProgress with the
+// progress
+type testStatus struct {
+	inProgress func(completedBytes int)
+	done       chan error
+}
+
+func (s *testStatus) Started(allocationId, filePath string, op int, totalBytes int) {}
+
+func (s *testStatus) InProgress(allocationId, filePath string, op int, completedBytes int) {
+	if s.inProgress != nil {
+		s.inProgress(completedBytes)
+	}
+}
+
+func (s *testStatus) Error(allocationID string, filePath string, op int, err error) {
+	s.done <- err
+}
+
+func (s *testStatus) Completed(allocationId, filePath string, filename string, mimetype string, size int, op int) {
+	s.done <- nil
+}
+
+func (s *testStatus) wait(t *testing.T) error {
+	select {
+	case err := <-s.done:
+		return err
+	case <-time.After(30 * time.Second):
+		t.Fatal("operation timed out")
+	}
+	return nil
+}
+
+func TestResumeUpload(t *testing.T) {
+	ft := newFaultTestWith(t, "resume_upload", true)
+	defer ft.Close()
+	SetUploadStateDir(ft.dir)
+	defer SetUploadStateDir("")
+	counter := &uploadCounter{counts: make(map[string]int)}
+	zboxutil.SetHTTPClient(&http.Client{Transport: counter})
+
+	// 3 requests of chunks and the final one to each blobber
+	chunks := 3 * uploadChunksPerRequest
+	content := make([]byte, chunks*fileref.CHUNK_SIZE*int(ft.a.DataShards))
+	rand.Read(content)
+	localPath := filepath.Join(ft.dir, "resumed.bin")
+	err := ioutil.WriteFile(localPath, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the allocation past the first request keeps the upload
+	chunkBytes := fileref.CHUNK_SIZE * (ft.a.DataShards + ft.a.ParityShards)
+	status := &testStatus{done: make(chan error, 1)}
+	status.inProgress = func(completedBytes int) {
+		if completedBytes >= (uploadChunksPerRequest+4)*chunkBytes {
+			ft.a.ctxCancelF()
+		}
+	}
+	err = ft.a.UploadFile(localPath, "/", status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = status.wait(t); err == nil {
+		t.Fatal("upload completed with the allocation closed")
+	}
+	state, err := loadUploadState(ft.a.ID, "/resumed.bin")
+	if err != nil {
+		t.Fatalf("upload state: %v", err)
+	}
+	for _, blobber := range ft.a.Blobbers {
+		if state.Acknowledged[blobber.ID] != uploadChunksPerRequest-1 {
+			t.Fatalf("blobber %s acknowledged chunk %d", blobber.Baseurl, state.Acknowledged[blobber.ID])
+		}
+	}
+
+	a := &Allocation{}
+	err = json.Unmarshal(ft.network.AllocationJSON(2), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.InitAllocation()
+	ft.a = a
+	counter.counts = make(map[string]int)
+	status = &testStatus{done: make(chan error, 1)}
+	err = a.ResumeUpload("/resumed.bin", status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = status.wait(t); err != nil {
+		t.Fatalf("resumed upload: %v", err)
+	}
+	for _, blobber := range a.Blobbers {
+		if n := counter.count(blobber.Baseurl); n != 3 {
+			t.Errorf("blobber %s was sent %d upload requests, expected 3", blobber.Baseurl, n)
+		}
+	}
+	if _, err = loadUploadState(a.ID, "/resumed.bin"); err == nil {
+		t.Error("completed upload left its state")
+	}
+
+	data, err := ft.downloadFrom(t, "/resumed.bin", "resumed_download.bin")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("resumed upload content differs")
+	}
+}
+
+// haltingTransport - drains the upload requests to the blobber at host and
+// calls halt instead of sending them, as if the process stopped before the
+// blobber got the whole shard
+type haltingTransport struct {
+	host string
+	halt func()
+}
+
+func (h *haltingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if zboxutil.GetOperation(req) == zboxutil.OperationUpload && req.URL.Host == h.host {
+		io.Copy(ioutil.Discard, req.Body)
+		req.Body.Close()
+		h.halt()
+		return nil, context.Canceled
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestResumeUploadSingleRequest(t *testing.T) {
+	ft := newFaultTest(t, "resume_upload_single_request")
+	defer ft.Close()
+	SetUploadStateDir(ft.dir)
+	defer SetUploadStateDir("")
+
+	content := make([]byte, 8*fileref.CHUNK_SIZE*int(ft.a.DataShards))
+	rand.Read(content)
+	localPath := filepath.Join(ft.dir, "resumed.bin")
+	err := ioutil.WriteFile(localPath, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The allocation is closed once the other blobbers hold the upload
+	halted := ft.a.Blobbers[2]
+	zboxutil.SetHTTPClient(&http.Client{Transport: &haltingTransport{
+		host: hostOf(halted.Baseurl),
+		halt: func() {
+			for i := 0; i < 1000; i++ {
+				state, err := loadUploadState(ft.a.ID, "/resumed.bin")
+				if err == nil && len(state.Uploaded) == 2 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			ft.a.ctxCancelF()
+		},
+	}})
+	status := &testStatus{done: make(chan error, 1)}
+	err = ft.a.UploadFile(localPath, "/", status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = status.wait(t); err == nil {
+		t.Fatal("upload completed with the allocation closed")
+	}
+	state, err := loadUploadState(ft.a.ID, "/resumed.bin")
+	if err != nil {
+		t.Fatalf("upload state: %v", err)
+	}
+	if len(state.Uploaded) != 2 || state.Uploaded[halted.ID] != nil || len(state.Acknowledged) != 0 {
+		t.Fatalf("upload state uploaded %d, acknowledged %d", len(state.Uploaded), len(state.Acknowledged))
+	}
+
+	a := &Allocation{}
+	err = json.Unmarshal(ft.network.AllocationJSON(2), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.InitAllocation()
+	ft.a = a
+	counter := &uploadCounter{counts: make(map[string]int)}
+	zboxutil.SetHTTPClient(&http.Client{Transport: counter})
+	status = &testStatus{done: make(chan error, 1)}
+	err = a.ResumeUpload("/resumed.bin", status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = status.wait(t); err != nil {
+		t.Fatalf("resumed upload: %v", err)
+	}
+	// Only the blobber without the whole shard gets it again, in one request
+	for _, blobber := range a.Blobbers {
+		expected := 0
+		if blobber.ID == halted.ID {
+			expected = 1
+		}
+		if n := counter.count(blobber.Baseurl); n != expected {
+			t.Errorf("blobber %s was sent %d upload requests, expected %d", blobber.Baseurl, n, expected)
+		}
+	}
+
+	data, err := ft.downloadFrom(t, "/resumed.bin", "resumed_download.bin")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("resumed upload content differs")
+	}
+}
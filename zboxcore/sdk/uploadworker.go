@@ -1,6 +1,7 @@
 package sdk
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha1"
@@ -16,7 +17,9 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
+	"github.com/0chain/gosdk/core/common"
 	"github.com/0chain/gosdk/core/util"
 	"github.com/0chain/gosdk/zboxcore/allocationchange"
 	"github.com/0chain/gosdk/zboxcore/blockchain"
@@ -57,6 +60,12 @@ type uploadFormData struct {
 	MimeType            string `json:"mimetype"`
 	CustomMeta          string `json:"custom_meta,omitempty"`
 	EncryptedKey        string `json:"encrypted_key,omitempty"`
+	// Only to the blobbers taking the shard in parts: index of the last shard
+	// chunk sent in the request, at the byte offset UploadOffset of the shard.
+	// The final request completes the upload.
+	ChunkIndex   int64 `json:"chunk_index,omitempty"`
+	UploadOffset int64 `json:"upload_offset,omitempty"`
+	IsFinal      bool  `json:"is_final,omitempty"`
 }
 
 type uploadResult struct {
@@ -64,11 +73,16 @@ type uploadResult struct {
 	ShardSize  int64  `json:"size"`
 	Hash       string `json:"content_hash,omitempty"`
 	MerkleRoot string `json:"merkle_root,omitempty"`
+	// Bytes of the shard the blobber holds after the request
+	UploadLength int64 `json:"upload_length,omitempty"`
 }
 
 type UploadRequest struct {
 	filepath        string
 	thumbnailpath   string
+	fileReader      io.Reader
+	thumbnailReader io.Reader
+	isStream        bool
 	remotefilepath  string
 	statusCallback  StatusCallback
 	fileHash        hash.Hash
@@ -83,210 +97,406 @@ type UploadRequest struct {
 	uploadDataCh    []chan []byte
 	uploadThumbCh   []chan []byte
 	isRepair        bool
+	// Only the hashes of the shards are computed, nothing is sent
+	hashOnly        bool
 	isUpdate        bool
 	connectionID    string
+	ctx             context.Context
+	ctxCncl         context.CancelFunc
 	datashards      int
 	parityshards    int
 	uploadMask      uint32
+	updateMask      uint32
 	isEncrypted 	bool
 	encscheme 		encryption.EncryptionScheme
+	state           *uploadState
+	resumed         map[int]*fileref.FileRef
+	startChunks     map[int]int64
+	// Blobbers taking the shard in parts
+	chunkedMask     uint32
+	// Done when a part of the push failed, pushErr telling the first failure
+	pushCtx         context.Context
+	pushCncl        context.CancelFunc
+	pushErr         error
+	mutex           sync.Mutex
 	Consensus
 }
 
-func (req *UploadRequest) prepareUpload(a *Allocation, blobber *blockchain.StorageNode, file *fileref.FileRef, uploadCh chan []byte, uploadThumbCh chan []byte, wg *sync.WaitGroup) {
-	bodyReader, bodyWriter := io.Pipe()
-	formWriter := multipart.NewWriter(bodyWriter)
-	httpreq, _ := zboxutil.NewUploadRequest(blobber.Baseurl, a.ID, bodyReader, req.isUpdate)
-	//timeout := time.Duration(int64(math.Max(10, float64(obj.file.Size)/(CHUNK_SIZE*float64(len(obj.blobbers)/2)))))
-	//ctx, cncl := context.WithTimeout(context.Background(), (time.Second * timeout))
+var errUploadAborted = common.NewError("upload_aborted", "Upload aborted")
 
-	httpreq.Header.Add("Content-Type", formWriter.FormDataContentType())
-	var formData uploadFormData
-	shardSize := (req.filemeta.Size + int64(a.DataShards) - 1) / int64(a.DataShards)
-	chunkSizeWithHeader := int64(fileref.CHUNK_SIZE)
-	if req.isEncrypted {
-		chunkSizeWithHeader -= 16
-		chunkSizeWithHeader -= 2 * 1024
+// uploadChunksPerRequest - shard chunks sent in one upload request to a
+// blobber taking the shard in parts. The blobber acknowledges the chunks of
+// every request, so that an interrupted upload resumes after the last
+// acknowledged chunk.
+const uploadChunksPerRequest = 16
+
+// prepareUpload - sends the shard read from uploadCh to the blobber. Blobbers
+// taking the shard in parts get it in requests of uploadChunksPerRequest
+// chunks, the chunks before startChunk are held by the blobber from an
+// interrupted attempt and only hashed. The others get the whole shard
+// streamed in one request.
+func (req *UploadRequest) prepareUpload(a *Allocation, blobber *blockchain.StorageNode, isUpdate bool, file *fileref.FileRef, chunked bool, startChunk int64, uploadCh chan []byte, uploadThumbCh chan []byte, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var stream *shardStream
+	if !chunked && !req.hashOnly {
+		stream = req.startShardStream(a, blobber, isUpdate, file.Name)
 	}
-	chunksPerShard := (shardSize + chunkSizeWithHeader - 1) / chunkSizeWithHeader
-	if req.isEncrypted {
-		shardSize += chunksPerShard * (16 + (2 *1024))
+	formData := uploadFormData{
+		ConnectionID: req.connectionID,
+		Filename:     file.Name,
+		Path:         file.Path,
 	}
-	thumbnailSize := int64(0)
-	remaining := shardSize
-	go func() {
-		fileMerkleRoot := ""
-		fileContentHash := ""
-		thumbContentHash := ""
-		fileField, err := formWriter.CreateFormFile("uploadFile", file.Name)
-		if err != nil {
-			Logger.Error("Create form failed: ", err)
-			bodyWriter.CloseWithError(err)
-			// Just read the data to unblock
-			for remaining > 0 {
-				dataBytes := <-uploadCh
-				remaining = remaining - int64(len(dataBytes))
-			}
-			_ = <-uploadCh
+	// Setup file hash compute
+	h := sha1.New()
+	merkleHashes := make([]hash.Hash, 1024)
+	merkleLeaves := make([]util.Hashable, 1024)
+	for idx := range merkleHashes {
+		merkleHashes[idx] = sha3.New256()
+	}
+	shardSize := int64(0)
+	remaining := int64(0)
+	if !req.isStream {
+		// Stream size is known only at the end, count the shard bytes instead
+		remaining = req.getShardSize(a, req.filemeta.Size)
+	}
+	chunkIndex := int64(0)
+	batch := &bytes.Buffer{}
+	batchChunks := 0
+	var err error
+	// Read the data. A blobber failed keeps reading, not to hold up the others.
+	for remaining > 0 || req.isStream {
+		dataBytes, ok := req.receiveShard(uploadCh)
+		if !ok {
+			// Upload aborted
+			stream.abort()
 			return
 		}
-		// Setup file hash compute
-		h := sha1.New()
-		//merkleHash := sha3.New256()
-		hWr := io.MultiWriter(h)
-		merkleHashes := make([]hash.Hash, 1024)
-		merkleLeaves := make([]util.Hashable, 1024)
-		for idx := range merkleHashes {
-			merkleHashes[idx] = sha3.New256()
-		}
-		// Read the data
-		for remaining > 0 {
-			dataBytes, ok := <-uploadCh
-			if !ok {
-				return
+		if dataBytes == nil {
+			// End of the stream. File hash is ready by now
+			break
+		}
+		h.Write(dataBytes)
+		merkleChunkSize := 64
+		for i := 0; i < len(dataBytes); i += merkleChunkSize {
+			end := i + merkleChunkSize
+			if end > len(dataBytes) {
+				end = len(dataBytes)
+			}
+			offset := i / merkleChunkSize
+			merkleHashes[offset].Write(dataBytes[i:end])
+		}
+		if stream != nil {
+			stream.write(dataBytes)
+		} else if chunkIndex >= startChunk && err == nil && !req.hashOnly {
+			if batchChunks == 0 {
+				formData.UploadOffset = shardSize
 			}
-			fileField.Write(dataBytes)
-			hWr.Write(dataBytes)
-			merkleChunkSize := 64
-			for i := 0; i < len(dataBytes); i += merkleChunkSize {
-				end := i + merkleChunkSize
-				if end > len(dataBytes) {
-					end = len(dataBytes)
+			batch.Write(dataBytes)
+			batchChunks++
+			if batchChunks == uploadChunksPerRequest {
+				formData.ChunkIndex = chunkIndex
+				_, err = req.sendUpload(a, blobber, isUpdate, &formData, batch.Bytes(), nil)
+				if err == nil && req.state != nil {
+					req.state.setAcknowledged(blobber.ID, chunkIndex)
 				}
-				offset := i / merkleChunkSize
-				merkleHashes[offset].Write(dataBytes[i:end])
+				batch.Reset()
+				batchChunks = 0
 			}
-			remaining = remaining - int64(len(dataBytes))
 		}
-		for idx := range merkleHashes {
-			merkleLeaves[idx] = util.NewStringHashable(hex.EncodeToString(merkleHashes[idx].Sum(nil)))
+		shardSize += int64(len(dataBytes))
+		remaining = remaining - int64(len(dataBytes))
+		chunkIndex++
+	}
+	for idx := range merkleHashes {
+		merkleLeaves[idx] = util.NewStringHashable(hex.EncodeToString(merkleHashes[idx].Sum(nil)))
+	}
+	var mt util.MerkleTreeI = &util.MerkleTree{}
+	mt.ComputeTree(merkleLeaves)
+	if !req.isRepair && !req.isStream {
+		// Wait for file hash to be ready
+		if _, ok := req.receiveShard(uploadCh); !ok {
+			stream.abort()
+			return
+		}
+	}
+	var thumbnail []byte
+	thumbnailSize := int64(0)
+	thumbContentHash := ""
+	if req.isThumbnailUpload() {
+		thumbnailSize = req.getShardSize(a, req.filemeta.ThumbnailSize)
+		thumbBuf := &bytes.Buffer{}
+		for int64(thumbBuf.Len()) < thumbnailSize {
+			dataBytes, ok := req.receiveShard(uploadThumbCh)
+			if !ok {
+				// Upload aborted
+				stream.abort()
+				return
+			}
+			thumbBuf.Write(dataBytes)
 		}
-		var mt util.MerkleTreeI = &util.MerkleTree{}
-		mt.ComputeTree(merkleLeaves)
 		if !req.isRepair {
 			// Wait for file hash to be ready
-			// Logger.Debug("Waiting for file hash....")
-			_ = <-uploadCh
-			// Logger.Debug("File Hash ready", obj.file.Hash)
-		}
-		fileContentHash = hex.EncodeToString(h.Sum(nil))
-		fileMerkleRoot = mt.GetRoot()
-
-		if len(req.thumbnailpath) > 0 {
-			thumbnailSize = (req.filemeta.ThumbnailSize + int64(a.DataShards) - 1) / int64(a.DataShards)
-			chunkSizeWithHeader := int64(fileref.CHUNK_SIZE)
-			if req.isEncrypted {
-				chunkSizeWithHeader -= 16
-				chunkSizeWithHeader -= 2 * 1024
-			}
-			chunksPerShard := (thumbnailSize + chunkSizeWithHeader - 1) / chunkSizeWithHeader
-			if req.isEncrypted {
-				thumbnailSize += chunksPerShard * (16 + (2 *1024))
-			}
-			remaining := thumbnailSize
-
-			fileField, err := formWriter.CreateFormFile("uploadThumbnailFile", file.Name+".thumb")
-			if err != nil {
-				Logger.Error("Create form failed: ", err)
+			if _, ok := req.receiveShard(uploadThumbCh); !ok {
+				stream.abort()
 				return
 			}
-			// Setup file hash compute
-			h := sha1.New()
-			hWr := io.MultiWriter(h)
-			// Read the data
-			for remaining > 0 {
-				dataBytes, ok := <-uploadThumbCh
-				if !ok {
-					return
-				}
-				fileField.Write(dataBytes)
-				hWr.Write(dataBytes)
-				remaining = remaining - int64(len(dataBytes))
-			}
-			if !req.isRepair {
-				// Wait for file hash to be ready
-				// Logger.Debug("Waiting for file hash....")
-				_ = <-uploadThumbCh
-				// Logger.Debug("File Hash ready", obj.file.Hash)
-			}
-			thumbContentHash = hex.EncodeToString(h.Sum(nil))
-		}
-
-		formData = uploadFormData{
-			ConnectionID:        req.connectionID,
-			Filename:            file.Name,
-			Path:                file.Path,
-			ActualHash:          req.filemeta.Hash,
-			ActualSize:          req.filemeta.Size,
-			ActualThumbnailHash: req.filemeta.ThumbnailHash,
-			ActualThumbnailSize: req.filemeta.ThumbnailSize,
-			MimeType:            req.filemeta.MimeType,
-			Hash:                fileContentHash,
-			ThumbnailHash:       thumbContentHash,
-			MerkleRoot:          fileMerkleRoot,
 		}
-		if req.isEncrypted {
-			formData.EncryptedKey = req.encscheme.GetEncryptedKey()
-		}
-		_ = formWriter.WriteField("connection_id", req.connectionID)
-		var metaData []byte
-		metaData, err = json.Marshal(formData)
-		// Logger.Debug("Upload with",string(metaData))
-		if err == nil {
-			if req.isUpdate {
-				_ = formWriter.WriteField("updateMeta", string(metaData))
-			} else {
-				_ = formWriter.WriteField("uploadMeta", string(metaData))
-			}
+		thumbnail = thumbBuf.Bytes()
+		thumbHash := sha1.Sum(thumbnail)
+		thumbContentHash = hex.EncodeToString(thumbHash[:])
+	}
+	if err != nil {
+		return
+	}
+	if req.hashOnly {
+		file.ContentHash = hex.EncodeToString(h.Sum(nil))
+		file.MerkleRoot = mt.GetRoot()
+		req.mutex.Lock()
+		req.consensus++
+		req.mutex.Unlock()
+		return
+	}
 
-			bodyWriter.CloseWithError(formWriter.Close())
-		}
-	}()
-	_ = zboxutil.HttpDo(a.ctx, a.ctxCancelF, httpreq, func(resp *http.Response, err error) error {
-		if err != nil {
-			Logger.Error("Upload : ", err)
-			return err
+	formData.ActualHash = req.filemeta.Hash
+	formData.ActualSize = req.filemeta.Size
+	formData.ActualThumbnailHash = req.filemeta.ThumbnailHash
+	formData.ActualThumbnailSize = req.filemeta.ThumbnailSize
+	formData.MimeType = req.filemeta.MimeType
+	formData.Hash = hex.EncodeToString(h.Sum(nil))
+	formData.ThumbnailHash = thumbContentHash
+	formData.MerkleRoot = mt.GetRoot()
+	if req.isEncrypted {
+		formData.EncryptedKey = req.encscheme.GetEncryptedKey()
+	}
+	var r *uploadResult
+	if stream != nil {
+		r, err = stream.finish(req.connectionID, isUpdate, &formData, thumbnail)
+	} else {
+		// The last request carries the rest of the shard, the thumbnail and
+		// the hashes of the whole file
+		if batchChunks == 0 {
+			formData.UploadOffset = shardSize
 		}
-		defer resp.Body.Close()
+		formData.ChunkIndex = chunkIndex - 1
+		formData.IsFinal = true
+		r, err = req.sendUpload(a, blobber, isUpdate, &formData, batch.Bytes(), thumbnail)
+	}
+	if err != nil {
+		return
+	}
+	if r.Filename != formData.Filename || r.ShardSize != shardSize ||
+		r.Hash != formData.Hash || r.MerkleRoot != formData.MerkleRoot {
+		Logger.Error(blobber.Baseurl, " Unexpected upload response data ", r)
+		return
+	}
+	req.mutex.Lock()
+	req.consensus++
+	req.mutex.Unlock()
+	Logger.Info(blobber.Baseurl, formData.Path, " uploaded")
+	file.MerkleRoot = formData.MerkleRoot
+	file.ContentHash = formData.Hash
+	file.ThumbnailHash = formData.ThumbnailHash
+	file.ThumbnailSize = thumbnailSize
+	file.Size = shardSize
+	file.Path = formData.Path
+	file.ActualFileHash = formData.ActualHash
+	file.ActualFileSize = formData.ActualSize
+	file.ActualThumbnailHash = formData.ActualThumbnailHash
+	file.ActualThumbnailSize = formData.ActualThumbnailSize
+	file.EncryptedKey = formData.EncryptedKey
+	file.CalculateHash()
+	if req.state != nil {
+		req.state.setUploaded(blobber.ID, file)
+	}
+}
 
-		respbody, err := ioutil.ReadAll(resp.Body)
+// sendUpload - sends the part of the shard starting at formData.UploadOffset
+// to the blobber, along with the thumbnail shard on the final request
+func (req *UploadRequest) sendUpload(a *Allocation, blobber *blockchain.StorageNode, isUpdate bool, formData *uploadFormData, data []byte, thumbnail []byte) (*uploadResult, error) {
+	body := &bytes.Buffer{}
+	formWriter := multipart.NewWriter(body)
+	fileField, err := formWriter.CreateFormFile("uploadFile", formData.Filename)
+	if err != nil {
+		return nil, err
+	}
+	fileField.Write(data)
+	err = writeUploadMeta(formWriter, req.connectionID, isUpdate, formData, thumbnail)
+	if err != nil {
+		return nil, err
+	}
+	httpreq, err := zboxutil.NewUploadRequest(blobber.Baseurl, a.ID, body, isUpdate)
+	if err != nil {
+		return nil, err
+	}
+	httpreq.Header.Add("Content-Type", formWriter.FormDataContentType())
+	var r uploadResult
+	err = zboxutil.HttpDo(req.ctx, a.ctxCancelF, httpreq, func(resp *http.Response, err error) error {
+		err = readUploadResult(blobber, resp, err, &r)
 		if err != nil {
-			Logger.Error("Error: Resp ", err)
 			return err
 		}
-		if resp.StatusCode != http.StatusOK {
-			Logger.Error(blobber.Baseurl, " Upload error response: ", resp.StatusCode, string(respbody))
+		if r.UploadLength != formData.UploadOffset+int64(len(data)) {
+			err = fmt.Errorf("Unexpected upload length %d, expected %d", r.UploadLength, formData.UploadOffset+int64(len(data)))
+			Logger.Error(blobber.Baseurl, " ", err)
 			return err
 		}
-		var r uploadResult
-		err = json.Unmarshal(respbody, &r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// shardStream - the upload request to a blobber taking the whole shard in one
+// request, sending the shard as it is read
+type shardStream struct {
+	bodyWriter *io.PipeWriter
+	formWriter *multipart.Writer
+	fileField  io.Writer
+	// First error writing the body, the rest of the shard is dropped
+	err    error
+	done   chan struct{}
+	result uploadResult
+	// Error of the request
+	reqErr error
+}
+
+// startShardStream - starts the upload request, its body written by write
+// and finish
+func (req *UploadRequest) startShardStream(a *Allocation, blobber *blockchain.StorageNode, isUpdate bool, filename string) *shardStream {
+	bodyReader, bodyWriter := io.Pipe()
+	s := &shardStream{bodyWriter: bodyWriter, formWriter: multipart.NewWriter(bodyWriter), done: make(chan struct{})}
+	httpreq, err := zboxutil.NewUploadRequest(blobber.Baseurl, a.ID, bodyReader, isUpdate)
+	if err != nil {
+		s.err, s.reqErr = err, err
+		close(s.done)
+		return s
+	}
+	httpreq.Header.Add("Content-Type", s.formWriter.FormDataContentType())
+	go func() {
+		defer close(s.done)
+		s.reqErr = zboxutil.HttpDo(req.ctx, a.ctxCancelF, httpreq, func(resp *http.Response, err error) error {
+			return readUploadResult(blobber, resp, err, &s.result)
+		})
+		// A request ended before reading the whole body fails the writes left
+		bodyReader.CloseWithError(io.ErrClosedPipe)
+	}()
+	s.fileField, s.err = s.formWriter.CreateFormFile("uploadFile", filename)
+	return s
+}
+
+func (s *shardStream) write(data []byte) {
+	if s.err == nil {
+		_, s.err = s.fileField.Write(data)
+	}
+}
+
+// finish - ends the body with the thumbnail and the meta of the shard, and
+// waits for the response
+func (s *shardStream) finish(connectionID string, isUpdate bool, formData *uploadFormData, thumbnail []byte) (*uploadResult, error) {
+	if s.err == nil {
+		s.err = writeUploadMeta(s.formWriter, connectionID, isUpdate, formData, thumbnail)
+	}
+	s.bodyWriter.CloseWithError(s.err)
+	<-s.done
+	if s.reqErr != nil {
+		return nil, s.reqErr
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &s.result, nil
+}
+
+// abort - fails the request of an aborted upload. No-op on nil.
+func (s *shardStream) abort() {
+	if s == nil {
+		return
+	}
+	s.bodyWriter.CloseWithError(context.Canceled)
+	<-s.done
+}
+
+// writeUploadMeta - writes the thumbnail, if any, and the meta of the upload
+// after the shard, and closes the form
+func writeUploadMeta(formWriter *multipart.Writer, connectionID string, isUpdate bool, formData *uploadFormData, thumbnail []byte) error {
+	if thumbnail != nil {
+		thumbField, err := formWriter.CreateFormFile("uploadThumbnailFile", formData.Filename+".thumb")
 		if err != nil {
-			Logger.Error(blobber.Baseurl, " Upload response parse error: ", err)
 			return err
 		}
-		if r.Filename != formData.Filename || r.ShardSize != shardSize ||
-			r.Hash != formData.Hash || r.MerkleRoot != formData.MerkleRoot {
-			err = fmt.Errorf(blobber.Baseurl, "Unexpected upload response data", string(respbody))
-			Logger.Error(err)
+		_, err = thumbField.Write(thumbnail)
+		if err != nil {
 			return err
 		}
-		req.consensus++
-		Logger.Info(blobber.Baseurl, formData.Path, " uploaded")
-		file.MerkleRoot = formData.MerkleRoot
-		file.ContentHash = formData.Hash
-		file.ThumbnailHash = formData.ThumbnailHash
-		file.ThumbnailSize = thumbnailSize
-		file.Size = shardSize
-		file.Path = formData.Path
-		file.ActualFileHash = formData.ActualHash
-		file.ActualFileSize = formData.ActualSize
-		file.ActualThumbnailHash = formData.ActualThumbnailHash
-		file.ActualThumbnailSize = formData.ActualThumbnailSize
-		file.EncryptedKey = formData.EncryptedKey
-		file.CalculateHash()
-		return nil
-	})
-	wg.Done()
+	}
+	metaData, err := json.Marshal(formData)
+	if err != nil {
+		return err
+	}
+	_ = formWriter.WriteField("connection_id", connectionID)
+	if isUpdate {
+		_ = formWriter.WriteField("updateMeta", string(metaData))
+	} else {
+		_ = formWriter.WriteField("uploadMeta", string(metaData))
+	}
+	return formWriter.Close()
+}
+
+func readUploadResult(blobber *blockchain.StorageNode, resp *http.Response, err error, r *uploadResult) error {
+	if err != nil {
+		Logger.Error("Upload : ", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	respbody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		Logger.Error("Error: Resp ", err)
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		Logger.Error(blobber.Baseurl, " Upload error response: ", resp.StatusCode, string(respbody))
+		return fmt.Errorf("Upload error response: Status: %d - %s ", resp.StatusCode, string(respbody))
+	}
+	err = json.Unmarshal(respbody, r)
+	if err != nil {
+		Logger.Error(blobber.Baseurl, " Upload response parse error: ", err)
+		return err
+	}
+	return nil
+}
+
+// isUpdateOn - whether the blobber at pos gets an update of the file rather
+// than a new file. Repairs update only the blobbers holding a stale version.
+func (req *UploadRequest) isUpdateOn(pos int) bool {
+	return req.isUpdate || (req.updateMask&(1<<uint32(pos))) != 0
+}
+
+func (req *UploadRequest) isThumbnailUpload() bool {
+	return len(req.thumbnailpath) > 0 || req.thumbnailReader != nil
+}
+
+// getShardSize - bytes sent to each blobber for a content of the given size
+func (req *UploadRequest) getShardSize(a *Allocation, size int64) int64 {
+	shardSize := (size + int64(a.DataShards) - 1) / int64(a.DataShards)
+	chunkSizeWithHeader := req.getChunkSize()
+	chunksPerShard := (shardSize + chunkSizeWithHeader - 1) / chunkSizeWithHeader
+	if req.isEncrypted {
+		shardSize += chunksPerShard * (16 + (2 * 1024))
+	}
+	return shardSize
+}
+
+// getChunkSize - content bytes per shard chunk, after the encryption header
+func (req *UploadRequest) getChunkSize() int64 {
+	chunkSizeWithHeader := int64(fileref.CHUNK_SIZE)
+	if req.isEncrypted {
+		chunkSizeWithHeader -= 16
+		chunkSizeWithHeader -= 2 * 1024
+	}
+	return chunkSizeWithHeader
 }
 
 func (req *UploadRequest) setupUpload(a *Allocation) error {
@@ -319,20 +529,58 @@ func (req *UploadRequest) setupUpload(a *Allocation) error {
 		req.encscheme.InitForEncryption("filetype:audio")
 	}
 	
+	if !req.hashOnly {
+		req.chunkedMask = a.getChunkedUploadMask(req.ctx, req.uploadMask, req.connectionID)
+	}
+	req.pushCtx, req.pushCncl = context.WithCancel(req.ctx)
 	req.wg = &sync.WaitGroup{}
 	req.wg.Add(numUploads)
-	req.consensus = 0
+	// Blobbers holding the upload from an interrupted attempt count as well
+	req.consensus = float32(len(req.resumed))
 
 	// Start upload for each blobber
 	c, pos := 0, 0
 	for i := req.uploadMask; i != 0; i &= ^(1 << uint32(pos)) {
 		pos = bits.TrailingZeros32(i)
-		go req.prepareUpload(a, a.Blobbers[pos], req.file[c], req.uploadDataCh[c], req.uploadThumbCh[c], req.wg)
+		chunked := (req.chunkedMask & (1 << uint32(pos))) != 0
+		go req.prepareUpload(a, a.Blobbers[pos], req.isUpdateOn(pos), req.file[c], chunked, req.startChunks[pos], req.uploadDataCh[c], req.uploadThumbCh[c], req.wg)
 		c++
 	}
 	return nil
 }
 
+// sendShard - sends the shard data to the blobber upload reading ch, unless
+// the push failed
+func (req *UploadRequest) sendShard(ch chan []byte, data []byte) error {
+	select {
+	case ch <- data:
+		return nil
+	case <-req.pushCtx.Done():
+		return errUploadAborted
+	}
+}
+
+// receiveShard - the shard data sent on ch. Not ok once the push failed.
+func (req *UploadRequest) receiveShard(ch chan []byte) ([]byte, bool) {
+	select {
+	case data, ok := <-ch:
+		return data, ok
+	case <-req.pushCtx.Done():
+		return nil, false
+	}
+}
+
+// failPush - ends the push of the shards on the first failure of reading the
+// file or the thumbnail
+func (req *UploadRequest) failPush(err error) {
+	req.mutex.Lock()
+	if req.pushErr == nil {
+		req.pushErr = err
+	}
+	req.mutex.Unlock()
+	req.pushCncl()
+}
+
 func (req *UploadRequest) pushData(data []byte) error {
 	//TODO: Check for optimization
 	n := int64(math.Min(float64(req.remaining), float64(len(data))))
@@ -367,12 +615,55 @@ func (req *UploadRequest) pushData(data []byte) error {
 	}
 	for i := req.uploadMask; i != 0; i &= ^(1 << uint32(pos)) {
 		pos = bits.TrailingZeros32(i)
-		req.uploadDataCh[c] <- shards[pos]
+		err = req.sendShard(req.uploadDataCh[c], shards[pos])
+		if err != nil {
+			return err
+		}
 		c++
 	}
 	return nil
 }
 
+// pushStream - pushes a stream of unknown length till EOF. Only the last
+// chunk is padded, just enough to split it evenly across the data shards.
+func (req *UploadRequest) pushStream(a *Allocation, reader io.Reader) error {
+	chunkSize := req.getChunkSize() * int64(a.DataShards)
+	size := int64(0)
+	sent := int(0)
+	for {
+		if err := req.ctx.Err(); err != nil {
+			return fmt.Errorf("Upload canceled: %s", err.Error())
+		}
+		b1 := make([]byte, chunkSize)
+		n, err := io.ReadFull(reader, b1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("Read failed: %s", err.Error())
+		}
+		if int64(n) < chunkSize {
+			perShard := (int64(n) + int64(a.DataShards) - 1) / int64(a.DataShards)
+			b1 = b1[:perShard*int64(a.DataShards)]
+		}
+		size += int64(n)
+		req.remaining += int64(n)
+		err = req.pushData(b1)
+		if err != nil {
+			return fmt.Errorf("Push error: %s", err.Error())
+		}
+		sent = sent + (len(b1)/a.DataShards)*(a.DataShards+a.ParityShards)
+		if req.statusCallback != nil {
+			req.statusCallback.InProgress(a.ID, req.remotefilepath, OpUpload, sent)
+		}
+		if int64(n) < chunkSize {
+			break
+		}
+	}
+	req.filemeta.Size = size
+	return nil
+}
+
 func (req *UploadRequest) completePush() error {
 	if !req.isRepair {
 		req.filemeta.Hash = hex.EncodeToString(req.fileHash.Sum(nil))
@@ -380,7 +671,17 @@ func (req *UploadRequest) completePush() error {
 		c, pos := 0, 0
 		for i := req.uploadMask; i != 0; i &= ^(1 << uint32(pos)) {
 			pos = bits.TrailingZeros32(i)
-			req.uploadDataCh[c] <- []byte("done")
+			var err error
+			if req.isStream {
+				// nil marks the end of the stream
+				err = req.sendShard(req.uploadDataCh[c], nil)
+			} else {
+				err = req.sendShard(req.uploadDataCh[c], []byte("done"))
+			}
+			if err != nil {
+				req.wg.Wait()
+				return err
+			}
 			c++
 		}
 	}
@@ -392,35 +693,141 @@ func (req *UploadRequest) completePush() error {
 }
 
 func (req *UploadRequest) processUpload(ctx context.Context, a *Allocation) {
-	var inFile *os.File
-	inFile, err := os.Open(req.filepath)
-	if err != nil && req.statusCallback != nil {
-		req.statusCallback.Error(a.ID, req.filepath, OpUpload, fmt.Errorf("Open file failed: %s", err.Error()))
+	defer a.untrackUpload(req)
+	defer req.ctxCncl()
+	perShard, ok := req.pushUpload(ctx, a)
+	// A commit once started is not interrupted, the blobbers could be left
+	// with different versions of the file
+	if req.ctx.Err() != nil {
+		req.abortUpload(a)
 		return
 	}
-	defer inFile.Close()
-	mimetype, err := zboxutil.GetFileContentType(inFile)
-	if err != nil && req.statusCallback != nil {
-		req.statusCallback.Error(a.ID, req.filepath, OpUpload, fmt.Errorf("Error detecting the mimetype: %s", err.Error()))
+	if !ok {
+		req.dropUpload(a)
 		return
 	}
+	req.commitUpload(a, perShard)
+}
+
+// abortUpload - drops the upload and reports the cancel. The upload is kept
+// when the allocation is closed rather than the upload canceled, to resume
+// the upload later.
+func (req *UploadRequest) abortUpload(a *Allocation) {
+	if a.ctx.Err() == nil {
+		req.dropUpload(a)
+	}
+	reportCancel(req.statusCallback, a.ID, req.remotefilepath, OpUpload)
+}
+
+// dropUpload - drops the shards sent in the connection from the blobbers and
+// the upload state of an upload that can't be resumed
+func (req *UploadRequest) dropUpload(a *Allocation) {
+	if req.state != nil {
+		req.state.remove()
+	}
+	mask := req.uploadMask
+	for pos := range req.resumed {
+		mask |= (1 << uint32(pos))
+	}
+	wg := &sync.WaitGroup{}
+	pos := 0
+	for i := mask; i != 0; i &= ^(1 << uint32(pos)) {
+		pos = bits.TrailingZeros32(i)
+		wg.Add(1)
+		go func(blobber *blockchain.StorageNode) {
+			defer wg.Done()
+			err := deleteConnection(a.ctx, blobber, a.ID, req.connectionID)
+			if err != nil {
+				Logger.Error(blobber.Baseurl, " dropping the upload failed: ", err)
+			}
+		}(a.Blobbers[pos])
+	}
+	wg.Wait()
+}
+
+// pushUpload - sends the shards to the blobbers in the upload mask, without
+// committing them. Returns the bytes per shard and whether the push went
+// through.
+func (req *UploadRequest) pushUpload(ctx context.Context, a *Allocation) (int64, bool) {
+	if req.ctx.Err() != nil {
+		// Canceled while queued
+		return 0, false
+	}
+	var inFile io.Reader
+	var mimetype string
+	var err error
+	if req.isRepair {
+		// The content is the stored one
+		mimetype = req.filemeta.MimeType
+		inFile = req.fileReader
+	} else if req.fileReader != nil {
+		bufReader := bufio.NewReader(req.fileReader)
+		mimetype, err = zboxutil.GetReaderContentType(bufReader)
+		inFile = bufReader
+	} else {
+		var file *os.File
+		file, err = os.Open(req.filepath)
+		if err != nil {
+			if req.statusCallback != nil {
+				req.statusCallback.Error(a.ID, req.remotefilepath, OpUpload, fmt.Errorf("Open file failed: %s", err.Error()))
+			}
+			return 0, false
+		}
+		defer file.Close()
+		mimetype, err = zboxutil.GetFileContentType(file)
+		inFile = file
+	}
+	if err != nil {
+		if req.statusCallback != nil {
+			req.statusCallback.Error(a.ID, req.remotefilepath, OpUpload, fmt.Errorf("Error detecting the mimetype: %s", err.Error()))
+		}
+		return 0, false
+	}
 	req.filemeta.MimeType = mimetype
 	err = req.setupUpload(a)
-	if err != nil && req.statusCallback != nil {
-		req.statusCallback.Error(a.ID, req.filepath, OpUpload, fmt.Errorf("setting up of upload failed : %s", err.Error()))
-		return
+	if err != nil {
+		if req.statusCallback != nil {
+			req.statusCallback.Error(a.ID, req.remotefilepath, OpUpload, fmt.Errorf("setting up of upload failed : %s", err.Error()))
+		}
+		return 0, false
 	}
 	size := req.filemeta.Size
 	// Calculate number of bytes per shard.
 	perShard := (size + int64(a.DataShards) - 1) / int64(a.DataShards)
+	if req.state != nil {
+		err = req.state.save()
+		if err != nil {
+			Logger.Error("Saving upload state failed: ", err)
+		}
+	}
+	if req.uploadMask == 0 {
+		// Every blobber acknowledged the upload before it was interrupted
+		return perShard, true
+	}
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	if len(req.thumbnailpath) > 0 {
+	if req.isThumbnailUpload() {
 		wg.Add(1)
 		go req.processThumbnail(a, wg)
 	}
+	defer req.pushCncl()
 	go func() {
 		defer wg.Done()
+		if req.isStream {
+			if req.statusCallback != nil {
+				req.statusCallback.Started(a.ID, req.remotefilepath, OpUpload, -1)
+			}
+			err := req.pushStream(a, inFile)
+			if err != nil {
+				req.failPush(err)
+				return
+			}
+			err = req.completePush()
+			if err != nil {
+				req.failPush(fmt.Errorf("Upload failed: %s", err.Error()))
+			}
+			return
+		}
 		// Pad data to Shards*perShard.
 		padding := make([]byte, (int64(a.DataShards)*perShard)-size)
 		dataReader := io.MultiReader(inFile, bytes.NewBuffer(padding))
@@ -437,16 +844,21 @@ func (req *UploadRequest) processUpload(ctx context.Context, a *Allocation) {
 
 		sent := int(0)
 		for ctr := int64(0); ctr < chunksPerShard; ctr++ {
+			if req.ctx.Err() != nil {
+				// Reported by processUpload
+				req.failPush(req.ctx.Err())
+				return
+			}
 			remaining := int64(math.Min(float64(perShard-(ctr*chunkSizeWithHeader)), float64(chunkSizeWithHeader)))
 			b1 := make([]byte, remaining*int64(a.DataShards))
-			_, err = dataReader.Read(b1)
-			if err != nil && req.statusCallback != nil {
-				req.statusCallback.Error(a.ID, req.filepath, OpUpload, fmt.Errorf("Read failed: %s", err.Error()))
+			_, err = io.ReadFull(dataReader, b1)
+			if err != nil {
+				req.failPush(fmt.Errorf("Read failed: %s", err.Error()))
 				return
 			}
 			err = req.pushData(b1)
 			if err != nil {
-				req.statusCallback.Error(a.ID, req.filepath, OpUpload, fmt.Errorf("Push error: %s", err.Error()))
+				req.failPush(fmt.Errorf("Push error: %s", err.Error()))
 				return
 			}
 			sent = sent + int(remaining*int64(a.DataShards+a.ParityShards))
@@ -455,10 +867,21 @@ func (req *UploadRequest) processUpload(ctx context.Context, a *Allocation) {
 			}
 
 		}
+		if req.isRepair {
+			// The read filling the last chunk may hold back the failed hash
+			// check of the content read back, the next one returns it
+			_, err = io.ReadFull(inFile, make([]byte, 1))
+			if err != io.EOF {
+				if err == nil {
+					err = fmt.Errorf("content is larger than %d bytes", size)
+				}
+				req.failPush(fmt.Errorf("Read failed: %s", err.Error()))
+				return
+			}
+		}
 		err = req.completePush()
-		if err != nil && req.statusCallback != nil {
-			req.statusCallback.Error(a.ID, req.remotefilepath, OpUpload, fmt.Errorf("Upload failed: %s", err.Error()))
-			return
+		if err != nil {
+			req.failPush(fmt.Errorf("Upload failed: %s", err.Error()))
 		}
 	}()
 	wg.Wait()
@@ -470,45 +893,80 @@ func (req *UploadRequest) processUpload(ctx context.Context, a *Allocation) {
 	for _, ch := range req.uploadThumbCh {
 		close(ch)
 	}
+	if req.pushErr != nil {
+		// The failures of a canceled upload are reported by processUpload
+		if req.statusCallback != nil && req.ctx.Err() == nil {
+			req.statusCallback.Error(a.ID, req.remotefilepath, OpUpload, req.pushErr)
+		}
+		return 0, false
+	}
+	if req.isStream {
+		perShard = (req.filemeta.Size + int64(a.DataShards) - 1) / int64(a.DataShards)
+	}
 	Logger.Info("Closed all the channels. Submitting for commit")
+	return perShard, true
+}
+
+// newChange - the change committing the uploaded file on the blobber at pos
+func (req *UploadRequest) newChange(pos int, file *fileref.FileRef) allocationchange.AllocationChange {
+	if req.isUpdateOn(pos) {
+		newChange := &allocationchange.UpdateFileChange{}
+		newChange.NewFile = file
+		newChange.NumBlocks = file.NumBlocks
+		newChange.Operation = allocationchange.UPDATE_OPERATION
+		newChange.Size = file.Size
+		return newChange
+	}
+	newChange := &allocationchange.NewFileChange{}
+	newChange.File = file
+	newChange.NumBlocks = file.NumBlocks
+	newChange.Operation = allocationchange.INSERT_OPERATION
+	newChange.Size = file.Size
+	return newChange
+}
+
+// commitUpload - commits the uploaded file on the blobbers it was uploaded to
+func (req *UploadRequest) commitUpload(a *Allocation, perShard int64) {
+	commitMask := req.uploadMask
+	for pos := range req.resumed {
+		commitMask |= (1 << uint32(pos))
+	}
 	req.consensus = 0
-	wg = &sync.WaitGroup{}
-	wg.Add(bits.OnesCount32(req.uploadMask))
-	commitReqs := make([]*CommitRequest, bits.OnesCount32(req.uploadMask))
-	c, pos := 0, 0
-	for i := req.uploadMask; i != 0; i &= ^(1 << uint32(pos)) {
+	// The commits are retried here as a whole, each request is sent once.
+	// The deadline bounds all the attempts.
+	policy := zboxutil.GetRetryPolicy()
+	ctx, cncl := newCommitContext(policy)
+	defer cncl()
+	commitCtx := zboxutil.WithoutRetries(ctx)
+	wg := &sync.WaitGroup{}
+	wg.Add(bits.OnesCount32(commitMask))
+	commitReqs := make([]*CommitRequest, bits.OnesCount32(commitMask))
+	c, idx, pos := 0, 0, 0
+	for i := commitMask; i != 0; i &= ^(1 << uint32(pos)) {
 		pos = bits.TrailingZeros32(i)
 		//go req.prepareUpload(a, a.Blobbers[pos], req.file[c], req.uploadDataCh[c], req.wg)
+		file, ok := req.resumed[pos]
+		if !ok {
+			file = req.file[c]
+			c++
+		}
 		commitReq := &CommitRequest{}
 		commitReq.allocationID = a.ID
 		commitReq.blobber = a.Blobbers[pos]
-		if req.isUpdate {
-			newChange := &allocationchange.UpdateFileChange{}
-			newChange.NewFile = req.file[c]
-			newChange.NumBlocks = req.file[c].NumBlocks
-			newChange.Operation = allocationchange.UPDATE_OPERATION
-			newChange.Size = req.file[c].Size
-			commitReq.changes = append(commitReq.changes, newChange)
-		} else {
-			newChange := &allocationchange.NewFileChange{}
-			newChange.File = req.file[c]
-			newChange.NumBlocks = req.file[c].NumBlocks
-			newChange.Operation = allocationchange.INSERT_OPERATION
-			newChange.Size = req.file[c].Size
-			commitReq.changes = append(commitReq.changes, newChange)
-		}
+		commitReq.changes = append(commitReq.changes, req.newChange(pos, file))
 
 		commitReq.connectionID = req.connectionID
 		commitReq.wg = wg
-		commitReqs[c] = commitReq
+		commitReq.ctx = commitCtx
+		commitReqs[idx] = commitReq
 		go AddCommitRequest(commitReq)
-		c++
+		idx++
 	}
 	wg.Wait()
 
-	retries := 0
-	req.consensus = 0
-	for retries < 1 && !req.isConsensusOk() {
+	// The commits failed on some blobbers are sent again with the backoff of
+	// the retry policy
+	for retries := 0; ; retries++ {
 		req.consensus = 0
 		failedCommits := make([]*CommitRequest, 0)
 		for _, commitReq := range commitReqs {
@@ -525,16 +983,23 @@ func (req *UploadRequest) processUpload(ctx context.Context, a *Allocation) {
 				Logger.Info("Commit result not set", commitReq.blobber.Baseurl, "Retries ", retries)
 			}
 		}
-		if !req.isConsensusOk() {
-			wg := &sync.WaitGroup{}
-			wg.Add(len(failedCommits))
-			for _, failedCommit := range failedCommits {
-				failedCommit.wg = wg
-				go AddCommitRequest(failedCommit)
-			}
-			wg.Wait()
+		if req.isConsensusOk() || retries >= policy.MaxRetries || ctx.Err() != nil {
+			break
 		}
-		retries++
+		select {
+		case <-ctx.Done():
+		case <-time.After(policy.Backoff(retries)):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg := &sync.WaitGroup{}
+		wg.Add(len(failedCommits))
+		for _, failedCommit := range failedCommits {
+			failedCommit.wg = wg
+			go AddCommitRequest(failedCommit)
+		}
+		wg.Wait()
 	}
 	// for _, commitReq := range commitReqs {
 	// 	if commitReq.result != nil {
@@ -550,12 +1015,18 @@ func (req *UploadRequest) processUpload(ctx context.Context, a *Allocation) {
 	// }
 
 	if !req.isConsensusOk() {
+		if req.state != nil {
+			req.state.remove()
+		}
 		if req.statusCallback != nil {
 			req.statusCallback.Error(a.ID, req.remotefilepath, OpUpload, fmt.Errorf("Upload failed: Commit consensus failed"))
-			return
 		}
+		return
 	}
 
+	if req.state != nil {
+		req.state.remove()
+	}
 	if req.statusCallback != nil {
 		sizeInCallback := int64(float32(perShard) * req.consensus)
 		req.statusCallback.Completed(a.ID, req.remotefilepath, req.filemeta.Name, req.filemeta.MimeType, int(sizeInCallback), OpUpload)
@@ -12,6 +12,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/0chain/gosdk/core/common"
@@ -39,6 +40,14 @@ const LATEST_READ_MARKER = "/v1/readmarker/latest"
 const FILE_META_ENDPOINT = "/v1/file/meta/"
 const FILE_STATS_ENDPOINT = "/v1/file/stats/"
 const OBJECT_TREE_ENDPOINT = "/v1/file/objecttree/"
+const DIR_ENDPOINT = "/v1/dir/"
+
+// UPLOAD_CAPABILITIES_HEADER - the header of the blobber responses listing
+// the upload protocols the blobber supports besides the single request upload.
+// Blobbers listing CHUNKED_UPLOAD_CAPABILITY take a shard in parts and
+// acknowledge each part, so that an interrupted upload can be resumed.
+const UPLOAD_CAPABILITIES_HEADER = "X-Upload-Capabilities"
+const CHUNKED_UPLOAD_CAPABILITY = "chunked"
 
 var transport = &http.Transport{
 	Proxy: http.ProxyFromEnvironment,
@@ -54,6 +63,29 @@ var transport = &http.Transport{
 	MaxIdleConnsPerHost:   100,
 }
 
+var httpClient = &http.Client{Transport: transport}
+var httpClientMutex sync.RWMutex
+
+// SetHTTPClient - sets the client the requests to the blobbers and the
+// sharders are sent with, the default client if nil. Its Transport can add
+// TLS roots, client certificates, a proxy or wrap the requests.
+func SetHTTPClient(client *http.Client) {
+	if client == nil {
+		client = &http.Client{Transport: transport}
+	}
+	httpClientMutex.Lock()
+	defer httpClientMutex.Unlock()
+	httpClient = client
+}
+
+// GetHTTPClient - the client the requests to the blobbers and the sharders are sent
+// with, as set by SetHTTPClient
+func GetHTTPClient() *http.Client {
+	httpClientMutex.RLock()
+	defer httpClientMutex.RUnlock()
+	return httpClient
+}
+
 func NewHTTPRequest(method string, url string, data []byte) (*http.Request, context.Context, context.CancelFunc, error) {
 	req, err := http.NewRequest(method, url, bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
@@ -135,6 +167,28 @@ func NewListRequest(baseUrl, allocation string, path string, auth_token string)
 	return setClientInfo(req, err)
 }
 
+func NewConnectionRequest(baseUrl, allocation string, connectionID string) (*http.Request, error) {
+	return newConnectionRequest(http.MethodGet, baseUrl, allocation, connectionID)
+}
+
+// NewConnectionDeleteRequest - drops the uncommitted changes of the connection
+func NewConnectionDeleteRequest(baseUrl, allocation string, connectionID string) (*http.Request, error) {
+	return newConnectionRequest(http.MethodDelete, baseUrl, allocation, connectionID)
+}
+
+func newConnectionRequest(method string, baseUrl, allocation string, connectionID string) (*http.Request, error) {
+	nurl, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, err
+	}
+	nurl.Path += CONNECTION_ENDPOINT + allocation
+	params := url.Values{}
+	params.Add("connection_id", connectionID)
+	nurl.RawQuery = params.Encode() // Escape Query Parameters
+	req, err := http.NewRequest(method, nurl.String(), nil)
+	return setClientInfo(req, err)
+}
+
 func NewUploadRequest(baseUrl, allocation string, body io.Reader, update bool) (*http.Request, error) {
 	url := fmt.Sprintf("%s%s%s", baseUrl, UPLOAD_ENDPOINT, allocation)
 	var req *http.Request
@@ -159,6 +213,12 @@ func NewCopyRequest(baseUrl, allocation string, body io.Reader) (*http.Request,
 	return setClientInfo(req, err)
 }
 
+func NewCreateDirRequest(baseUrl, allocation string, body io.Reader) (*http.Request, error) {
+	url := fmt.Sprintf("%s%s%s", baseUrl, DIR_ENDPOINT, allocation)
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	return setClientInfo(req, err)
+}
+
 func NewDownloadRequest(baseUrl, allocation string, body io.Reader) (*http.Request, error) {
 	url := fmt.Sprintf("%s%s%s", baseUrl, DOWNLOAD_ENDPOINT, allocation)
 	req, err := http.NewRequest(http.MethodPost, url, body)
@@ -187,7 +247,7 @@ func MakeSCRestAPICall(scAddress string, relativePath string, params map[string]
 		}
 		urlObj.RawQuery = q.Encode()
 		h := sha1.New()
-		client := &http.Client{Transport: transport}
+		client := GetHTTPClient()
 
 		response, err := client.Get(urlObj.String())
 		if err != nil {
@@ -226,16 +286,18 @@ func MakeSCRestAPICall(scAddress string, relativePath string, params map[string]
 	return nil, err
 }
 
+// HttpDo - sends the request to the blobber with the timeouts and retries of
+// the retry policy, see SetRetryPolicy, and passes the response to f. ctx
+// bounds all the attempts.
 func HttpDo(ctx context.Context, cncl context.CancelFunc, req *http.Request, f func(*http.Response, error) error) error {
 	// Run the HTTP request in a goroutine and pass the response to f.
-	client := &http.Client{Transport: transport}
+	client := GetHTTPClient()
 	c := make(chan error, 1)
-	go func() { c <- f(client.Do(req.WithContext(ctx))) }()
+	go func() { c <- doWithRetries(ctx, client, req, f) }()
 	// TODO: Check cncl context required in any case
 	// defer cncl()
 	select {
 	case <-ctx.Done():
-		transport.CancelRequest(req)
 		<-c // Wait for f to return.
 		return ctx.Err()
 	case err := <-c:
@@ -0,0 +1,154 @@
+package zboxutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+type ignoreRule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// IgnoreMatcher - matches slash separated paths against gitignore style
+// patterns. A pattern without a slash matches the name at any depth, one with
+// a slash is relative to the root. "**" matches any number of directories, a
+// trailing slash matches directories only and a leading "!" includes again
+// what an earlier pattern excluded. The last pattern matching a path decides,
+// and nothing under an excluded directory can be included again.
+type IgnoreMatcher struct {
+	rules []*ignoreRule
+}
+
+// NewIgnoreMatcher - parses the patterns, in the format of the lines of a
+// .gitignore file
+func NewIgnoreMatcher(patterns []string) (*IgnoreMatcher, error) {
+	m := &IgnoreMatcher{}
+	for _, pattern := range patterns {
+		rule, err := parseIgnoreRule(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			m.rules = append(m.rules, rule)
+		}
+	}
+	return m, nil
+}
+
+// ReadIgnoreFile - the lines of a .gitignore style file
+func ReadIgnoreFile(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func parseIgnoreRule(pattern string) (*ignoreRule, error) {
+	p := strings.TrimRight(pattern, " \t\r")
+	if len(p) == 0 || strings.HasPrefix(p, "#") {
+		return nil, nil
+	}
+	rule := &ignoreRule{}
+	if strings.HasPrefix(p, "!") {
+		rule.negate = true
+		p = p[1:]
+	} else if strings.HasPrefix(p, "\\!") || strings.HasPrefix(p, "\\#") {
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		rule.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimLeft(p, "/")
+	if len(p) == 0 {
+		return nil, fmt.Errorf("invalid pattern %q", pattern)
+	}
+	if !anchored {
+		rule.segments = append(rule.segments, "**")
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		rule.segments = append(rule.segments, segment)
+	}
+	return rule, nil
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			// A trailing "**" matches what is inside, not the directory itself
+			return len(name) > 0
+		}
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+func (m *IgnoreMatcher) matchSegments(name []string, isDir bool) bool {
+	matched := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.segments, name) {
+			matched = !rule.negate
+		}
+	}
+	return matched
+}
+
+// Match - whether the path, relative to the root of the patterns, is
+// excluded. A leading slash is optional.
+func (m *IgnoreMatcher) Match(relPath string, isDir bool) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+	p := strings.Trim(path.Clean("/"+relPath), "/")
+	if len(p) == 0 {
+		return false
+	}
+	name := strings.Split(p, "/")
+	for i := 1; i < len(name); i++ {
+		if m.matchSegments(name[:i], true) {
+			return true
+		}
+	}
+	return m.matchSegments(name, isDir)
+}
+
+// Empty - whether there is no pattern to match
+func (m *IgnoreMatcher) Empty() bool {
+	return m == nil || len(m.rules) == 0
+}
@@ -0,0 +1,67 @@
+package zboxutil
+
+import (
+	"testing"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	m, err := NewIgnoreMatcher([]string{
+		"# comment",
+		"*.tmp",
+		"node_modules/",
+		"**/.cache/**",
+		"/build",
+		"docs/*.pdf",
+		"*.log",
+		"!keep.log",
+		"\\#notes",
+	})
+	if err != nil {
+		t.Fatalf("NewIgnoreMatcher() failed: %v", err)
+	}
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"/a.tmp", false, true},
+		{"/x/y/a.tmp", false, true},
+		{"/a.tmpx", false, false},
+		{"/node_modules", true, true},
+		{"/src/node_modules", true, true},
+		{"/src/node_modules/lib/index.js", false, true},
+		{"/node_modules", false, false},
+		{"/.cache", true, false},
+		{"/.cache/x", false, true},
+		{"/a/b/.cache/c/d", false, true},
+		{"/build", true, true},
+		{"/build/out.bin", false, true},
+		{"/src/build", true, false},
+		{"/docs/a.pdf", false, true},
+		{"/docs/sub/a.pdf", false, false},
+		{"/x/docs/a.pdf", false, false},
+		{"/err.log", false, true},
+		{"/keep.log", false, false},
+		{"/x/keep.log", false, false},
+		{"/build/keep.log", false, true},
+		{"/#notes", false, true},
+		{"comment", false, false},
+		{"a.tmp", false, true},
+		{"/", true, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.path, tt.isDir); got != tt.ignored {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+}
+
+func TestIgnoreMatcherInvalid(t *testing.T) {
+	if _, err := NewIgnoreMatcher([]string{"[a-"}); err == nil {
+		t.Fatalf("NewIgnoreMatcher() accepted an invalid pattern")
+	}
+	var m *IgnoreMatcher
+	if m.Match("/a", false) {
+		t.Fatalf("Match() of a nil matcher")
+	}
+}
@@ -0,0 +1,277 @@
+package zboxutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations of the requests to the blobbers, told apart by the endpoint
+const (
+	OperationUpload        = "upload"
+	OperationDelete        = "delete"
+	OperationRename        = "rename"
+	OperationCopy          = "copy"
+	OperationList          = "list"
+	OperationReferencePath = "reference_path"
+	OperationConnection    = "connection"
+	OperationCommit        = "commit"
+	OperationDownload      = "download"
+	OperationReadMarker    = "read_marker"
+	OperationFileMeta      = "file_meta"
+	OperationFileStats     = "file_stats"
+	OperationObjectTree    = "object_tree"
+	OperationCreateDir     = "create_dir"
+	OperationOther         = "other"
+)
+
+var operationEndpoints = []struct {
+	endpoint  string
+	operation string
+}{
+	{UPLOAD_ENDPOINT, OperationUpload},
+	{RENAME_ENDPOINT, OperationRename},
+	{COPY_ENDPOINT, OperationCopy},
+	{LIST_ENDPOINT, OperationList},
+	{REFERENCE_ENDPOINT, OperationReferencePath},
+	{CONNECTION_ENDPOINT, OperationConnection},
+	{COMMIT_ENDPOINT, OperationCommit},
+	{DOWNLOAD_ENDPOINT, OperationDownload},
+	{LATEST_READ_MARKER, OperationReadMarker},
+	{FILE_META_ENDPOINT, OperationFileMeta},
+	{FILE_STATS_ENDPOINT, OperationFileStats},
+	{OBJECT_TREE_ENDPOINT, OperationObjectTree},
+	{DIR_ENDPOINT, OperationCreateDir},
+}
+
+// Operations a repeated request has the same effect for, which can be
+// retried whatever the failure
+var idempotentOperations = map[string]bool{
+	OperationList:          true,
+	OperationReferencePath: true,
+	OperationConnection:    true,
+	OperationReadMarker:    true,
+	OperationFileMeta:      true,
+	OperationFileStats:     true,
+	OperationObjectTree:    true,
+}
+
+// RetryPolicy - timeouts and retries of the requests to the blobbers, applied
+// by HttpDo
+type RetryPolicy struct {
+	// Timeout of an attempt by operation, DefaultTimeout for the operations
+	// not in the map. Zero for no timeout.
+	Timeouts       map[string]time.Duration
+	DefaultTimeout time.Duration
+	// Attempts after the first one
+	MaxRetries int
+	// Wait before the first retry, multiplied by BackoffFactor for each
+	// further retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	BackoffFactor  float64
+	// Fraction of the wait, 0 to 1, randomized so that the clients failing
+	// together don't retry together
+	Jitter float64
+	// Classifies the failed attempts, IsRetriable when not set
+	Retriable func(operation string, resp *http.Response, err error) bool
+}
+
+// DefaultRetryPolicy - the timeouts the SDK always had and two retries
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Timeouts: map[string]time.Duration{
+			// The shards are streamed, the size of the file decides
+			OperationUpload: 0,
+			OperationCommit: 60 * time.Second,
+		},
+		DefaultTimeout: 30 * time.Second,
+		MaxRetries:     2,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		BackoffFactor:  2,
+		Jitter:         0.2,
+	}
+}
+
+var retryPolicy = DefaultRetryPolicy()
+var retryPolicyMutex sync.RWMutex
+
+// SetRetryPolicy - sets the policy of the requests to the blobbers, the
+// default policy if nil
+func SetRetryPolicy(policy *RetryPolicy) {
+	if policy == nil {
+		policy = DefaultRetryPolicy()
+	}
+	retryPolicyMutex.Lock()
+	defer retryPolicyMutex.Unlock()
+	retryPolicy = policy
+}
+
+func GetRetryPolicy() *RetryPolicy {
+	retryPolicyMutex.RLock()
+	defer retryPolicyMutex.RUnlock()
+	return retryPolicy
+}
+
+// GetTimeout - timeout of an attempt of the operation
+func (p *RetryPolicy) GetTimeout(operation string) time.Duration {
+	if timeout, ok := p.Timeouts[operation]; ok {
+		return timeout
+	}
+	return p.DefaultTimeout
+}
+
+// Backoff - wait before the retry, counted from 0
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := p.maxBackoff(retry)
+	jitter := math.Max(0, math.Min(1, p.Jitter))
+	backoff -= backoff * jitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+func (p *RetryPolicy) maxBackoff(retry int) float64 {
+	factor := p.BackoffFactor
+	if factor < 1 {
+		factor = 1
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(factor, float64(retry))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	return backoff
+}
+
+// GetDeadline - longest the attempts of the operations and the waits between
+// the retries take. Zero when an attempt has no timeout.
+func (p *RetryPolicy) GetDeadline(operations ...string) time.Duration {
+	attempt := time.Duration(0)
+	for _, operation := range operations {
+		timeout := p.GetTimeout(operation)
+		if timeout <= 0 {
+			return 0
+		}
+		attempt += timeout
+	}
+	deadline := attempt * time.Duration(p.MaxRetries+1)
+	for retry := 0; retry < p.MaxRetries; retry++ {
+		deadline += time.Duration(p.maxBackoff(retry))
+	}
+	return deadline
+}
+
+type noRetriesKey struct{}
+
+// WithoutRetries - a context for the requests the caller retries itself,
+// which HttpDo sends once
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetriesKey{}, true)
+}
+
+func (p *RetryPolicy) isRetriable(operation string, resp *http.Response, err error) bool {
+	if p.Retriable != nil {
+		return p.Retriable(operation, resp, err)
+	}
+	return IsRetriable(operation, resp, err)
+}
+
+// IsRetriable - the default classification of the failed attempts. The
+// requests the blobber may have processed are retried only for the
+// idempotent operations, the others are retried when the blobber couldn't be
+// reached or refused the request.
+func IsRetriable(operation string, resp *http.Response, err error) bool {
+	if err != nil {
+		if isDialError(err) {
+			return true
+		}
+		if !idempotentOperations[operation] {
+			return false
+		}
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return true
+		}
+		var netErr net.Error
+		return errors.As(err, &netErr)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotentOperations[operation]
+	}
+	return false
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// GetOperation - the operation of the request to a blobber
+func GetOperation(req *http.Request) string {
+	for _, e := range operationEndpoints {
+		if !strings.Contains(req.URL.Path, e.endpoint) {
+			continue
+		}
+		if e.operation == OperationUpload && req.Method == http.MethodDelete {
+			return OperationDelete
+		}
+		return e.operation
+	}
+	return OperationOther
+}
+
+// canResend - whether the body of the request can be sent again
+func canResend(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// doWithRetries - sends the request until an attempt doesn't fail in a
+// retriable way or the retries run out, and passes the response of that
+// attempt to f
+func doWithRetries(ctx context.Context, client *http.Client, req *http.Request, f func(*http.Response, error) error) error {
+	policy := GetRetryPolicy()
+	operation := GetOperation(req)
+	maxRetries := policy.MaxRetries
+	if ctx.Value(noRetriesKey{}) != nil {
+		maxRetries = 0
+	}
+	for retry := 0; ; retry++ {
+		attemptCtx, cncl := ctx, context.CancelFunc(func() {})
+		if timeout := policy.GetTimeout(operation); timeout > 0 {
+			attemptCtx, cncl = context.WithTimeout(ctx, timeout)
+		}
+		resp, err := client.Do(req.WithContext(attemptCtx))
+		if retry >= maxRetries || ctx.Err() != nil || !canResend(req) || !policy.isRetriable(operation, resp, err) {
+			err = f(resp, err)
+			cncl()
+			return err
+		}
+		if resp != nil {
+			// Let the connection be reused
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		cncl()
+		select {
+		case <-ctx.Done():
+			return f(nil, ctx.Err())
+		case <-time.After(policy.Backoff(retry)):
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return f(nil, err)
+			}
+			req.Body = body
+		}
+	}
+}
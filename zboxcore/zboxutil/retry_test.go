@@ -0,0 +1,114 @@
+package zboxutil
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOperation(t *testing.T) {
+	tests := []struct {
+		method    string
+		url       string
+		operation string
+	}{
+		{http.MethodGet, "http://b1" + LIST_ENDPOINT + "alloc", OperationList},
+		{http.MethodPost, "http://b1" + UPLOAD_ENDPOINT + "alloc", OperationUpload},
+		{http.MethodDelete, "http://b1" + UPLOAD_ENDPOINT + "alloc", OperationDelete},
+		{http.MethodPost, "http://b1" + COMMIT_ENDPOINT + "alloc", OperationCommit},
+		{http.MethodGet, "http://b1/v1/unknown", OperationOther},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		if got := GetOperation(req); got != tt.operation {
+			t.Errorf("GetOperation(%s %s) = %s, want %s", tt.method, tt.url, got, tt.operation)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, BackoffFactor: 2, Jitter: 0.5}
+	for retry, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second} {
+		backoff := p.Backoff(retry)
+		if backoff > max || backoff < max/2 {
+			t.Errorf("Backoff(%d) = %v, want between %v and %v", retry, backoff, max/2, max)
+		}
+	}
+}
+
+func TestRetryPolicyDeadline(t *testing.T) {
+	p := &RetryPolicy{
+		Timeouts:       map[string]time.Duration{OperationCommit: 2 * time.Second, OperationUpload: 0},
+		DefaultTimeout: time.Second,
+		MaxRetries:     2,
+		InitialBackoff: 100 * time.Millisecond,
+		BackoffFactor:  2,
+		Jitter:         0.5,
+	}
+	// 3 attempts of both requests, and the waits of 100 and 200ms
+	if deadline := p.GetDeadline(OperationReferencePath, OperationCommit); deadline != 9300*time.Millisecond {
+		t.Errorf("GetDeadline() = %v, want 9.3s", deadline)
+	}
+	if deadline := p.GetDeadline(OperationUpload); deadline != 0 {
+		t.Errorf("GetDeadline() of an operation without timeout = %v, want 0", deadline)
+	}
+}
+
+func TestHttpDoRetries(t *testing.T) {
+	defer SetRetryPolicy(nil)
+	tests := []struct {
+		name     string
+		endpoint string
+		status   int
+		attempts int32
+		// Retried by the caller
+		noRetries bool
+	}{
+		{"unavailable", LIST_ENDPOINT, http.StatusServiceUnavailable, 3, false},
+		{"gateway timeout of idempotent", LIST_ENDPOINT, http.StatusGatewayTimeout, 3, false},
+		{"gateway timeout of commit", COMMIT_ENDPOINT, http.StatusGatewayTimeout, 1, false},
+		{"bad request", LIST_ENDPOINT, http.StatusBadRequest, 1, false},
+		{"retried by the caller", LIST_ENDPOINT, http.StatusServiceUnavailable, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetRetryPolicy(&RetryPolicy{DefaultTimeout: time.Second, MaxRetries: 2, InitialBackoff: time.Millisecond, BackoffFactor: 2})
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != "payload" {
+					t.Errorf("attempt %d got body %q", atomic.LoadInt32(&attempts)+1, body)
+				}
+				atomic.AddInt32(&attempts, 1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			req, _ := http.NewRequest(http.MethodPost, server.URL+tt.endpoint+"alloc", strings.NewReader("payload"))
+			ctx, cncl := context.WithCancel(context.Background())
+			defer cncl()
+			if tt.noRetries {
+				ctx = WithoutRetries(ctx)
+			}
+			var status int
+			err := HttpDo(ctx, cncl, req, func(resp *http.Response, err error) error {
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				status = resp.StatusCode
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("HttpDo() failed: %v", err)
+			}
+			if status != tt.status || attempts != tt.attempts {
+				t.Errorf("got status %d after %d attempts, want %d after %d", status, attempts, tt.status, tt.attempts)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package zboxutil
 
 import (
+	"bufio"
 	"crypto/rand"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
 	"path/filepath"
@@ -28,6 +30,22 @@ func GetFileContentType(out *os.File) (string, error) {
 	return kind.MIME.Value, nil
 }
 
+// GetReaderContentType - detects the mime type from the head of the stream
+// without consuming it, so the same reader can be used for the upload.
+func GetReaderContentType(in *bufio.Reader) (string, error) {
+	buffer, err := in.Peek(261)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+
+	kind, _ := filetype.Match(buffer)
+	if kind == filetype.Unknown {
+		return "application/octet-stream", nil
+	}
+
+	return kind.MIME.Value, nil
+}
+
 func GetFullRemotePath(localPath, remotePath string) string {
 	if remotePath == "" || strings.HasSuffix(remotePath, "/") {
 		remotePath = strings.TrimRight(remotePath, "/")
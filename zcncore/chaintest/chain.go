@@ -0,0 +1,406 @@
+package chaintest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/core/encryption"
+	"github.com/0chain/gosdk/core/transaction"
+	"github.com/0chain/gosdk/core/util"
+	"github.com/0chain/gosdk/core/zcncrypto"
+)
+
+const (
+	storageSmartContractAddress      = `6dba10422e368813802877a85039d3985d96760ed844092319743fb3a76712d7`
+	faucetSmartContractAddress       = `6dba10422e368813802877a85039d3985d96760ed844092319743fb3a76712d3`
+	interestPoolSmartContractAddress = `6dba10422e368813802877a85039d3985d96760ed844092319743fb3a76712d9`
+	minerSmartContractAddress        = `6dba10422e368813802877a85039d3985d96760ed844092319743fb3a76712d1`
+)
+
+// Status of the transactions in the confirmations
+const (
+	TxnSuccess = 1
+	TxnFailure = 2
+)
+
+// DefaultFaucetPour - tokens the faucet pours by default, one ZCN
+const DefaultFaucetPour = int64(10000000000)
+
+/*Confirmation - the confirmation of a transaction included into a block, as the sharders return it */
+type Confirmation struct {
+	Version               string                   `json:"version"`
+	Hash                  string                   `json:"hash"`
+	BlockHash             string                   `json:"block_hash"`
+	PreviousBlockHash     string                   `json:"previous_block_hash"`
+	Transaction           *transaction.Transaction `json:"txn,omitempty"`
+	CreationDate          int64                    `json:"creation_date,omitempty"`
+	MinerID               string                   `json:"miner_id"`
+	Round                 int64                    `json:"round"`
+	Status                int                      `json:"transaction_status"`
+	RoundRandomSeed       int64                    `json:"round_random_seed"`
+	MerkleTreeRoot        string                   `json:"merkle_tree_root"`
+	MerkleTreePath        *util.MTPath             `json:"merkle_tree_path"`
+	ReceiptMerkleTreeRoot string                   `json:"receipt_merkle_tree_root"`
+	ReceiptMerkleTreePath *util.MTPath             `json:"receipt_merkle_tree_path"`
+}
+
+type block struct {
+	transaction.RoundBlockHeader
+	PrevHash     string                     `json:"prev_hash"`
+	Transactions []*transaction.Transaction `json:"transactions,omitempty"`
+	status       []int
+	txnTree      *util.MerkleTree
+	receiptTree  *util.MerkleTree
+}
+
+type lockPool struct {
+	ID        string `json:"pool_id"`
+	StartTime int64  `json:"start_time"`
+	Duration  string `json:"duration"`
+	Balance   int64  `json:"balance"`
+	clientID  string
+}
+
+type delegatePool struct {
+	ID       string `json:"pool_id"`
+	MinerID  string `json:"miner_id"`
+	Balance  int64  `json:"balance"`
+	clientID string
+}
+
+type account struct {
+	balance int64
+	txnHash string
+	round   int64
+}
+
+// chain - the ledger and the blocks shared by the miners and the sharders
+type chain struct {
+	config   Config
+	minerIDs []string
+
+	mutex         sync.Mutex
+	blocks        []*block
+	txnBlocks     map[string]*block
+	clients       map[string]string
+	accounts      map[string]*account
+	lockPools     map[string]*lockPool
+	delegatePools map[string]*delegatePool
+}
+
+func newChain(config Config, minerIDs []string) *chain {
+	c := &chain{
+		config:        config,
+		minerIDs:      minerIDs,
+		txnBlocks:     make(map[string]*block),
+		clients:       make(map[string]string),
+		accounts:      make(map[string]*account),
+		lockPools:     make(map[string]*lockPool),
+		delegatePools: make(map[string]*delegatePool),
+	}
+	c.mineBlock(nil, nil)
+	return c
+}
+
+func (c *chain) tip() *block {
+	return c.blocks[len(c.blocks)-1]
+}
+
+// mineBlock - adds a block of the transactions on the tip
+func (c *chain) mineBlock(txns []*transaction.Transaction, status []int) *block {
+	b := &block{Transactions: txns, status: status}
+	b.Version = "1.0"
+	b.Round = int64(len(c.blocks))
+	b.CreationData = common.Now()
+	b.MinerID = c.minerIDs[int(b.Round)%len(c.minerIDs)]
+	b.RoundRandomSeed = rand.Int63()
+	b.NumberOfTxns = int64(len(txns))
+	if len(c.blocks) > 0 {
+		b.PrevHash = c.tip().Hash
+	}
+	if len(txns) > 0 {
+		txnLeaves := make([]util.Hashable, len(txns))
+		receiptLeaves := make([]util.Hashable, len(txns))
+		for i, txn := range txns {
+			txnLeaves[i] = util.NewStringHashable(txn.Hash)
+			receiptLeaves[i] = transaction.NewTransactionReceipt(txn)
+		}
+		b.txnTree = &util.MerkleTree{}
+		b.txnTree.ComputeTree(txnLeaves)
+		b.receiptTree = &util.MerkleTree{}
+		b.receiptTree.ComputeTree(receiptLeaves)
+		b.MerkleTreeRoot = b.txnTree.GetRoot()
+		b.ReceiptMerkleTreeRoot = b.receiptTree.GetRoot()
+	}
+	data := fmt.Sprintf("%v:%v:%v:%v:%v:%v:%v", b.MinerID, b.PrevHash, b.CreationData, b.Round,
+		b.RoundRandomSeed, b.MerkleTreeRoot, b.ReceiptMerkleTreeRoot)
+	b.Hash = encryption.Hash(data)
+	c.blocks = append(c.blocks, b)
+	for _, txn := range txns {
+		c.txnBlocks[txn.Hash] = b
+	}
+	return b
+}
+
+// getBlock - the block of the round, mining empty blocks up to it as the
+// chain would move on while the client waits
+func (c *chain) getBlock(round int64) *block {
+	if round < 0 {
+		return nil
+	}
+	for int64(len(c.blocks)) <= round {
+		c.mineBlock(nil, nil)
+	}
+	return c.blocks[round]
+}
+
+// getConfirmation - the confirmation of the transaction, nil if it isn't in a
+// block
+func (c *chain) getConfirmation(txnHash string) *Confirmation {
+	b, ok := c.txnBlocks[txnHash]
+	if !ok {
+		return nil
+	}
+	idx := 0
+	for i, txn := range b.Transactions {
+		if txn.Hash == txnHash {
+			idx = i
+			break
+		}
+	}
+	txn := b.Transactions[idx]
+	return &Confirmation{
+		Version:               "1.0",
+		Hash:                  encryption.Hash(txn.Hash + ":" + b.Hash),
+		BlockHash:             b.Hash,
+		PreviousBlockHash:     b.PrevHash,
+		Transaction:           txn,
+		CreationDate:          b.CreationData,
+		MinerID:               b.MinerID,
+		Round:                 b.Round,
+		Status:                b.status[idx],
+		RoundRandomSeed:       b.RoundRandomSeed,
+		MerkleTreeRoot:        b.MerkleTreeRoot,
+		MerkleTreePath:        b.txnTree.GetPathByIndex(idx),
+		ReceiptMerkleTreeRoot: b.ReceiptMerkleTreeRoot,
+		ReceiptMerkleTreePath: b.receiptTree.GetPathByIndex(idx),
+	}
+}
+
+// verifyClientKey - checks that the client ID is the hash of the public key
+func verifyClientKey(clientID string, publicKey string) error {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return common.NewError("invalid_public_key", err.Error())
+	}
+	if encryption.Hash(key) != clientID {
+		return common.NewError("invalid_client_id", "Client ID doesn't match the public key")
+	}
+	return nil
+}
+
+// registerClient - registers the public key of the client
+func (c *chain) registerClient(clientID string, publicKey string) error {
+	err := verifyClientKey(clientID, publicKey)
+	if err != nil {
+		return err
+	}
+	c.clients[clientID] = publicKey
+	return nil
+}
+
+// verifyTransaction - checks the hash, the signature and the chain of the
+// transaction
+func (c *chain) verifyTransaction(txn *transaction.Transaction) error {
+	if len(c.config.ChainID) > 0 && txn.ChainID != c.config.ChainID {
+		return common.NewError("invalid_chain_id", "Transaction is for another chain")
+	}
+	publicKey := txn.PublicKey
+	if len(publicKey) == 0 {
+		publicKey = c.clients[txn.ClientID]
+	}
+	if len(publicKey) == 0 {
+		return common.NewError("unknown_client", "Client "+txn.ClientID+" is not registered")
+	}
+	err := verifyClientKey(txn.ClientID, publicKey)
+	if err != nil {
+		return err
+	}
+	verified := *txn
+	ok, err := verified.VerifyTransaction(func(signature, msgHash, _ string) (bool, error) {
+		ss := zcncrypto.NewSignatureScheme(c.config.SignatureScheme)
+		err := ss.SetPublicKey(publicKey)
+		if err != nil {
+			return false, err
+		}
+		return ss.Verify(signature, msgHash)
+	})
+	if err != nil {
+		return common.NewError("invalid_transaction", err.Error())
+	}
+	if !ok {
+		return common.NewError("invalid_signature", "Transaction signature is not valid")
+	}
+	return nil
+}
+
+// addTransaction - executes the transaction and mines it into a block. The
+// transactions already added are accepted again without changes, as the
+// clients submit to several miners.
+func (c *chain) addTransaction(txn *transaction.Transaction) (*transaction.Transaction, error) {
+	if b, ok := c.txnBlocks[txn.Hash]; ok {
+		for _, t := range b.Transactions {
+			if t.Hash == txn.Hash {
+				return t, nil
+			}
+		}
+	}
+	err := c.verifyTransaction(txn)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.clients[txn.ClientID]; !ok {
+		c.clients[txn.ClientID] = txn.PublicKey
+	}
+	status := TxnSuccess
+	output, err := c.execute(txn)
+	if err != nil {
+		status = TxnFailure
+		output = err.Error()
+	}
+	txn.TransactionOutput = output
+	txn.OutputHash = encryption.Hash(output)
+	b := c.mineBlock([]*transaction.Transaction{txn}, []int{status})
+	for _, id := range []string{txn.ClientID, txn.ToClientID} {
+		if acc, ok := c.accounts[id]; ok && acc.round < b.Round {
+			acc.txnHash = txn.Hash
+			acc.round = b.Round
+		}
+	}
+	return txn, nil
+}
+
+func (c *chain) getAccount(clientID string) *account {
+	acc, ok := c.accounts[clientID]
+	if !ok {
+		acc = &account{}
+		c.accounts[clientID] = acc
+	}
+	return acc
+}
+
+// transfer - moves the value between the accounts
+func (c *chain) transfer(from string, to string, value int64) error {
+	if value < 0 {
+		return common.NewError("invalid_value", "Negative value")
+	}
+	if value == 0 {
+		return nil
+	}
+	fromAcc := c.getAccount(from)
+	if fromAcc.balance < value {
+		return common.NewError("insufficient_balance", fmt.Sprintf("Balance %d is less than %d", fromAcc.balance, value))
+	}
+	fromAcc.balance -= value
+	c.getAccount(to).balance += value
+	return nil
+}
+
+// execute - applies the transaction to the ledger, which is left as is when
+// it fails
+func (c *chain) execute(txn *transaction.Transaction) (string, error) {
+	if c.getAccount(txn.ClientID).balance < txn.Value+txn.TransactionFee {
+		return "", common.NewError("insufficient_balance", "Balance doesn't cover the value and the fee")
+	}
+	var output string
+	var err error
+	switch txn.TransactionType {
+	case transaction.TxnTypeSend:
+		err = c.transfer(txn.ClientID, txn.ToClientID, txn.Value)
+		output = "transfer successful"
+	case transaction.TxnTypeData:
+		output = "data stored"
+	case transaction.TxnTypeSmartContract:
+		output, err = c.executeSmartContract(txn)
+	default:
+		err = common.NewError("invalid_transaction_type", fmt.Sprintf("Unsupported transaction type %d", txn.TransactionType))
+	}
+	if err != nil {
+		return "", err
+	}
+	c.getAccount(txn.ClientID).balance -= txn.TransactionFee
+	return output, nil
+}
+
+func (c *chain) executeSmartContract(txn *transaction.Transaction) (string, error) {
+	var scData struct {
+		Name      string                 `json:"name"`
+		InputArgs map[string]interface{} `json:"input"`
+	}
+	err := json.Unmarshal([]byte(txn.TransactionData), &scData)
+	if err != nil {
+		return "", common.NewError("invalid_smart_contract_data", err.Error())
+	}
+	input := func(key string) string {
+		v, _ := scData.InputArgs[key].(string)
+		return v
+	}
+	switch txn.ToClientID + ":" + scData.Name {
+	case faucetSmartContractAddress + ":pour":
+		c.getAccount(faucetSmartContractAddress)
+		c.getAccount(txn.ClientID).balance += c.config.FaucetPour
+		return fmt.Sprintf("%d poured", c.config.FaucetPour), nil
+	case interestPoolSmartContractAddress + ":" + transaction.LOCK_TOKEN:
+		if txn.Value <= 0 {
+			return "", common.NewError("invalid_value", "Nothing to lock")
+		}
+		err = c.transfer(txn.ClientID, interestPoolSmartContractAddress, txn.Value)
+		if err != nil {
+			return "", err
+		}
+		c.lockPools[txn.Hash] = &lockPool{ID: txn.Hash, StartTime: txn.CreationDate, Duration: input("duration"), Balance: txn.Value, clientID: txn.ClientID}
+		return "tokens locked, pool " + txn.Hash, nil
+	case interestPoolSmartContractAddress + ":" + transaction.UNLOCK_TOKEN:
+		pool, ok := c.lockPools[input("pool_id")]
+		if !ok || pool.clientID != txn.ClientID {
+			return "", common.NewError("invalid_pool", "Pool not found")
+		}
+		err = c.transfer(interestPoolSmartContractAddress, txn.ClientID, pool.Balance)
+		if err != nil {
+			return "", err
+		}
+		delete(c.lockPools, pool.ID)
+		return "tokens unlocked, pool " + pool.ID, nil
+	case minerSmartContractAddress + ":" + transaction.STAKE:
+		if txn.Value <= 0 {
+			return "", common.NewError("invalid_value", "Nothing to stake")
+		}
+		err = c.transfer(txn.ClientID, minerSmartContractAddress, txn.Value)
+		if err != nil {
+			return "", err
+		}
+		c.delegatePools[txn.Hash] = &delegatePool{ID: txn.Hash, MinerID: input("id"), Balance: txn.Value, clientID: txn.ClientID}
+		return "tokens staked, pool " + txn.Hash, nil
+	case minerSmartContractAddress + ":" + transaction.DELETE_STAKE:
+		pool, ok := c.delegatePools[input("pool_id")]
+		if !ok || pool.clientID != txn.ClientID || pool.MinerID != input("id") {
+			return "", common.NewError("invalid_pool", "Pool not found")
+		}
+		err = c.transfer(minerSmartContractAddress, txn.ClientID, pool.Balance)
+		if err != nil {
+			return "", err
+		}
+		delete(c.delegatePools, pool.ID)
+		return "stake deleted, pool " + pool.ID, nil
+	}
+	// Other smart contracts keep the value
+	err = c.transfer(txn.ClientID, txn.ToClientID, txn.Value)
+	if err != nil {
+		return "", err
+	}
+	return scData.Name + " executed", nil
+}
@@ -0,0 +1,367 @@
+// Package chaintest - in-process miners and sharders sharing a simple ledger,
+// serving the endpoints zcncore calls, for testing the SDK without a network
+package chaintest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/0chain/gosdk/core/common"
+	"github.com/0chain/gosdk/core/encryption"
+	"github.com/0chain/gosdk/core/transaction"
+)
+
+// Config - the simulated chain
+type Config struct {
+	ChainID         string
+	SignatureScheme string
+	// FaucetPour - tokens the faucet pours per request, DefaultFaucetPour if 0
+	FaucetPour int64
+}
+
+// SCRestHandler - answers a screst request of a smart contract with the
+// object to encode as the response
+type SCRestHandler func(params url.Values) (interface{}, error)
+
+// Node - a miner or a sharder
+type Node struct {
+	ID     string
+	server *httptest.Server
+
+	mutex sync.Mutex
+	down  bool
+}
+
+// URL - base URL of the node
+func (n *Node) URL() string {
+	return n.server.URL
+}
+
+// SetDown - the node answers every request with 503 while down
+func (n *Node) SetDown(down bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.down = down
+}
+
+func (n *Node) isDown() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.down
+}
+
+// Simulator - miners and sharders of one chain. Every transaction the miners
+// accept is executed and mined into a block of its own, and the blocks after
+// the last one are mined on demand, so the confirmations are found right after
+// the submit and the chain always extends them.
+type Simulator struct {
+	Config   Config
+	Miners   []*Node
+	Sharders []*Node
+	chain    *chain
+
+	scMutex sync.Mutex
+	scRest  map[string]SCRestHandler
+}
+
+// NewSimulator - starts the miners and the sharders of the chain, closed by
+// Close
+func NewSimulator(numMiners int, numSharders int, config Config) *Simulator {
+	if config.FaucetPour == 0 {
+		config.FaucetPour = DefaultFaucetPour
+	}
+	s := &Simulator{
+		Config:   config,
+		Miners:   make([]*Node, numMiners),
+		Sharders: make([]*Node, numSharders),
+		scRest:   make(map[string]SCRestHandler),
+	}
+	minerIDs := make([]string, numMiners)
+	for i := range s.Miners {
+		minerIDs[i] = encryption.Hash(fmt.Sprintf("%s:miner:%d", config.ChainID, i))
+		mux := http.NewServeMux()
+		handle(mux, "/v1/client/put", s.registerClientHandler)
+		handle(mux, "/v1/transaction/put", s.putTransactionHandler)
+		s.Miners[i] = newNode(minerIDs[i], mux)
+	}
+	for i := range s.Sharders {
+		mux := http.NewServeMux()
+		handle(mux, "/v1/transaction/get/confirmation", s.confirmationHandler)
+		handle(mux, "/v1/block/get", s.blockHandler)
+		handle(mux, "/v1/client/get/balance", s.balanceHandler)
+		handle(mux, "/v1/screst/", s.scRestHandler)
+		s.Sharders[i] = newNode(encryption.Hash(fmt.Sprintf("%s:sharder:%d", config.ChainID, i)), mux)
+	}
+	s.chain = newChain(config, minerIDs)
+	s.HandleSCRest(interestPoolSmartContractAddress, "/getLockConfig", s.lockConfigHandler)
+	s.HandleSCRest(interestPoolSmartContractAddress, "/getPoolsStats", s.lockedTokensHandler)
+	s.HandleSCRest(minerSmartContractAddress, "/getUserPools", s.userPoolsHandler)
+	s.HandleSCRest(minerSmartContractAddress, "/getPoolsStats", s.userPoolDetailHandler)
+	s.HandleSCRest(storageSmartContractAddress, "/getblobbers", func(params url.Values) (interface{}, error) {
+		return map[string]interface{}{"Nodes": []interface{}{}}, nil
+	})
+	return s
+}
+
+func newNode(id string, mux *http.ServeMux) *Node {
+	n := &Node{ID: id}
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.isDown() {
+			http.Error(w, "node is down", http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return n
+}
+
+// MinerURLs - base URLs of the miners, as InitZCNSDK takes them
+func (s *Simulator) MinerURLs() []string {
+	return nodeURLs(s.Miners)
+}
+
+// SharderURLs - base URLs of the sharders, as InitZCNSDK takes them
+func (s *Simulator) SharderURLs() []string {
+	return nodeURLs(s.Sharders)
+}
+
+func nodeURLs(nodes []*Node) []string {
+	urls := make([]string, len(nodes))
+	for i, n := range nodes {
+		urls[i] = n.URL()
+	}
+	return urls
+}
+
+func (s *Simulator) Close() {
+	for _, n := range append(s.Miners, s.Sharders...) {
+		n.server.Close()
+	}
+}
+
+// Round - round of the latest block
+func (s *Simulator) Round() int64 {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	return s.chain.tip().Round
+}
+
+// Balance - balance of the client, 0 for the unknown clients
+func (s *Simulator) Balance(clientID string) int64 {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	if acc, ok := s.chain.accounts[clientID]; ok {
+		return acc.balance
+	}
+	return 0
+}
+
+// SetBalance - sets the balance of the client, as if the genesis block had
+// given it
+func (s *Simulator) SetBalance(clientID string, balance int64) {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	s.chain.getAccount(clientID).balance = balance
+}
+
+// IsRegistered - whether the miners registered the client
+func (s *Simulator) IsRegistered(clientID string) bool {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	_, ok := s.chain.clients[clientID]
+	return ok
+}
+
+// Confirmation - confirmation of the transaction, nil if it isn't in a block
+func (s *Simulator) Confirmation(txnHash string) *Confirmation {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	return s.chain.getConfirmation(txnHash)
+}
+
+// HandleSCRest - registers the handler of the screst path of the smart
+// contract, replacing the built-in one if any
+func (s *Simulator) HandleSCRest(scAddress string, path string, handler SCRestHandler) {
+	s.scMutex.Lock()
+	defer s.scMutex.Unlock()
+	s.scRest[scAddress+path] = handler
+}
+
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+func handle(mux *http.ServeMux, endpoint string, h handlerFunc) {
+	mux.HandleFunc(endpoint, func(w http.ResponseWriter, r *http.Request) {
+		response, err := h(r)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+func respondError(w http.ResponseWriter, err error) {
+	cerr, ok := err.(*common.Error)
+	if !ok {
+		cerr = common.NewError("internal_error", err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"code": cerr.Code, "error": cerr.Msg})
+}
+
+func (s *Simulator) registerClientHandler(r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, common.NewError("invalid_method", "Use POST to register the client")
+	}
+	var client struct {
+		ID        string `json:"id"`
+		PublicKey string `json:"public_key"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&client)
+	if err != nil {
+		return nil, common.NewError("invalid_request", err.Error())
+	}
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	err = s.chain.registerClient(client.ID, client.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"id": client.ID, "public_key": client.PublicKey, "creation_date": common.Now()}, nil
+}
+
+func (s *Simulator) putTransactionHandler(r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, common.NewError("invalid_method", "Use POST to submit the transaction")
+	}
+	txn := &transaction.Transaction{}
+	err := json.NewDecoder(r.Body).Decode(txn)
+	if err != nil {
+		return nil, common.NewError("invalid_request", err.Error())
+	}
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	txn, err = s.chain.addTransaction(txn)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"async": true, "entity": txn}, nil
+}
+
+func (s *Simulator) confirmationHandler(r *http.Request) (interface{}, error) {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	confirmation := s.chain.getConfirmation(r.FormValue("hash"))
+	if confirmation == nil {
+		return nil, common.NewError("entity_not_found", "Transaction confirmation not found")
+	}
+	if r.FormValue("content") == "lfb" {
+		return map[string]interface{}{"confirmation": confirmation, "latest_finalized_block": s.chain.tip().RoundBlockHeader}, nil
+	}
+	return confirmation, nil
+}
+
+func (s *Simulator) blockHandler(r *http.Request) (interface{}, error) {
+	round, err := strconv.ParseInt(r.FormValue("round"), 10, 64)
+	if err != nil {
+		return nil, common.NewError("invalid_round", "Invalid round "+r.FormValue("round"))
+	}
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	b := s.chain.getBlock(round)
+	if b == nil {
+		return nil, common.NewError("block_not_found", "Block not found")
+	}
+	if r.FormValue("content") == "full" {
+		return map[string]interface{}{"block": b}, nil
+	}
+	return map[string]interface{}{"header": b.RoundBlockHeader}, nil
+}
+
+func (s *Simulator) balanceHandler(r *http.Request) (interface{}, error) {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	acc, ok := s.chain.accounts[r.FormValue("client_id")]
+	if !ok {
+		return nil, common.NewError("value_not_present", "value not present")
+	}
+	return map[string]interface{}{"txn": acc.txnHash, "round": acc.round, "balance": acc.balance}, nil
+}
+
+func (s *Simulator) scRestHandler(r *http.Request) (interface{}, error) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/screst/")
+	s.scMutex.Lock()
+	handler, ok := s.scRest[path]
+	s.scMutex.Unlock()
+	if !ok {
+		return nil, common.NewError("invalid_path", "Unknown screst path "+path)
+	}
+	err := r.ParseForm()
+	if err != nil {
+		return nil, common.NewError("invalid_request", err.Error())
+	}
+	return handler(r.Form)
+}
+
+func (s *Simulator) lockConfigHandler(params url.Values) (interface{}, error) {
+	return map[string]interface{}{
+		"ID": interestPoolSmartContractAddress,
+		"simple_global_node": map[string]interface{}{
+			"max_mint":     4000000000000000,
+			"total_minted": 0,
+			"min_lock":     10,
+			"apr":          0.1,
+		},
+		"min_lock_period": 60000000000,
+	}, nil
+}
+
+func (s *Simulator) lockedTokensHandler(params url.Values) (interface{}, error) {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	stats := make([]*lockPool, 0)
+	for _, pool := range s.chain.lockPools {
+		if pool.clientID == params.Get("client_id") {
+			stats = append(stats, pool)
+		}
+	}
+	if len(stats) == 0 {
+		return nil, common.NewError("get_pools_stats", "no pools for the client")
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return map[string]interface{}{"stats": stats}, nil
+}
+
+func (s *Simulator) userPoolsHandler(params url.Values) (interface{}, error) {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	pools := make(map[string][]string)
+	for _, pool := range s.chain.delegatePools {
+		if pool.clientID == params.Get("client_id") {
+			pools[pool.MinerID] = append(pools[pool.MinerID], pool.ID)
+		}
+	}
+	for _, ids := range pools {
+		sort.Strings(ids)
+	}
+	return map[string]interface{}{"pools": pools}, nil
+}
+
+func (s *Simulator) userPoolDetailHandler(params url.Values) (interface{}, error) {
+	s.chain.mutex.Lock()
+	defer s.chain.mutex.Unlock()
+	pool, ok := s.chain.delegatePools[params.Get("pool_id")]
+	if !ok || pool.MinerID != params.Get("miner_id") {
+		return nil, common.NewError("get_pools_stats", "pool not found")
+	}
+	return pool, nil
+}
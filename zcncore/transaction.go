@@ -220,7 +220,7 @@ func (t *Transaction) submitTxn() {
 		t.completeTxn(StatusError, "", fmt.Errorf("submit transaction failed. %s", tFailureRsp))
 		return
 	}
-	time.Sleep(3 * time.Second)
+	time.Sleep(submitWaitTime)
 	t.completeTxn(StatusSuccess, tSuccessRsp, nil)
 }
 
@@ -0,0 +1,342 @@
+package zcncore
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0chain/gosdk/core/zcncrypto"
+	"github.com/0chain/gosdk/zcncore/chaintest"
+)
+
+const testChainID = "0afc093ffb509f059c55478bc1a60351cef7b4e9c008a53a6cc8241ca8617dfe"
+
+const testTimeout = 10 * time.Second
+
+type walletCallback struct {
+	status chan int
+}
+
+func (cb *walletCallback) OnWalletCreateComplete(status int, wallet string, err string) {
+	cb.status <- status
+}
+
+type txnCallback struct {
+	submit chan int
+	verify chan int
+}
+
+func newTxnCallback() *txnCallback {
+	return &txnCallback{submit: make(chan int, 1), verify: make(chan int, 1)}
+}
+
+func (cb *txnCallback) OnTransactionComplete(t *Transaction, status int) {
+	cb.submit <- status
+}
+
+func (cb *txnCallback) OnVerifyComplete(t *Transaction, status int) {
+	cb.verify <- status
+}
+
+func (cb *txnCallback) OnAuthComplete(t *Transaction, status int) {}
+
+type infoCallback struct {
+	status chan int
+	info   string
+	err    string
+}
+
+func (cb *infoCallback) OnInfoAvailable(op int, status int, info string, err string) {
+	cb.info, cb.err = info, err
+	cb.status <- status
+}
+
+type balanceCallback struct {
+	status chan int
+	value  int64
+}
+
+func (cb *balanceCallback) OnBalanceAvailable(status int, value int64, info string) {
+	cb.value = value
+	cb.status <- status
+}
+
+func waitStatus(t *testing.T, status chan int, what string) int {
+	select {
+	case s := <-status:
+		return s
+	case <-time.After(testTimeout):
+		t.Fatalf("%s timed out", what)
+	}
+	return StatusUnknown
+}
+
+// newTestChain - a chain of 2 miners and 2 sharders, with the SDK initialized
+// on it and a registered wallet set
+func newTestChain(t *testing.T) (*chaintest.Simulator, *zcncrypto.Wallet) {
+	submitWaitTime = 0
+	sim := chaintest.NewSimulator(2, 2, chaintest.Config{ChainID: testChainID, SignatureScheme: "ed25519"})
+	err := InitZCNSDK(sim.MinerURLs(), sim.SharderURLs(), "ed25519", WithChainID(testChainID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet, err := zcncrypto.NewSignatureScheme("ed25519").GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &walletCallback{status: make(chan int, 1)}
+	err = RegisterToMiners(wallet, cb)
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if status := waitStatus(t, cb.status, "register"); status != StatusSuccess {
+		t.Fatalf("register: status %d", status)
+	}
+	walletJSON, err := json.Marshal(wallet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetWalletInfo(string(walletJSON), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim, wallet
+}
+
+// submitAndVerify - submits the transaction and verifies it, returning the
+// confirmation of the verify output
+func submitAndVerify(t *testing.T, fee int64, submit func(txn *Transaction) error) (*Transaction, *confirmation) {
+	cb := newTxnCallback()
+	txn, err := newTransaction(cb, fee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = submit(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitStatus(t, cb.submit, "submit"); status != StatusSuccess {
+		t.Fatalf("submit: status %d, %s", status, txn.GetTransactionError())
+	}
+	err = txn.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitStatus(t, cb.verify, "verify"); status != StatusSuccess {
+		t.Fatalf("verify: status %d, %s", status, txn.GetVerifyError())
+	}
+	var output map[string]json.RawMessage
+	err = json.Unmarshal([]byte(txn.GetVerifyOutput()), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfm := &confirmation{}
+	err = json.Unmarshal(output["confirmation"], cfm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txn, cfm
+}
+
+func pour(t *testing.T) {
+	_, cfm := submitAndVerify(t, 0, func(txn *Transaction) error {
+		return txn.ExecuteSmartContract(FaucetSmartContractAddress, "pour", "{}", 0)
+	})
+	if cfm.Status != chaintest.TxnSuccess {
+		t.Fatalf("pour: status %d", cfm.Status)
+	}
+}
+
+func getBalance(t *testing.T) (int, int64) {
+	cb := &balanceCallback{status: make(chan int, 1)}
+	err := GetBalance(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return waitStatus(t, cb.status, "balance"), cb.value
+}
+
+func TestRegisterToMiners(t *testing.T) {
+	sim, wallet := newTestChain(t)
+	defer sim.Close()
+	if !sim.IsRegistered(wallet.ClientID) {
+		t.Fatal("miners didn't register the wallet")
+	}
+
+	other, err := zcncrypto.NewSignatureScheme("ed25519").GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, miner := range sim.Miners {
+		miner.SetDown(true)
+	}
+	err = RegisterToMiners(other, &walletCallback{status: make(chan int, 1)})
+	if err == nil {
+		t.Fatal("register succeeded with the miners down")
+	}
+	if sim.IsRegistered(other.ClientID) {
+		t.Fatal("miners down registered the wallet")
+	}
+}
+
+func TestSendAndVerify(t *testing.T) {
+	sim, wallet := newTestChain(t)
+	defer sim.Close()
+	if status, _ := getBalance(t); status != StatusError {
+		t.Fatalf("balance of a new wallet: status %d", status)
+	}
+
+	pour(t)
+	status, balance := getBalance(t)
+	if status != StatusSuccess || balance != chaintest.DefaultFaucetPour {
+		t.Fatalf("balance after pour: status %d, balance %d", status, balance)
+	}
+
+	to := "receiver"
+	_, cfm := submitAndVerify(t, 10, func(txn *Transaction) error {
+		return txn.Send(to, 1000, "test send")
+	})
+	if cfm.Status != chaintest.TxnSuccess || cfm.Transaction.ClientID != wallet.ClientID {
+		t.Fatalf("send: status %d", cfm.Status)
+	}
+	if sim.Balance(to) != 1000 {
+		t.Fatalf("receiver balance %d", sim.Balance(to))
+	}
+	status, balance = getBalance(t)
+	if status != StatusSuccess || balance != chaintest.DefaultFaucetPour-1010 {
+		t.Fatalf("balance after send: status %d, balance %d", status, balance)
+	}
+
+	// Confirmed too, but as failed and without changing the balances
+	_, cfm = submitAndVerify(t, 0, func(txn *Transaction) error {
+		return txn.Send(to, 2*chaintest.DefaultFaucetPour, "overdraft")
+	})
+	if cfm.Status != chaintest.TxnFailure {
+		t.Fatalf("overdraft: status %d", cfm.Status)
+	}
+	if sim.Balance(to) != 1000 || sim.Balance(wallet.ClientID) != chaintest.DefaultFaucetPour-1010 {
+		t.Fatal("failed transaction changed the balances")
+	}
+}
+
+func TestConfirmationValidation(t *testing.T) {
+	sim, _ := newTestChain(t)
+	defer sim.Close()
+	pour(t)
+	_, cfm := submitAndVerify(t, 0, func(txn *Transaction) error {
+		return txn.StoreData("data")
+	})
+
+	confirmationOf := func(tamper func(cfm *chaintest.Confirmation)) map[string]json.RawMessage {
+		c := sim.Confirmation(cfm.Transaction.Hash)
+		if tamper != nil {
+			tamper(c)
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return map[string]json.RawMessage{"confirmation": data}
+	}
+	block, err := getBlockHeaderFromTransactionConfirmation(cfm.Transaction.Hash, confirmationOf(nil))
+	if err != nil {
+		t.Fatalf("valid confirmation: %v", err)
+	}
+	if block.Hash != cfm.BlockHash || block.Round != cfm.Round {
+		t.Fatalf("unexpected block %+v", block)
+	}
+	if !validateChain(block) {
+		t.Fatal("chain doesn't extend the block")
+	}
+	if sim.Round() < block.Round+getMinRequiredChainLength()-1 {
+		t.Fatalf("chain validated at round %d, block round %d", sim.Round(), block.Round)
+	}
+
+	_, err = getBlockHeaderFromTransactionConfirmation("other", confirmationOf(nil))
+	if err == nil {
+		t.Fatal("confirmation of another transaction accepted")
+	}
+	tampered := map[string]func(cfm *chaintest.Confirmation){
+		"merkle root": func(cfm *chaintest.Confirmation) {
+			cfm.MerkleTreeRoot = cfm.ReceiptMerkleTreeRoot
+		},
+		"receipt": func(cfm *chaintest.Confirmation) {
+			cfm.Transaction.OutputHash = cfm.Transaction.Hash
+		},
+		"previous block": func(cfm *chaintest.Confirmation) {
+			cfm.PreviousBlockHash = cfm.BlockHash
+		},
+		"round": func(cfm *chaintest.Confirmation) {
+			cfm.Round++
+		},
+	}
+	for name, tamper := range tampered {
+		_, err = getBlockHeaderFromTransactionConfirmation(cfm.Transaction.Hash, confirmationOf(tamper))
+		if err == nil {
+			t.Fatalf("confirmation with a tampered %s accepted", name)
+		}
+	}
+}
+
+func TestLockAndStakeInfo(t *testing.T) {
+	sim, _ := newTestChain(t)
+	defer sim.Close()
+	pour(t)
+
+	cb := &infoCallback{status: make(chan int, 1)}
+	err := GetLockConfig(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitStatus(t, cb.status, "lock config"); status != StatusSuccess || !strings.Contains(cb.info, InterestPoolSmartContractAddress) {
+		t.Fatalf("lock config: status %d, %s %s", status, cb.info, cb.err)
+	}
+
+	lock, cfm := submitAndVerify(t, 0, func(txn *Transaction) error {
+		return txn.LockTokens(TOKEN_UNIT/2, 1, 0)
+	})
+	if cfm.Status != chaintest.TxnSuccess {
+		t.Fatalf("lock: status %d, %s", cfm.Status, cfm.Transaction.TransactionOutput)
+	}
+	err = GetLockedTokens(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitStatus(t, cb.status, "locked tokens"); status != StatusSuccess || !strings.Contains(cb.info, lock.GetTransactionHash()) {
+		t.Fatalf("locked tokens: status %d, %s %s", status, cb.info, cb.err)
+	}
+
+	miner := sim.Miners[0].ID
+	stake, cfm := submitAndVerify(t, 0, func(txn *Transaction) error {
+		return txn.Stake(miner, TOKEN_UNIT/4)
+	})
+	if cfm.Status != chaintest.TxnSuccess {
+		t.Fatalf("stake: status %d, %s", cfm.Status, cfm.Transaction.TransactionOutput)
+	}
+	err = GetUserPoolDetails(miner, stake.GetTransactionHash(), cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitStatus(t, cb.status, "pool detail"); status != StatusSuccess {
+		t.Fatalf("pool detail: status %d, %s", status, cb.err)
+	}
+
+	_, cfm = submitAndVerify(t, 0, func(txn *Transaction) error {
+		return txn.UnlockTokens(lock.GetTransactionHash())
+	})
+	if cfm.Status != chaintest.TxnSuccess {
+		t.Fatalf("unlock: status %d, %s", cfm.Status, cfm.Transaction.TransactionOutput)
+	}
+	err = GetLockedTokens(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitStatus(t, cb.status, "locked tokens"); status != StatusError {
+		t.Fatalf("locked tokens after unlock: status %d, %s", status, cb.info)
+	}
+	status, balance := getBalance(t)
+	if status != StatusSuccess || balance != chaintest.DefaultFaucetPour-TOKEN_UNIT/4 {
+		t.Fatalf("balance: status %d, balance %d", status, balance)
+	}
+}
@@ -56,6 +56,10 @@ const defaultConfirmationChainLength = int(5)
 const defaultTxnExpirationSeconds = 15
 const defaultWaitSeconds = (3 * time.Second)
 
+// submitWaitTime - time given to the miners to pick up a submitted request
+// before its callback is called
+var submitWaitTime = defaultWaitSeconds
+
 const (
 	StatusSuccess      int = 0
 	StatusNetworkError int = 1
@@ -253,6 +257,15 @@ func WithConfirmationChainLength(m int) func(c *ChainConfig) error {
 	}
 }
 
+// WithHTTPClient sets the client the requests to the miners and the sharders
+// are sent with.
+func WithHTTPClient(client *http.Client) func(c *ChainConfig) error {
+	return func(c *ChainConfig) error {
+		util.SetHTTPClient(client)
+		return nil
+	}
+}
+
 // InitZCNSDK initializes the SDK with miner, sharder and signature scheme provided.
 func InitZCNSDK(miners []string, sharders []string, signscheme string, configs ...func(*ChainConfig) error) error {
 	if signscheme != "ed25519" && signscheme != "bls0chain" {
@@ -381,7 +394,7 @@ func RegisterToMiners(wallet *zcncrypto.Wallet, statusCb WalletCallback) error {
 	if err != nil {
 		return fmt.Errorf("wallet encoding failed - %s", err.Error())
 	}
-	time.Sleep(3 * time.Second)
+	time.Sleep(submitWaitTime)
 	statusCb.OnWalletCreateComplete(StatusSuccess, w, "")
 	return nil
 }